@@ -0,0 +1,1495 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: api/mcpshim/v1/control.proto
+
+package mcpshimv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{0}
+}
+
+type StatusResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	StartedAt   *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	UptimeSec   int64                  `protobuf:"varint,2,opt,name=uptime_sec,json=uptimeSec,proto3" json:"uptime_sec,omitempty"`
+	ServerCount int32                  `protobuf:"varint,3,opt,name=server_count,json=serverCount,proto3" json:"server_count,omitempty"`
+	ToolCount   int32                  `protobuf:"varint,4,opt,name=tool_count,json=toolCount,proto3" json:"tool_count,omitempty"`
+	LastRefresh *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=last_refresh,json=lastRefresh,proto3" json:"last_refresh,omitempty"`
+	// servers with a cached tool list, out of server_count
+	CachedServerCount int32    `protobuf:"varint,6,opt,name=cached_server_count,json=cachedServerCount,proto3" json:"cached_server_count,omitempty"`
+	OpenCircuits      []string `protobuf:"bytes,7,rep,name=open_circuits,json=openCircuits,proto3" json:"open_circuits,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StatusResponse) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetUptimeSec() int64 {
+	if x != nil {
+		return x.UptimeSec
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetServerCount() int32 {
+	if x != nil {
+		return x.ServerCount
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetToolCount() int32 {
+	if x != nil {
+		return x.ToolCount
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetLastRefresh() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastRefresh
+	}
+	return nil
+}
+
+func (x *StatusResponse) GetCachedServerCount() int32 {
+	if x != nil {
+		return x.CachedServerCount
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetOpenCircuits() []string {
+	if x != nil {
+		return x.OpenCircuits
+	}
+	return nil
+}
+
+type ServersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServersRequest) Reset() {
+	*x = ServersRequest{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServersRequest) ProtoMessage() {}
+
+func (x *ServersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServersRequest.ProtoReflect.Descriptor instead.
+func (*ServersRequest) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{2}
+}
+
+type ServersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Servers       []*ServerInfo          `protobuf:"bytes,1,rep,name=servers,proto3" json:"servers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServersResponse) Reset() {
+	*x = ServersResponse{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServersResponse) ProtoMessage() {}
+
+func (x *ServersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServersResponse.ProtoReflect.Descriptor instead.
+func (*ServersResponse) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ServersResponse) GetServers() []*ServerInfo {
+	if x != nil {
+		return x.Servers
+	}
+	return nil
+}
+
+// env is left out on purpose: its values are often secrets
+type ServerInfo struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	Name                string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Alias               string                 `protobuf:"bytes,2,opt,name=alias,proto3" json:"alias,omitempty"`
+	Url                 string                 `protobuf:"bytes,3,opt,name=url,proto3" json:"url,omitempty"`
+	Transport           string                 `protobuf:"bytes,4,opt,name=transport,proto3" json:"transport,omitempty"`
+	HasAuth             bool                   `protobuf:"varint,5,opt,name=has_auth,json=hasAuth,proto3" json:"has_auth,omitempty"`
+	Command             []string               `protobuf:"bytes,6,rep,name=command,proto3" json:"command,omitempty"`
+	Disabled            bool                   `protobuf:"varint,7,opt,name=disabled,proto3" json:"disabled,omitempty"`
+	LastRefresh         *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=last_refresh,json=lastRefresh,proto3" json:"last_refresh,omitempty"`
+	ImplName            string                 `protobuf:"bytes,9,opt,name=impl_name,json=implName,proto3" json:"impl_name,omitempty"`
+	ImplVersion         string                 `protobuf:"bytes,10,opt,name=impl_version,json=implVersion,proto3" json:"impl_version,omitempty"`
+	ProtocolVersion     string                 `protobuf:"bytes,11,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Instructions        string                 `protobuf:"bytes,12,opt,name=instructions,proto3" json:"instructions,omitempty"`
+	Circuit             string                 `protobuf:"bytes,13,opt,name=circuit,proto3" json:"circuit,omitempty"`
+	ConsecutiveFailures int32                  `protobuf:"varint,14,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *ServerInfo) Reset() {
+	*x = ServerInfo{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerInfo) ProtoMessage() {}
+
+func (x *ServerInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerInfo.ProtoReflect.Descriptor instead.
+func (*ServerInfo) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ServerInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetTransport() string {
+	if x != nil {
+		return x.Transport
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetHasAuth() bool {
+	if x != nil {
+		return x.HasAuth
+	}
+	return false
+}
+
+func (x *ServerInfo) GetCommand() []string {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+func (x *ServerInfo) GetDisabled() bool {
+	if x != nil {
+		return x.Disabled
+	}
+	return false
+}
+
+func (x *ServerInfo) GetLastRefresh() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastRefresh
+	}
+	return nil
+}
+
+func (x *ServerInfo) GetImplName() string {
+	if x != nil {
+		return x.ImplName
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetImplVersion() string {
+	if x != nil {
+		return x.ImplVersion
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetProtocolVersion() string {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetInstructions() string {
+	if x != nil {
+		return x.Instructions
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetCircuit() string {
+	if x != nil {
+		return x.Circuit
+	}
+	return ""
+}
+
+func (x *ServerInfo) GetConsecutiveFailures() int32 {
+	if x != nil {
+		return x.ConsecutiveFailures
+	}
+	return 0
+}
+
+type ToolsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// empty lists the tools of every server
+	Server        string `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ToolsRequest) Reset() {
+	*x = ToolsRequest{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ToolsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ToolsRequest) ProtoMessage() {}
+
+func (x *ToolsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ToolsRequest.ProtoReflect.Descriptor instead.
+func (*ToolsRequest) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ToolsRequest) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+type ToolsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Tools []*ToolInfo            `protobuf:"bytes,1,rep,name=tools,proto3" json:"tools,omitempty"`
+	// set when the list came from the cache because the server was unreachable
+	RefreshedAt   *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=refreshed_at,json=refreshedAt,proto3" json:"refreshed_at,omitempty"`
+	Stale         bool                   `protobuf:"varint,3,opt,name=stale,proto3" json:"stale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ToolsResponse) Reset() {
+	*x = ToolsResponse{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ToolsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ToolsResponse) ProtoMessage() {}
+
+func (x *ToolsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ToolsResponse.ProtoReflect.Descriptor instead.
+func (*ToolsResponse) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ToolsResponse) GetTools() []*ToolInfo {
+	if x != nil {
+		return x.Tools
+	}
+	return nil
+}
+
+func (x *ToolsResponse) GetRefreshedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RefreshedAt
+	}
+	return nil
+}
+
+func (x *ToolsResponse) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+type ToolInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Server        string                 `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Description   string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Required      []string               `protobuf:"bytes,4,rep,name=required,proto3" json:"required,omitempty"`
+	Properties    []string               `protobuf:"bytes,5,rep,name=properties,proto3" json:"properties,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ToolInfo) Reset() {
+	*x = ToolInfo{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ToolInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ToolInfo) ProtoMessage() {}
+
+func (x *ToolInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ToolInfo.ProtoReflect.Descriptor instead.
+func (*ToolInfo) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ToolInfo) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *ToolInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ToolInfo) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *ToolInfo) GetRequired() []string {
+	if x != nil {
+		return x.Required
+	}
+	return nil
+}
+
+func (x *ToolInfo) GetProperties() []string {
+	if x != nil {
+		return x.Properties
+	}
+	return nil
+}
+
+type InspectRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Server        string                 `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	Tool          string                 `protobuf:"bytes,2,opt,name=tool,proto3" json:"tool,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InspectRequest) Reset() {
+	*x = InspectRequest{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InspectRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InspectRequest) ProtoMessage() {}
+
+func (x *InspectRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InspectRequest.ProtoReflect.Descriptor instead.
+func (*InspectRequest) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *InspectRequest) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *InspectRequest) GetTool() string {
+	if x != nil {
+		return x.Tool
+	}
+	return ""
+}
+
+type InspectResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tool          *ToolDetail            `protobuf:"bytes,1,opt,name=tool,proto3" json:"tool,omitempty"`
+	RefreshedAt   *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=refreshed_at,json=refreshedAt,proto3" json:"refreshed_at,omitempty"`
+	Stale         bool                   `protobuf:"varint,3,opt,name=stale,proto3" json:"stale,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InspectResponse) Reset() {
+	*x = InspectResponse{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InspectResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InspectResponse) ProtoMessage() {}
+
+func (x *InspectResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InspectResponse.ProtoReflect.Descriptor instead.
+func (*InspectResponse) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *InspectResponse) GetTool() *ToolDetail {
+	if x != nil {
+		return x.Tool
+	}
+	return nil
+}
+
+func (x *InspectResponse) GetRefreshedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RefreshedAt
+	}
+	return nil
+}
+
+func (x *InspectResponse) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
+type ToolDetail struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Server      string                 `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Description string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Properties  []*PropertyDetail      `protobuf:"bytes,4,rep,name=properties,proto3" json:"properties,omitempty"`
+	// fields of the structured result, from the tool's outputSchema
+	OutputProperties []*PropertyDetail `protobuf:"bytes,5,rep,name=output_properties,json=outputProperties,proto3" json:"output_properties,omitempty"`
+	// vendor extension data from the tool's _meta, passed through as-is
+	Meta          *structpb.Struct `protobuf:"bytes,6,opt,name=meta,proto3" json:"meta,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ToolDetail) Reset() {
+	*x = ToolDetail{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ToolDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ToolDetail) ProtoMessage() {}
+
+func (x *ToolDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ToolDetail.ProtoReflect.Descriptor instead.
+func (*ToolDetail) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ToolDetail) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *ToolDetail) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ToolDetail) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *ToolDetail) GetProperties() []*PropertyDetail {
+	if x != nil {
+		return x.Properties
+	}
+	return nil
+}
+
+func (x *ToolDetail) GetOutputProperties() []*PropertyDetail {
+	if x != nil {
+		return x.OutputProperties
+	}
+	return nil
+}
+
+func (x *ToolDetail) GetMeta() *structpb.Struct {
+	if x != nil {
+		return x.Meta
+	}
+	return nil
+}
+
+type PropertyDetail struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Name        string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type        string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Enum        []string               `protobuf:"bytes,3,rep,name=enum,proto3" json:"enum,omitempty"`
+	Const       string                 `protobuf:"bytes,4,opt,name=const,proto3" json:"const,omitempty"`
+	Description string                 `protobuf:"bytes,5,opt,name=description,proto3" json:"description,omitempty"`
+	Required    bool                   `protobuf:"varint,6,opt,name=required,proto3" json:"required,omitempty"`
+	// element type of an array, such as "string" or "object"
+	Items string `protobuf:"bytes,7,opt,name=items,proto3" json:"items,omitempty"`
+	// fields of an object, or of each element of an array of objects
+	Properties    []*PropertyDetail `protobuf:"bytes,8,rep,name=properties,proto3" json:"properties,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PropertyDetail) Reset() {
+	*x = PropertyDetail{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PropertyDetail) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PropertyDetail) ProtoMessage() {}
+
+func (x *PropertyDetail) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PropertyDetail.ProtoReflect.Descriptor instead.
+func (*PropertyDetail) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *PropertyDetail) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *PropertyDetail) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *PropertyDetail) GetEnum() []string {
+	if x != nil {
+		return x.Enum
+	}
+	return nil
+}
+
+func (x *PropertyDetail) GetConst() string {
+	if x != nil {
+		return x.Const
+	}
+	return ""
+}
+
+func (x *PropertyDetail) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *PropertyDetail) GetRequired() bool {
+	if x != nil {
+		return x.Required
+	}
+	return false
+}
+
+func (x *PropertyDetail) GetItems() string {
+	if x != nil {
+		return x.Items
+	}
+	return ""
+}
+
+func (x *PropertyDetail) GetProperties() []*PropertyDetail {
+	if x != nil {
+		return x.Properties
+	}
+	return nil
+}
+
+// only configured servers can be called; there are no url or command
+// fields, so ad-hoc servers stay on the unix socket
+type CallRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Server string                 `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	Tool   string                 `protobuf:"bytes,2,opt,name=tool,proto3" json:"tool,omitempty"`
+	Args   *structpb.Struct       `protobuf:"bytes,3,opt,name=args,proto3" json:"args,omitempty"`
+	// optional id for cancelling the call over the socket; generated if empty
+	Id            string `protobuf:"bytes,4,opt,name=id,proto3" json:"id,omitempty"`
+	NoCache       bool   `protobuf:"varint,5,opt,name=no_cache,json=noCache,proto3" json:"no_cache,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CallRequest) Reset() {
+	*x = CallRequest{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CallRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CallRequest) ProtoMessage() {}
+
+func (x *CallRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CallRequest.ProtoReflect.Descriptor instead.
+func (*CallRequest) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *CallRequest) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *CallRequest) GetTool() string {
+	if x != nil {
+		return x.Tool
+	}
+	return ""
+}
+
+func (x *CallRequest) GetArgs() *structpb.Struct {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *CallRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CallRequest) GetNoCache() bool {
+	if x != nil {
+		return x.NoCache
+	}
+	return false
+}
+
+type CallResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// the mcp CallToolResult as sent by the server
+	Result        *structpb.Value `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	CallId        string          `protobuf:"bytes,2,opt,name=call_id,json=callId,proto3" json:"call_id,omitempty"`
+	Cached        bool            `protobuf:"varint,3,opt,name=cached,proto3" json:"cached,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CallResponse) Reset() {
+	*x = CallResponse{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CallResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CallResponse) ProtoMessage() {}
+
+func (x *CallResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CallResponse.ProtoReflect.Descriptor instead.
+func (*CallResponse) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *CallResponse) GetResult() *structpb.Value {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *CallResponse) GetCallId() string {
+	if x != nil {
+		return x.CallId
+	}
+	return ""
+}
+
+func (x *CallResponse) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+type HistoryRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Server string                 `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	Tool   string                 `protobuf:"bytes,2,opt,name=tool,proto3" json:"tool,omitempty"`
+	// defaults to 50
+	Limit         int32 `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	BeforeId      int64 `protobuf:"varint,4,opt,name=before_id,json=beforeId,proto3" json:"before_id,omitempty"`
+	AfterId       int64 `protobuf:"varint,5,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	Offset        int32 `protobuf:"varint,6,opt,name=offset,proto3" json:"offset,omitempty"`
+	WithTotal     bool  `protobuf:"varint,7,opt,name=with_total,json=withTotal,proto3" json:"with_total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistoryRequest) Reset() {
+	*x = HistoryRequest{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryRequest) ProtoMessage() {}
+
+func (x *HistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryRequest.ProtoReflect.Descriptor instead.
+func (*HistoryRequest) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *HistoryRequest) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *HistoryRequest) GetTool() string {
+	if x != nil {
+		return x.Tool
+	}
+	return ""
+}
+
+func (x *HistoryRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *HistoryRequest) GetBeforeId() int64 {
+	if x != nil {
+		return x.BeforeId
+	}
+	return 0
+}
+
+func (x *HistoryRequest) GetAfterId() int64 {
+	if x != nil {
+		return x.AfterId
+	}
+	return 0
+}
+
+func (x *HistoryRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *HistoryRequest) GetWithTotal() bool {
+	if x != nil {
+		return x.WithTotal
+	}
+	return false
+}
+
+type HistoryResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	History []*HistoryItem         `protobuf:"bytes,1,rep,name=history,proto3" json:"history,omitempty"`
+	// only set when with_total was requested
+	Total         int64 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistoryResponse) Reset() {
+	*x = HistoryResponse{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryResponse) ProtoMessage() {}
+
+func (x *HistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryResponse.ProtoReflect.Descriptor instead.
+func (*HistoryResponse) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *HistoryResponse) GetHistory() []*HistoryItem {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+func (x *HistoryResponse) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+type HistoryItem struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	At            *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=at,proto3" json:"at,omitempty"`
+	Server        string                 `protobuf:"bytes,3,opt,name=server,proto3" json:"server,omitempty"`
+	Tool          string                 `protobuf:"bytes,4,opt,name=tool,proto3" json:"tool,omitempty"`
+	Args          *structpb.Struct       `protobuf:"bytes,5,opt,name=args,proto3" json:"args,omitempty"`
+	ArgsTruncated bool                   `protobuf:"varint,6,opt,name=args_truncated,json=argsTruncated,proto3" json:"args_truncated,omitempty"`
+	Success       bool                   `protobuf:"varint,7,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	DurationMs    int64                  `protobuf:"varint,9,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	ConnectMs     int64                  `protobuf:"varint,10,opt,name=connect_ms,json=connectMs,proto3" json:"connect_ms,omitempty"`
+	CallMs        int64                  `protobuf:"varint,11,opt,name=call_ms,json=callMs,proto3" json:"call_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HistoryItem) Reset() {
+	*x = HistoryItem{}
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HistoryItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HistoryItem) ProtoMessage() {}
+
+func (x *HistoryItem) ProtoReflect() protoreflect.Message {
+	mi := &file_api_mcpshim_v1_control_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HistoryItem.ProtoReflect.Descriptor instead.
+func (*HistoryItem) Descriptor() ([]byte, []int) {
+	return file_api_mcpshim_v1_control_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *HistoryItem) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *HistoryItem) GetAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.At
+	}
+	return nil
+}
+
+func (x *HistoryItem) GetServer() string {
+	if x != nil {
+		return x.Server
+	}
+	return ""
+}
+
+func (x *HistoryItem) GetTool() string {
+	if x != nil {
+		return x.Tool
+	}
+	return ""
+}
+
+func (x *HistoryItem) GetArgs() *structpb.Struct {
+	if x != nil {
+		return x.Args
+	}
+	return nil
+}
+
+func (x *HistoryItem) GetArgsTruncated() bool {
+	if x != nil {
+		return x.ArgsTruncated
+	}
+	return false
+}
+
+func (x *HistoryItem) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *HistoryItem) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *HistoryItem) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *HistoryItem) GetConnectMs() int64 {
+	if x != nil {
+		return x.ConnectMs
+	}
+	return 0
+}
+
+func (x *HistoryItem) GetCallMs() int64 {
+	if x != nil {
+		return x.CallMs
+	}
+	return 0
+}
+
+var File_api_mcpshim_v1_control_proto protoreflect.FileDescriptor
+
+const file_api_mcpshim_v1_control_proto_rawDesc = "" +
+	"\n" +
+	"\x1capi/mcpshim/v1/control.proto\x12\n" +
+	"mcpshim.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x0f\n" +
+	"\rStatusRequest\"\xc0\x02\n" +
+	"\x0eStatusResponse\x129\n" +
+	"\n" +
+	"started_at\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12\x1d\n" +
+	"\n" +
+	"uptime_sec\x18\x02 \x01(\x03R\tuptimeSec\x12!\n" +
+	"\fserver_count\x18\x03 \x01(\x05R\vserverCount\x12\x1d\n" +
+	"\n" +
+	"tool_count\x18\x04 \x01(\x05R\ttoolCount\x12=\n" +
+	"\flast_refresh\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\vlastRefresh\x12.\n" +
+	"\x13cached_server_count\x18\x06 \x01(\x05R\x11cachedServerCount\x12#\n" +
+	"\ropen_circuits\x18\a \x03(\tR\fopenCircuits\"\x10\n" +
+	"\x0eServersRequest\"C\n" +
+	"\x0fServersResponse\x120\n" +
+	"\aservers\x18\x01 \x03(\v2\x16.mcpshim.v1.ServerInfoR\aservers\"\xd2\x03\n" +
+	"\n" +
+	"ServerInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05alias\x18\x02 \x01(\tR\x05alias\x12\x10\n" +
+	"\x03url\x18\x03 \x01(\tR\x03url\x12\x1c\n" +
+	"\ttransport\x18\x04 \x01(\tR\ttransport\x12\x19\n" +
+	"\bhas_auth\x18\x05 \x01(\bR\ahasAuth\x12\x18\n" +
+	"\acommand\x18\x06 \x03(\tR\acommand\x12\x1a\n" +
+	"\bdisabled\x18\a \x01(\bR\bdisabled\x12=\n" +
+	"\flast_refresh\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\vlastRefresh\x12\x1b\n" +
+	"\timpl_name\x18\t \x01(\tR\bimplName\x12!\n" +
+	"\fimpl_version\x18\n" +
+	" \x01(\tR\vimplVersion\x12)\n" +
+	"\x10protocol_version\x18\v \x01(\tR\x0fprotocolVersion\x12\"\n" +
+	"\finstructions\x18\f \x01(\tR\finstructions\x12\x18\n" +
+	"\acircuit\x18\r \x01(\tR\acircuit\x121\n" +
+	"\x14consecutive_failures\x18\x0e \x01(\x05R\x13consecutiveFailures\"&\n" +
+	"\fToolsRequest\x12\x16\n" +
+	"\x06server\x18\x01 \x01(\tR\x06server\"\x90\x01\n" +
+	"\rToolsResponse\x12*\n" +
+	"\x05tools\x18\x01 \x03(\v2\x14.mcpshim.v1.ToolInfoR\x05tools\x12=\n" +
+	"\frefreshed_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\vrefreshedAt\x12\x14\n" +
+	"\x05stale\x18\x03 \x01(\bR\x05stale\"\x94\x01\n" +
+	"\bToolInfo\x12\x16\n" +
+	"\x06server\x18\x01 \x01(\tR\x06server\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12\x1a\n" +
+	"\brequired\x18\x04 \x03(\tR\brequired\x12\x1e\n" +
+	"\n" +
+	"properties\x18\x05 \x03(\tR\n" +
+	"properties\"<\n" +
+	"\x0eInspectRequest\x12\x16\n" +
+	"\x06server\x18\x01 \x01(\tR\x06server\x12\x12\n" +
+	"\x04tool\x18\x02 \x01(\tR\x04tool\"\x92\x01\n" +
+	"\x0fInspectResponse\x12*\n" +
+	"\x04tool\x18\x01 \x01(\v2\x16.mcpshim.v1.ToolDetailR\x04tool\x12=\n" +
+	"\frefreshed_at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\vrefreshedAt\x12\x14\n" +
+	"\x05stale\x18\x03 \x01(\bR\x05stale\"\x8c\x02\n" +
+	"\n" +
+	"ToolDetail\x12\x16\n" +
+	"\x06server\x18\x01 \x01(\tR\x06server\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12:\n" +
+	"\n" +
+	"properties\x18\x04 \x03(\v2\x1a.mcpshim.v1.PropertyDetailR\n" +
+	"properties\x12G\n" +
+	"\x11output_properties\x18\x05 \x03(\v2\x1a.mcpshim.v1.PropertyDetailR\x10outputProperties\x12+\n" +
+	"\x04meta\x18\x06 \x01(\v2\x17.google.protobuf.StructR\x04meta\"\xf2\x01\n" +
+	"\x0ePropertyDetail\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x12\n" +
+	"\x04enum\x18\x03 \x03(\tR\x04enum\x12\x14\n" +
+	"\x05const\x18\x04 \x01(\tR\x05const\x12 \n" +
+	"\vdescription\x18\x05 \x01(\tR\vdescription\x12\x1a\n" +
+	"\brequired\x18\x06 \x01(\bR\brequired\x12\x14\n" +
+	"\x05items\x18\a \x01(\tR\x05items\x12:\n" +
+	"\n" +
+	"properties\x18\b \x03(\v2\x1a.mcpshim.v1.PropertyDetailR\n" +
+	"properties\"\x91\x01\n" +
+	"\vCallRequest\x12\x16\n" +
+	"\x06server\x18\x01 \x01(\tR\x06server\x12\x12\n" +
+	"\x04tool\x18\x02 \x01(\tR\x04tool\x12+\n" +
+	"\x04args\x18\x03 \x01(\v2\x17.google.protobuf.StructR\x04args\x12\x0e\n" +
+	"\x02id\x18\x04 \x01(\tR\x02id\x12\x19\n" +
+	"\bno_cache\x18\x05 \x01(\bR\anoCache\"o\n" +
+	"\fCallResponse\x12.\n" +
+	"\x06result\x18\x01 \x01(\v2\x16.google.protobuf.ValueR\x06result\x12\x17\n" +
+	"\acall_id\x18\x02 \x01(\tR\x06callId\x12\x16\n" +
+	"\x06cached\x18\x03 \x01(\bR\x06cached\"\xc1\x01\n" +
+	"\x0eHistoryRequest\x12\x16\n" +
+	"\x06server\x18\x01 \x01(\tR\x06server\x12\x12\n" +
+	"\x04tool\x18\x02 \x01(\tR\x04tool\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x1b\n" +
+	"\tbefore_id\x18\x04 \x01(\x03R\bbeforeId\x12\x19\n" +
+	"\bafter_id\x18\x05 \x01(\x03R\aafterId\x12\x16\n" +
+	"\x06offset\x18\x06 \x01(\x05R\x06offset\x12\x1d\n" +
+	"\n" +
+	"with_total\x18\a \x01(\bR\twithTotal\"Z\n" +
+	"\x0fHistoryResponse\x121\n" +
+	"\ahistory\x18\x01 \x03(\v2\x17.mcpshim.v1.HistoryItemR\ahistory\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\x03R\x05total\"\xd2\x02\n" +
+	"\vHistoryItem\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12*\n" +
+	"\x02at\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x02at\x12\x16\n" +
+	"\x06server\x18\x03 \x01(\tR\x06server\x12\x12\n" +
+	"\x04tool\x18\x04 \x01(\tR\x04tool\x12+\n" +
+	"\x04args\x18\x05 \x01(\v2\x17.google.protobuf.StructR\x04args\x12%\n" +
+	"\x0eargs_truncated\x18\x06 \x01(\bR\rargsTruncated\x12\x18\n" +
+	"\asuccess\x18\a \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error\x12\x1f\n" +
+	"\vduration_ms\x18\t \x01(\x03R\n" +
+	"durationMs\x12\x1d\n" +
+	"\n" +
+	"connect_ms\x18\n" +
+	" \x01(\x03R\tconnectMs\x12\x17\n" +
+	"\acall_ms\x18\v \x01(\x03R\x06callMs2\x8f\x03\n" +
+	"\aControl\x12?\n" +
+	"\x06Status\x12\x19.mcpshim.v1.StatusRequest\x1a\x1a.mcpshim.v1.StatusResponse\x12B\n" +
+	"\aServers\x12\x1a.mcpshim.v1.ServersRequest\x1a\x1b.mcpshim.v1.ServersResponse\x12<\n" +
+	"\x05Tools\x12\x18.mcpshim.v1.ToolsRequest\x1a\x19.mcpshim.v1.ToolsResponse\x12B\n" +
+	"\aInspect\x12\x1a.mcpshim.v1.InspectRequest\x1a\x1b.mcpshim.v1.InspectResponse\x129\n" +
+	"\x04Call\x12\x17.mcpshim.v1.CallRequest\x1a\x18.mcpshim.v1.CallResponse\x12B\n" +
+	"\aHistory\x12\x1a.mcpshim.v1.HistoryRequest\x1a\x1b.mcpshim.v1.HistoryResponseB8Z6github.com/prbarcelon/mcpshim/api/mcpshim/v1;mcpshimv1b\x06proto3"
+
+var (
+	file_api_mcpshim_v1_control_proto_rawDescOnce sync.Once
+	file_api_mcpshim_v1_control_proto_rawDescData []byte
+)
+
+func file_api_mcpshim_v1_control_proto_rawDescGZIP() []byte {
+	file_api_mcpshim_v1_control_proto_rawDescOnce.Do(func() {
+		file_api_mcpshim_v1_control_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_mcpshim_v1_control_proto_rawDesc), len(file_api_mcpshim_v1_control_proto_rawDesc)))
+	})
+	return file_api_mcpshim_v1_control_proto_rawDescData
+}
+
+var file_api_mcpshim_v1_control_proto_msgTypes = make([]protoimpl.MessageInfo, 17)
+var file_api_mcpshim_v1_control_proto_goTypes = []any{
+	(*StatusRequest)(nil),         // 0: mcpshim.v1.StatusRequest
+	(*StatusResponse)(nil),        // 1: mcpshim.v1.StatusResponse
+	(*ServersRequest)(nil),        // 2: mcpshim.v1.ServersRequest
+	(*ServersResponse)(nil),       // 3: mcpshim.v1.ServersResponse
+	(*ServerInfo)(nil),            // 4: mcpshim.v1.ServerInfo
+	(*ToolsRequest)(nil),          // 5: mcpshim.v1.ToolsRequest
+	(*ToolsResponse)(nil),         // 6: mcpshim.v1.ToolsResponse
+	(*ToolInfo)(nil),              // 7: mcpshim.v1.ToolInfo
+	(*InspectRequest)(nil),        // 8: mcpshim.v1.InspectRequest
+	(*InspectResponse)(nil),       // 9: mcpshim.v1.InspectResponse
+	(*ToolDetail)(nil),            // 10: mcpshim.v1.ToolDetail
+	(*PropertyDetail)(nil),        // 11: mcpshim.v1.PropertyDetail
+	(*CallRequest)(nil),           // 12: mcpshim.v1.CallRequest
+	(*CallResponse)(nil),          // 13: mcpshim.v1.CallResponse
+	(*HistoryRequest)(nil),        // 14: mcpshim.v1.HistoryRequest
+	(*HistoryResponse)(nil),       // 15: mcpshim.v1.HistoryResponse
+	(*HistoryItem)(nil),           // 16: mcpshim.v1.HistoryItem
+	(*timestamppb.Timestamp)(nil), // 17: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),       // 18: google.protobuf.Struct
+	(*structpb.Value)(nil),        // 19: google.protobuf.Value
+}
+var file_api_mcpshim_v1_control_proto_depIdxs = []int32{
+	17, // 0: mcpshim.v1.StatusResponse.started_at:type_name -> google.protobuf.Timestamp
+	17, // 1: mcpshim.v1.StatusResponse.last_refresh:type_name -> google.protobuf.Timestamp
+	4,  // 2: mcpshim.v1.ServersResponse.servers:type_name -> mcpshim.v1.ServerInfo
+	17, // 3: mcpshim.v1.ServerInfo.last_refresh:type_name -> google.protobuf.Timestamp
+	7,  // 4: mcpshim.v1.ToolsResponse.tools:type_name -> mcpshim.v1.ToolInfo
+	17, // 5: mcpshim.v1.ToolsResponse.refreshed_at:type_name -> google.protobuf.Timestamp
+	10, // 6: mcpshim.v1.InspectResponse.tool:type_name -> mcpshim.v1.ToolDetail
+	17, // 7: mcpshim.v1.InspectResponse.refreshed_at:type_name -> google.protobuf.Timestamp
+	11, // 8: mcpshim.v1.ToolDetail.properties:type_name -> mcpshim.v1.PropertyDetail
+	11, // 9: mcpshim.v1.ToolDetail.output_properties:type_name -> mcpshim.v1.PropertyDetail
+	18, // 10: mcpshim.v1.ToolDetail.meta:type_name -> google.protobuf.Struct
+	11, // 11: mcpshim.v1.PropertyDetail.properties:type_name -> mcpshim.v1.PropertyDetail
+	18, // 12: mcpshim.v1.CallRequest.args:type_name -> google.protobuf.Struct
+	19, // 13: mcpshim.v1.CallResponse.result:type_name -> google.protobuf.Value
+	16, // 14: mcpshim.v1.HistoryResponse.history:type_name -> mcpshim.v1.HistoryItem
+	17, // 15: mcpshim.v1.HistoryItem.at:type_name -> google.protobuf.Timestamp
+	18, // 16: mcpshim.v1.HistoryItem.args:type_name -> google.protobuf.Struct
+	0,  // 17: mcpshim.v1.Control.Status:input_type -> mcpshim.v1.StatusRequest
+	2,  // 18: mcpshim.v1.Control.Servers:input_type -> mcpshim.v1.ServersRequest
+	5,  // 19: mcpshim.v1.Control.Tools:input_type -> mcpshim.v1.ToolsRequest
+	8,  // 20: mcpshim.v1.Control.Inspect:input_type -> mcpshim.v1.InspectRequest
+	12, // 21: mcpshim.v1.Control.Call:input_type -> mcpshim.v1.CallRequest
+	14, // 22: mcpshim.v1.Control.History:input_type -> mcpshim.v1.HistoryRequest
+	1,  // 23: mcpshim.v1.Control.Status:output_type -> mcpshim.v1.StatusResponse
+	3,  // 24: mcpshim.v1.Control.Servers:output_type -> mcpshim.v1.ServersResponse
+	6,  // 25: mcpshim.v1.Control.Tools:output_type -> mcpshim.v1.ToolsResponse
+	9,  // 26: mcpshim.v1.Control.Inspect:output_type -> mcpshim.v1.InspectResponse
+	13, // 27: mcpshim.v1.Control.Call:output_type -> mcpshim.v1.CallResponse
+	15, // 28: mcpshim.v1.Control.History:output_type -> mcpshim.v1.HistoryResponse
+	23, // [23:29] is the sub-list for method output_type
+	17, // [17:23] is the sub-list for method input_type
+	17, // [17:17] is the sub-list for extension type_name
+	17, // [17:17] is the sub-list for extension extendee
+	0,  // [0:17] is the sub-list for field type_name
+}
+
+func init() { file_api_mcpshim_v1_control_proto_init() }
+func file_api_mcpshim_v1_control_proto_init() {
+	if File_api_mcpshim_v1_control_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_mcpshim_v1_control_proto_rawDesc), len(file_api_mcpshim_v1_control_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   17,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_mcpshim_v1_control_proto_goTypes,
+		DependencyIndexes: file_api_mcpshim_v1_control_proto_depIdxs,
+		MessageInfos:      file_api_mcpshim_v1_control_proto_msgTypes,
+	}.Build()
+	File_api_mcpshim_v1_control_proto = out.File
+	file_api_mcpshim_v1_control_proto_goTypes = nil
+	file_api_mcpshim_v1_control_proto_depIdxs = nil
+}
@@ -13,6 +13,7 @@ func main() {
 	configPath := flag.String("config", config.DefaultConfigPath(), "path to mcpshim config")
 	socketPath := flag.String("socket", "", "override unix socket path")
 	debug := flag.Bool("debug", false, "debug logging")
+	readOnly := flag.Bool("read-only", false, "refuse actions that change servers or config")
 	showVersion := flag.Bool("version", false, "print version")
 	flag.Parse()
 
@@ -32,6 +33,7 @@ func main() {
 
 	srv := server.New(*configPath, cfg)
 	srv.SetDebug(*debug)
+	srv.SetReadOnly(*readOnly)
 	if err := srv.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 		os.Exit(1)
@@ -1,22 +1,37 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prbarcelon/mcpshim/internal/config"
 	"github.com/prbarcelon/mcpshim/internal/mcp"
 	"github.com/prbarcelon/mcpshim/internal/protocol"
 	"github.com/prbarcelon/mcpshim/internal/store"
+	"gopkg.in/yaml.v3"
 )
 
 type headerArgs map[string]string
@@ -46,7 +61,45 @@ func (s *stringSliceFlag) Set(value string) error {
 	return nil
 }
 
+type envArgs []string
+
+func (e *envArgs) String() string {
+	if e == nil {
+		return ""
+	}
+	return strings.Join(*e, ",")
+}
+
+func (e *envArgs) Set(value string) error {
+	if strings.HasPrefix(value, "@") {
+		lines, err := readKeyValueFile(strings.TrimPrefix(value, "@"))
+		if err != nil {
+			return err
+		}
+		*e = append(*e, lines...)
+		return nil
+	}
+	*e = append(*e, value)
+	return nil
+}
+
 func (h *headerArgs) Set(value string) error {
+	if strings.HasPrefix(value, "@") {
+		lines, err := readKeyValueFile(strings.TrimPrefix(value, "@"))
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if err := h.setPair(line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return h.setPair(value)
+}
+
+func (h *headerArgs) setPair(value string) error {
 	parts := strings.SplitN(value, "=", 2)
 	if len(parts) != 2 {
 		return fmt.Errorf("invalid header %q, expected key=value", value)
@@ -62,11 +115,44 @@ func (h *headerArgs) Set(value string) error {
 	(*h)[key] = val
 	return nil
 }
+
+func readKeyValueFile(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("missing file path after @")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "=") {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE, got %q", path, i+1, line)
+		}
+		out = append(out, line)
+	}
+	return out, nil
+}
+
+const heartbeatTimeout = 30 * time.Second
+
+var subcommands = []string{
+	"servers", "tools", "inspect", "call", "add", "update", "set", "remove", "status",
+	"history", "reload", "validate", "login", "script", "rpc", "cancel",
+	"subscribe", "invalidate", "resources", "read", "prompts", "prompt",
+	"logout", "completion", "shell", "health", "search",
+}
+
 func Run(binaryName string, argv []string) int {
 	if binaryName == "" {
 		binaryName = filepath.Base(os.Args[0])
 	}
 
+	autoStart = autoStartConfigured()
 	if binaryName != "mcpshim" {
 		if len(argv) < 1 {
 			fmt.Fprintf(os.Stderr, "%s requires a tool name\n", binaryName)
@@ -82,7 +168,7 @@ func Run(binaryName string, argv []string) int {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, true)
+		return printResponse(resp, outputOptions{format: "json", indent: "  "})
 	}
 
 	if len(argv) == 0 {
@@ -91,13 +177,45 @@ func Run(binaryName string, argv []string) int {
 	}
 
 	socketPath := config.DefaultSocketPath()
-	jsonOut := !isTerminal(os.Stdout.Fd())
+	format, err := defaultOutputFormat()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	out := outputOptions{format: format}
+	var compact, asJSON bool
+	indent := 2
 
 	global := flag.NewFlagSet("global", flag.ContinueOnError)
 	global.StringVar(&socketPath, "socket", socketPath, "unix socket path")
-	global.BoolVar(&jsonOut, "json", jsonOut, "json output")
+	global.StringVar(&out.format, "output", out.format, "text|json|yaml")
+	global.BoolVar(&asJSON, "json", false, "json output, same as --output json")
+	global.BoolVar(&out.quiet, "quiet", false, "print only results, suppressing informational output")
+	global.BoolVar(&compact, "compact", false, "print json on a single line")
+	global.IntVar(&indent, "indent", indent, "spaces of json indentation")
+	global.IntVar(&out.maxOutput, "max-output", 0, "truncate printed text results after n bytes (0 = unlimited)")
+	global.BoolVar(&autoStart, "auto-start", autoStart, "start mcpshimd in the background if no daemon answers")
 	global.SetOutput(os.Stderr)
 	_ = global.Parse(argv)
+	global.Visit(func(f *flag.Flag) {
+		// --json=false still asks for text, as it did before --output
+		if f.Name == "json" && asJSON {
+			out.format = "json"
+		} else if f.Name == "json" {
+			out.format = "text"
+		}
+	})
+	if err := config.CheckOutputFormat(out.format); err != nil || out.format == "" {
+		fmt.Fprintf(os.Stderr, "--output must be text, json or yaml, got %q\n", out.format)
+		return 1
+	}
+	if indent < 0 {
+		fmt.Fprintln(os.Stderr, "--indent must be non-negative")
+		return 1
+	}
+	if !compact {
+		out.indent = strings.Repeat(" ", indent)
+	}
 	args := global.Args()
 	if len(args) == 0 {
 		usage()
@@ -109,38 +227,97 @@ func Run(binaryName string, argv []string) int {
 
 	switch cmd {
 	case "servers":
+		fs := flag.NewFlagSet("servers", flag.ContinueOnError)
+		var sortBy string
+		fs.StringVar(&sortBy, "sort", "name", "name|alias|transport")
+		_ = fs.Parse(rest)
 		resp, err := call(protocol.Request{Action: "servers"}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		if err := sortServers(resp.Servers, sortBy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, out)
 	case "tools":
 		fs := flag.NewFlagSet("tools", flag.ContinueOnError)
-		var server string
-		var full bool
+		var server, format string
+		var full, count, diff bool
 		fs.StringVar(&server, "server", "", "server name or alias")
+		fs.StringVar(&format, "format", "text", "text|md")
 		fs.BoolVar(&full, "full", false, "show full tool descriptions")
+		fs.BoolVar(&count, "count", false, "show per-server tool counts only")
+		fs.BoolVar(&diff, "diff", false, "show tools added, removed or changed by the last change seen on refresh")
 		_ = fs.Parse(rest)
-		resp, err := call(protocol.Request{Action: "tools", Server: server}, socketPath)
+		if diff {
+			if server == "" {
+				fmt.Fprintln(os.Stderr, "usage: mcpshim tools --diff --server <name>")
+				return 1
+			}
+			resp, err := call(protocol.Request{Action: "tool_diff", Server: server}, socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			return printResponse(resp, out)
+		}
+		if format == "md" {
+			if server == "" {
+				fmt.Fprintln(os.Stderr, "usage: mcpshim tools --server <name> --format md")
+				return 1
+			}
+			resp, err := call(protocol.Request{Action: "tools", Server: server, Detail: true}, socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			if !resp.OK {
+				fmt.Fprintln(os.Stderr, resp.Error)
+				return 1
+			}
+			printStaleNotice(resp)
+			fmt.Printf("# %s tools\n", server)
+			for i := range resp.ToolDetails {
+				fmt.Print("\n" + toolMarkdown(&resp.ToolDetails[i]))
+			}
+			return 0
+		} else if format != "text" {
+			fmt.Fprintf(os.Stderr, "unsupported --format %q (expected text or md)\n", format)
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "tools", Server: server, Count: count}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		if jsonOut {
-			return printResponse(resp, jsonOut)
+		if count {
+			// a server that exposes no tools is missing from the tool list,
+			// and that is the server --count is meant to catch
+			servers, err := call(protocol.Request{Action: "servers"}, socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			return printToolCounts(resp, countedServers(servers.Servers, server), out)
+		}
+		if out.structured() {
+			return printResponse(resp, out)
 		}
 		if !resp.OK {
 			fmt.Fprintln(os.Stderr, resp.Error)
 			return 1
 		}
+		printStaleNotice(resp)
 		printToolsList(resp.Tools, full)
 		return 0
 	case "inspect":
 		fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
-		var server, tool string
+		var server, tool, format string
 		fs.StringVar(&server, "server", "", "server name or alias")
 		fs.StringVar(&tool, "tool", "", "tool name")
+		fs.StringVar(&format, "format", "text", "text|md")
 		_ = fs.Parse(rest)
 		// allow positional: inspect <server> <tool>
 		if server == "" || tool == "" {
@@ -157,105 +334,492 @@ func Run(binaryName string, argv []string) int {
 			fmt.Fprintln(os.Stderr, "usage: mcpshim inspect --server <name> --tool <tool>")
 			return 1
 		}
+		if format != "text" && format != "md" {
+			fmt.Fprintf(os.Stderr, "unsupported --format %q (expected text or md)\n", format)
+			return 1
+		}
 		resp, err := call(protocol.Request{Action: "inspect", Server: server, Tool: tool}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		if !out.structured() && resp.OK {
+			printStaleNotice(resp)
+		}
+		if format == "md" && resp.OK && resp.ToolDetail != nil {
+			fmt.Print(toolMarkdown(resp.ToolDetail))
+			return 0
+		}
+		return printResponse(resp, out)
+	case "resources":
+		fs := flag.NewFlagSet("resources", flag.ContinueOnError)
+		var server string
+		fs.StringVar(&server, "server", "", "server name or alias")
+		_ = fs.Parse(rest)
+		if server == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim resources --server <name>")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "resources", Server: server}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !out.structured() && resp.OK && len(resp.Resources) == 0 && !out.quiet {
+			fmt.Fprintf(os.Stderr, "%s lists no resources\n", server)
+		}
+		return printResponse(resp, out)
+	case "read":
+		fs := flag.NewFlagSet("read", flag.ContinueOnError)
+		var server, uri string
+		fs.StringVar(&server, "server", "", "server name or alias")
+		fs.StringVar(&uri, "uri", "", "resource uri to read")
+		_ = fs.Parse(rest)
+		if server == "" || uri == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim read --server <name> --uri <uri>")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "read_resource", Server: server, URI: uri}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if out.structured() || !resp.OK {
+			return printResponse(resp, out)
+		}
+		printResourceContents(os.Stdout, resp.Result)
+		return 0
+	case "prompts":
+		fs := flag.NewFlagSet("prompts", flag.ContinueOnError)
+		var server string
+		fs.StringVar(&server, "server", "", "server name or alias")
+		_ = fs.Parse(rest)
+		if server == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim prompts --server <name>")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "prompts", Server: server}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !out.structured() && resp.OK && len(resp.Prompts) == 0 && !out.quiet {
+			fmt.Fprintf(os.Stderr, "%s lists no prompts\n", server)
+		}
+		return printResponse(resp, out)
+	case "prompt":
+		fs := flag.NewFlagSet("prompt", flag.ContinueOnError)
+		var server, name string
+		var promptArgs stringSliceFlag
+		fs.StringVar(&server, "server", "", "server name or alias")
+		fs.StringVar(&name, "name", "", "prompt name")
+		fs.Var(&promptArgs, "arg", "prompt argument as key=value (repeatable)")
+		_ = fs.Parse(rest)
+		if server == "" || name == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim prompt --server <name> --name <prompt> [--arg key=value ...]")
+			return 1
+		}
+		args := map[string]interface{}{}
+		for _, pair := range promptArgs {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				fmt.Fprintf(os.Stderr, "invalid --arg %q, expected key=value\n", pair)
+				return 1
+			}
+			args[strings.TrimSpace(key)] = value
+		}
+		resp, err := call(protocol.Request{Action: "get_prompt", Server: server, Name: name, Args: args}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if out.structured() || !resp.OK || resp.Prompt == nil {
+			return printResponse(resp, out)
+		}
+		printPrompt(os.Stdout, resp.Prompt)
+		return 0
 	case "call":
-		return runCall(rest, socketPath, jsonOut)
+		return runCall(rest, socketPath, out)
 	case "add":
 		fs := flag.NewFlagSet("add", flag.ContinueOnError)
-		var name, alias, url, transport string
+		var name, alias, url, transport, bearer string
 		var headers headerArgs
-		var command, env stringSliceFlag
+		var command, roots stringSliceFlag
+		var env envArgs
 		fs.StringVar(&name, "name", "", "server name")
 		fs.StringVar(&alias, "alias", "", "short alias")
 		fs.StringVar(&url, "url", "", "mcp endpoint")
 		fs.StringVar(&transport, "transport", "http", "http|sse|stdio")
-		fs.Var(&headers, "header", "request header key=value (repeatable)")
+		fs.Var(&headers, "header", "request header key=value or @file (repeatable)")
+		fs.StringVar(&bearer, "bearer", "", "token to send as Authorization: Bearer <token>")
 		fs.Var(&command, "command", "command and args for stdio transport (repeatable)")
-		fs.Var(&env, "env", "environment variable KEY=VALUE for stdio transport (repeatable)")
-		_ = fs.Parse(rest)
+		fs.Var(&env, "env", "environment variable KEY=VALUE or @file for stdio transport (repeatable)")
+		fs.Var(&roots, "root", "directory the server may access, advertised as an mcp root (repeatable)")
+		// an unreadable @file must not register the server without its headers
+		if err := fs.Parse(rest); err != nil {
+			return 1
+		}
+		if url != "" {
+			if err := config.CheckEndpointURL(url); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		rootPaths, err := absRoots(roots)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
 		headersMap := map[string]string(headers)
-		resp, err := call(protocol.Request{Action: "add_server", Name: name, Alias: alias, URL: url, Transport: transport, Headers: headersMap, Command: []string(command), Env: []string(env)}, socketPath)
+		warnMissingAuthScheme(headersMap)
+		resp, err := call(protocol.Request{Action: "add_server", Name: name, Alias: alias, URL: url, Transport: transport, Headers: headersMap, Bearer: bearer, Command: []string(command), Env: []string(env), Roots: rootPaths}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, out)
+	case "update":
+		fs := flag.NewFlagSet("update", flag.ContinueOnError)
+		var name, alias, url, transport, bearer string
+		var headers headerArgs
+		var command, roots stringSliceFlag
+		var env envArgs
+		fs.StringVar(&name, "name", "", "server name")
+		fs.StringVar(&alias, "alias", "", "new alias")
+		fs.StringVar(&url, "url", "", "new mcp endpoint")
+		fs.StringVar(&transport, "transport", "", "new transport: http|sse|stdio")
+		fs.Var(&headers, "header", "header key=value or @file to add or replace (repeatable)")
+		fs.StringVar(&bearer, "bearer", "", "token to send as Authorization: Bearer <token>")
+		fs.Var(&command, "command", "replacement command and args for stdio transport (repeatable)")
+		fs.Var(&env, "env", "replacement environment KEY=VALUE or @file (repeatable)")
+		fs.Var(&roots, "root", "replacement directory the server may access (repeatable)")
+		if err := fs.Parse(rest); err != nil {
+			return 1
+		}
+		if name == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim update --name <server> [--alias a] [--url u] [--header K=V] ...")
+			return 1
+		}
+		if url != "" {
+			if err := config.CheckEndpointURL(url); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		}
+		rootPaths, err := absRoots(roots)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		warnMissingAuthScheme(headers)
+		resp, err := call(protocol.Request{Action: "update_server", Name: name, Alias: alias, URL: url, Transport: transport, Headers: map[string]string(headers), Bearer: bearer, Command: []string(command), Env: []string(env), Roots: rootPaths}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		return printResponse(resp, out)
 	case "set":
-		return runSetCommand(rest, socketPath, jsonOut)
+		return runSetCommand(rest, socketPath, out)
 	case "remove":
 		fs := flag.NewFlagSet("remove", flag.ContinueOnError)
 		var name string
+		var purge, purgeHistory bool
 		fs.StringVar(&name, "name", "", "server name")
+		fs.BoolVar(&purge, "purge", false, "also delete the server's oauth token and tool snapshots")
+		fs.BoolVar(&purgeHistory, "history", false, "with --purge, also delete the server's call history")
 		_ = fs.Parse(rest)
-		resp, err := call(protocol.Request{Action: "remove_server", Name: name}, socketPath)
+		if purgeHistory && !purge {
+			fmt.Fprintln(os.Stderr, "--history requires --purge")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "remove_server", Name: name, Purge: purge, PurgeHistory: purgeHistory}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		return printResponse(resp, out)
 	case "status":
+		fs := flag.NewFlagSet("status", flag.ContinueOnError)
+		var watch, connections bool
+		var interval time.Duration
+		fs.BoolVar(&watch, "watch", false, "redraw a live dashboard until interrupted")
+		fs.BoolVar(&connections, "connections", false, "show per-server connection counters")
+		fs.DurationVar(&interval, "interval", 2*time.Second, "refresh interval for --watch")
+		_ = fs.Parse(rest)
+		if watch {
+			if interval < 500*time.Millisecond {
+				fmt.Fprintln(os.Stderr, "--interval must be at least 500ms")
+				return 1
+			}
+			return runStatusWatch(socketPath, interval, out)
+		}
+		if connections {
+			resp, err := call(protocol.Request{Action: "connections"}, socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			return printResponse(resp, out)
+		}
 		resp, err := call(protocol.Request{Action: "status"}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		return printResponse(resp, out)
 	case "history":
+		if len(rest) > 0 && rest[0] == "export" {
+			fs := flag.NewFlagSet("history export", flag.ContinueOnError)
+			var server, tool, dbPath string
+			fs.StringVar(&server, "server", "", "filter by server name or alias")
+			fs.StringVar(&tool, "tool", "", "filter by tool name")
+			fs.StringVar(&dbPath, "db", "", "read this database file directly instead of asking the daemon")
+			_ = fs.Parse(rest[1:])
+			if dbPath != "" {
+				return runHistoryExportLocal(dbPath, store.HistoryQuery{Server: server, Tool: tool})
+			}
+			return runStream(protocol.Request{Action: "history_export", Server: server, Tool: tool}, socketPath, out)
+		}
 		fs := flag.NewFlagSet("history", flag.ContinueOnError)
-		var server, tool string
+		var server, tool, dbPath string
 		var limit int
 		fs.StringVar(&server, "server", "", "filter by server name or alias")
 		fs.StringVar(&tool, "tool", "", "filter by tool name")
+		fs.StringVar(&dbPath, "db", "", "read this database file directly instead of asking the daemon")
+		var page int
+		var before, after int64
+		var withTotal, clearHistory bool
 		fs.IntVar(&limit, "limit", 50, "max entries to return (1-500)")
+		fs.IntVar(&page, "page", 1, "page of --limit entries, counting back from the newest")
+		fs.Int64Var(&before, "before", 0, "only entries older than this history id")
+		fs.Int64Var(&after, "after", 0, "only entries newer than this history id, oldest first")
+		fs.BoolVar(&withTotal, "with-total", false, "also count all entries matching --server and --tool")
+		fs.BoolVar(&clearHistory, "clear", false, "delete the entries matching --server and --tool instead of listing them")
 		_ = fs.Parse(rest)
-		resp, err := call(protocol.Request{Action: "history", Server: server, Tool: tool, Limit: limit}, socketPath)
+		if clearHistory {
+			if dbPath != "" {
+				fmt.Fprintln(os.Stderr, "--clear cannot be used with --db, which opens the database read-only")
+				return 1
+			}
+			resp, err := call(protocol.Request{Action: "clear_history", Server: server, Tool: tool}, socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			return printResponse(resp, out)
+		}
+		if page < 1 {
+			fmt.Fprintln(os.Stderr, "--page must be at least 1")
+			return 1
+		}
+		q := store.HistoryQuery{Server: server, Tool: tool, Limit: limit, BeforeID: before, AfterID: after, Offset: (page - 1) * limit}
+		if dbPath != "" {
+			return runHistoryLocal(dbPath, q, withTotal, out)
+		}
+		resp, err := call(protocol.Request{Action: "history", Server: server, Tool: tool, Limit: limit, BeforeID: before, AfterID: after, Offset: q.Offset, WithTotal: withTotal}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		return printResponse(resp, out)
 	case "reload":
 		resp, err := call(protocol.Request{Action: "reload"}, socketPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return printResponse(resp, jsonOut)
+		return printResponse(resp, out)
+	case "invalidate":
+		fs := flag.NewFlagSet("invalidate", flag.ContinueOnError)
+		var server string
+		fs.StringVar(&server, "server", "", "only drop cached tools of this server")
+		_ = fs.Parse(rest)
+		resp, err := call(protocol.Request{Action: "invalidate", Server: server}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, out)
 	case "validate":
 		fs := flag.NewFlagSet("validate", flag.ContinueOnError)
 		configPath := fs.String("config", config.DefaultConfigPath(), "config path to validate")
+		strict := fs.Bool("strict", false, "also check for aliases shadowing subcommands, unsafe names and duplicate urls")
 		_ = fs.Parse(rest)
-		if _, err := config.Load(*configPath); err != nil {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		fmt.Printf("config is valid: %s\n", *configPath)
+		if *strict {
+			issues := config.StrictIssues(cfg, subcommands)
+			for _, issue := range issues {
+				fmt.Fprintln(os.Stderr, issue)
+			}
+			if len(issues) > 0 {
+				return 1
+			}
+		}
+		if !out.quiet {
+			fmt.Printf("config is valid: %s\n", *configPath)
+		}
 		return 0
 	case "login":
 		fs := flag.NewFlagSet("login", flag.ContinueOnError)
 		var server string
-		var manual bool
+		var manual, check bool
+		var roots stringSliceFlag
 		fs.StringVar(&server, "server", "", "server name or alias")
 		fs.BoolVar(&manual, "manual", false, "complete oauth by pasting redirect url/code")
+		fs.BoolVar(&check, "check", false, "only report whether stored credentials work; never start a login flow")
+		fs.Var(&roots, "root", "directory the server may access, stored and advertised as an mcp root (repeatable)")
 		_ = fs.Parse(rest)
-		if server == "" {
-			pos := fs.Args()
-			if len(pos) > 0 {
-				server = pos[0]
+		servers := fs.Args()
+		if server != "" {
+			servers = append([]string{server}, servers...)
+		}
+		if len(servers) == 0 {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim login --server <name> [name...]")
+			return 1
+		}
+		if check {
+			code := 0
+			for _, name := range servers {
+				if runLoginCheckLocal(name, out) != 0 {
+					code = 1
+				}
 			}
+			return code
+		}
+		if len(roots) > 0 && len(servers) > 1 {
+			fmt.Fprintln(os.Stderr, "--root sets the directories of one server at a time")
+			return 1
+		}
+		servers, code := runLoginRoots(servers, roots, socketPath, out)
+		if code != 0 || len(servers) == 0 {
+			return code
+		}
+		if manual && len(servers) > 1 {
+			fmt.Fprintln(os.Stderr, "--manual logs in to one server at a time")
+			return 1
+		}
+		return runLoginLocal(servers, manual, out)
+	case "logout":
+		fs := flag.NewFlagSet("logout", flag.ContinueOnError)
+		var server string
+		fs.StringVar(&server, "server", "", "server name or alias")
+		_ = fs.Parse(rest)
+		if server == "" && fs.NArg() == 1 {
+			server = fs.Arg(0)
 		}
 		if server == "" {
-			fmt.Fprintln(os.Stderr, "usage: mcpshim login --server <name>")
+			fmt.Fprintln(os.Stderr, "usage: mcpshim logout --server <name>")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "logout", Server: server}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
-		return runLoginLocal(server, manual)
+		return printResponse(resp, out)
 	case "script":
 		return runScriptCommand(rest, socketPath)
+	case "rpc":
+		fs := flag.NewFlagSet("rpc", flag.ContinueOnError)
+		var server, method, rawParams string
+		fs.StringVar(&server, "server", "", "server name or alias")
+		fs.StringVar(&method, "method", "", "json-rpc method, e.g. tools/list")
+		fs.StringVar(&rawParams, "params", "", "json-rpc params as json")
+		_ = fs.Parse(rest)
+		if server == "" || method == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim rpc --server <name> --method <method> [--params '{...}']")
+			return 1
+		}
+		var params interface{}
+		if rawParams != "" {
+			if err := json.Unmarshal([]byte(rawParams), &params); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid --params: %v\n", err)
+				return 1
+			}
+		}
+		resp, err := call(protocol.Request{Action: "rpc", Server: server, Method: method, Params: params}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, out)
+	case "health":
+		fs := flag.NewFlagSet("health", flag.ContinueOnError)
+		var server string
+		fs.StringVar(&server, "server", "", "server name or alias; all enabled servers when empty")
+		_ = fs.Parse(rest)
+		resp, err := call(protocol.Request{Action: "health", Server: server}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printHealth(resp, out)
+	case "search":
+		fs := flag.NewFlagSet("search", flag.ContinueOnError)
+		useRegex := fs.Bool("regex", false, "treat the query as a regular expression")
+		// the query may come before the flags, as in search github --regex
+		var query string
+		if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+			query, rest = rest[0], rest[1:]
+		}
+		_ = fs.Parse(rest)
+		if query == "" && fs.NArg() == 1 {
+			query = fs.Arg(0)
+		}
+		if query == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim search <query> [--regex]")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "search", Query: query, Regex: *useRegex}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printSearchResults(resp, out)
+	case "shell":
+		return runShell(rest, socketPath, out)
+	case "completion":
+		if len(rest) != 1 || completionScripts[rest[0]] == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim completion bash|zsh|fish")
+			return 1
+		}
+		fmt.Print(completionScripts[rest[0]])
+		return 0
+	case "__complete":
+		return runComplete(rest, socketPath)
+	case "subscribe":
+		fs := flag.NewFlagSet("subscribe", flag.ContinueOnError)
+		var server, uri string
+		fs.StringVar(&server, "server", "", "server name or alias")
+		fs.StringVar(&uri, "uri", "", "resource uri to watch")
+		_ = fs.Parse(rest)
+		if server == "" || uri == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim subscribe --server <name> --uri <uri>")
+			return 1
+		}
+		return runStream(protocol.Request{Action: "subscribe", Server: server, URI: uri}, socketPath, out)
+	case "cancel":
+		fs := flag.NewFlagSet("cancel", flag.ContinueOnError)
+		var id string
+		fs.StringVar(&id, "id", "", "call id to cancel")
+		_ = fs.Parse(rest)
+		if id == "" && len(fs.Args()) > 0 {
+			id = fs.Args()[0]
+		}
+		if id == "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim cancel <call-id>")
+			return 1
+		}
+		resp, err := call(protocol.Request{Action: "cancel", ID: id}, socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, out)
 	default:
 		if len(rest) > 0 {
 			resp, err := call(protocol.Request{
@@ -268,20 +832,220 @@ func Run(binaryName string, argv []string) int {
 				fmt.Fprintln(os.Stderr, err)
 				return 1
 			}
-			return printResponse(resp, jsonOut)
+			return printResponse(resp, out)
 		}
 		usage()
 		return 1
 	}
 }
 
-func runScriptCommand(args []string, socket string) int {
-	fs := flag.NewFlagSet("script", flag.ContinueOnError)
-	install := fs.Bool("install", false, "install executable wrappers instead of printing shell script")
-	dir := fs.String("dir", filepath.Join(os.Getenv("HOME"), ".local", "bin"), "target directory for wrappers when --install is set")
-	_ = fs.Parse(args)
-
-	resp, err := call(protocol.Request{Action: "servers"}, socket)
+// the scripts ask `mcpshim __complete` for candidates, so they follow the
+// servers and tools of whichever daemon answers
+var completionScripts = map[string]string{
+	"bash": `_mcpshim() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    local server="" tool="" i
+    for ((i = 1; i < COMP_CWORD - 1; i++)); do
+        [[ ${COMP_WORDS[i]} == --server ]] && server="${COMP_WORDS[i+1]}"
+        [[ ${COMP_WORDS[i]} == --tool ]] && tool="${COMP_WORDS[i+1]}"
+    done
+    if [[ -z $server && ${COMP_WORDS[1]} != call ]] && ((COMP_CWORD > 3)); then
+        server="${COMP_WORDS[1]}" tool="${COMP_WORDS[2]}"
+    fi
+    local candidates=""
+    case "$prev" in
+        --server|--name) candidates="$(mcpshim __complete servers 2>/dev/null)" ;;
+        --tool) candidates="$(mcpshim __complete tools "$server" 2>/dev/null)" ;;
+        --*)
+            if [[ -n $server && -n $tool ]]; then
+                candidates="$(mcpshim __complete enum "$server" "$tool" "${prev#--}" 2>/dev/null)"
+            fi
+            ;;
+        *)
+            if ((COMP_CWORD == 1)); then
+                candidates="$(mcpshim __complete commands 2>/dev/null)"
+            elif ((COMP_CWORD == 2)) && [[ $cur != -* ]]; then
+                candidates="$(mcpshim __complete tools "${COMP_WORDS[1]}" 2>/dev/null)"
+            fi
+            ;;
+    esac
+    [[ -n $candidates ]] && COMPREPLY=($(compgen -W "$candidates" -- "$cur"))
+}
+complete -o default -F _mcpshim mcpshim
+`,
+	"zsh": `#compdef mcpshim
+_mcpshim() {
+    local -a candidates
+    local server="" tool="" i
+    for ((i = 2; i < CURRENT - 1; i++)); do
+        [[ ${words[i]} == --server ]] && server=${words[i+1]}
+        [[ ${words[i]} == --tool ]] && tool=${words[i+1]}
+    done
+    if [[ -z $server && ${words[2]} != call ]] && ((CURRENT > 4)); then
+        server=${words[2]} tool=${words[3]}
+    fi
+    case ${words[CURRENT-1]} in
+        --server|--name) candidates=(${(f)"$(mcpshim __complete servers 2>/dev/null)"}) ;;
+        --tool) candidates=(${(f)"$(mcpshim __complete tools "$server" 2>/dev/null)"}) ;;
+        --*)
+            if [[ -n $server && -n $tool ]]; then
+                candidates=(${(f)"$(mcpshim __complete enum "$server" "$tool" "${words[CURRENT-1]#--}" 2>/dev/null)"})
+            fi
+            ;;
+        *)
+            if ((CURRENT == 2)); then
+                candidates=(${(f)"$(mcpshim __complete commands 2>/dev/null)"})
+            elif ((CURRENT == 3)) && [[ ${words[CURRENT]} != -* ]]; then
+                candidates=(${(f)"$(mcpshim __complete tools "${words[2]}" 2>/dev/null)"})
+            fi
+            ;;
+    esac
+    if ((${#candidates})); then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+    _mcpshim "$@"
+else
+    compdef _mcpshim mcpshim
+fi
+`,
+	"fish": `function __mcpshim_server
+    set -l tokens (commandline -opc)
+    for i in (seq (count $tokens))
+        if test "$tokens[$i]" = --server; and test $i -lt (count $tokens)
+            echo $tokens[(math $i + 1)]
+            return
+        end
+    end
+end
+function __mcpshim_tool
+    set -l tokens (commandline -opc)
+    for i in (seq (count $tokens))
+        if test "$tokens[$i]" = --tool; and test $i -lt (count $tokens)
+            echo $tokens[(math $i + 1)]
+            return
+        end
+    end
+end
+function __mcpshim_enum
+    set -l tokens (commandline -opc)
+    set -l prev $tokens[-1]
+    string match -q -- '--*' $prev; or return
+    contains -- $prev --server --name --tool; and return
+    set -l server (__mcpshim_server)
+    set -l tool (__mcpshim_tool)
+    if test -z "$server"; and test (count $tokens) -ge 4; and test "$tokens[2]" != call
+        set server $tokens[2]
+        set tool $tokens[3]
+    end
+    test -n "$server"; and test -n "$tool"; or return
+    mcpshim __complete enum $server $tool (string sub -s 3 -- $prev) 2>/dev/null
+end
+complete -c mcpshim -f -n 'test (count (commandline -opc)) -eq 1' -a '(mcpshim __complete commands 2>/dev/null)'
+complete -c mcpshim -f -n 'test (count (commandline -opc)) -eq 2' -a '(mcpshim __complete tools (commandline -opc)[2] 2>/dev/null)'
+complete -c mcpshim -l server -x -a '(mcpshim __complete servers 2>/dev/null)'
+complete -c mcpshim -l name -x -a '(mcpshim __complete servers 2>/dev/null)'
+complete -c mcpshim -l tool -x -a '(mcpshim __complete tools (__mcpshim_server) 2>/dev/null)'
+complete -c mcpshim -a '(__mcpshim_enum)'
+`,
+}
+
+// prints candidates one per line for the completion scripts: "commands"
+// (subcommands and server aliases), "servers" (names and aliases),
+// "tools <server>" or "enum <server> <tool> <property>"; a daemon that does
+// not answer just means no candidates
+func runComplete(args []string, socketPath string) int {
+	// pressing tab should never start a daemon
+	autoStart = false
+	if len(args) == 0 {
+		return 0
+	}
+	switch args[0] {
+	case "commands":
+		for _, name := range subcommands {
+			fmt.Println(name)
+		}
+		for _, name := range completeServers(socketPath, false) {
+			fmt.Println(name)
+		}
+	case "servers":
+		for _, name := range completeServers(socketPath, true) {
+			fmt.Println(name)
+		}
+	case "tools":
+		if len(args) < 2 || args[1] == "" {
+			return 0
+		}
+		// count requests are answered from the warm cache when there is one
+		resp, err := call(protocol.Request{Action: "tools", Server: args[1], Count: true}, socketPath)
+		if err != nil || !resp.OK {
+			return 0
+		}
+		seen := map[string]bool{}
+		for _, item := range resp.Tools {
+			if !seen[item.Name] {
+				seen[item.Name] = true
+				fmt.Println(item.Name)
+			}
+		}
+	case "enum":
+		if len(args) < 4 || args[1] == "" || args[2] == "" {
+			return 0
+		}
+		detail, err := fetchToolDetail(args[1], args[2], socketPath)
+		if err != nil {
+			return 0
+		}
+		for _, value := range propertyValues(detail, args[3]) {
+			fmt.Println(value)
+		}
+	}
+	return 0
+}
+
+// the values a property allows, from its enum or else its const
+func propertyValues(detail *protocol.ToolDetail, name string) []string {
+	for _, p := range detail.Properties {
+		if p.Name != name {
+			continue
+		}
+		if len(p.Enum) > 0 {
+			return p.Enum
+		}
+		if p.Const != "" {
+			return []string{p.Const}
+		}
+	}
+	return nil
+}
+
+func completeServers(socketPath string, withNames bool) []string {
+	resp, err := call(protocol.Request{Action: "servers"}, socketPath)
+	if err != nil || !resp.OK {
+		return nil
+	}
+	out := []string{}
+	for _, s := range resp.Servers {
+		if withNames {
+			out = append(out, s.Name)
+		}
+		if s.Alias != "" && !slices.Contains(out, s.Alias) {
+			out = append(out, s.Alias)
+		}
+	}
+	return out
+}
+
+func runScriptCommand(args []string, socket string) int {
+	fs := flag.NewFlagSet("script", flag.ContinueOnError)
+	install := fs.Bool("install", false, "install executable wrappers instead of printing shell script")
+	dir := fs.String("dir", filepath.Join(os.Getenv("HOME"), ".local", "bin"), "target directory for wrappers when --install is set")
+	_ = fs.Parse(args)
+
+	resp, err := call(protocol.Request{Action: "servers"}, socket)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
@@ -292,7 +1056,7 @@ func runScriptCommand(args []string, socket string) int {
 	}
 
 	if *install {
-		if err := installAliasScripts(*dir, resp.Servers); err != nil {
+		if err := installAliasScripts(*dir, resp.Servers, socket); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			return 1
 		}
@@ -304,43 +1068,156 @@ func runScriptCommand(args []string, socket string) int {
 	return 0
 }
 
-func runSetCommand(args []string, socket string, jsonOut bool) int {
+func runSetCommand(args []string, socket string, out outputOptions) int {
 	if len(args) == 0 {
-		fmt.Fprintln(os.Stderr, "usage: mcpshim set auth --server <name> --header K=V")
+		fmt.Fprintln(os.Stderr, "usage: mcpshim set auth --server <name> --header K=V | --bearer <token>")
+		fmt.Fprintln(os.Stderr, "       mcpshim set enabled --server <name> --value true|false")
 		return 1
 	}
 
-	sub := args[0]
-	if sub != "auth" {
-		fmt.Fprintf(os.Stderr, "unknown set target %q (supported: auth)\n", sub)
+	switch sub := args[0]; sub {
+	case "auth":
+		return runSetAuth(args[1:], socket, out)
+	case "enabled":
+		return runSetEnabled(args[1:], socket, out)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown set target %q (supported: auth, enabled)\n", sub)
 		return 1
 	}
+}
 
+func runSetAuth(args []string, socket string, out outputOptions) int {
 	fs := flag.NewFlagSet("set auth", flag.ContinueOnError)
-	var name string
+	var name, bearer string
 	var headers headerArgs
 	fs.StringVar(&name, "server", "", "server name")
-	fs.Var(&headers, "header", "request header key=value (repeatable)")
-	_ = fs.Parse(args[1:])
+	fs.Var(&headers, "header", "request header key=value or @file (repeatable)")
+	fs.StringVar(&bearer, "bearer", "", "token to send as Authorization: Bearer <token>")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
 	if name == "" {
-		fmt.Fprintln(os.Stderr, "usage: mcpshim set auth --server <name> --header K=V")
+		fmt.Fprintln(os.Stderr, "usage: mcpshim set auth --server <name> --header K=V | --bearer <token>")
 		return 1
 	}
 	headersMap := map[string]string(headers)
-	resp, err := call(protocol.Request{Action: "set_auth", Name: name, Headers: headersMap}, socket)
+	warnMissingAuthScheme(headersMap)
+	resp, err := call(protocol.Request{Action: "set_auth", Name: name, Headers: headersMap, Bearer: bearer}, socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printResponse(resp, out)
+}
+
+func runSetEnabled(args []string, socket string, out outputOptions) int {
+	fs := flag.NewFlagSet("set enabled", flag.ContinueOnError)
+	var name, value string
+	fs.StringVar(&name, "server", "", "server name")
+	fs.StringVar(&value, "value", "", "true to enable the server, false to disable it")
+	_ = fs.Parse(args)
+	enabled, err := strconv.ParseBool(value)
+	if name == "" || err != nil {
+		fmt.Fprintln(os.Stderr, "usage: mcpshim set enabled --server <name> --value true|false")
+		return 1
+	}
+	resp, err := call(protocol.Request{Action: "set_enabled", Name: name, Enabled: &enabled}, socket)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	return printResponse(resp, jsonOut)
+	return printResponse(resp, out)
 }
 
-func runCall(args []string, socket string, jsonOut bool) int {
-	server, tool, rest, helpRequested, parseTextJSON, err := parseCallArgs(args)
+func warnMissingAuthScheme(headers map[string]string) {
+	if config.MissingAuthScheme(headers) {
+		fmt.Fprintln(os.Stderr, "warning: the Authorization header has no scheme; use --bearer <token> to send \"Bearer <token>\"")
+	}
+}
+
+func runCall(args []string, socket string, out outputOptions) int {
+	opts, err := parseCallArgs(args)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
+	server, tool, rest := opts.server, opts.tool, opts.rest
+	var fileArgs map[string]interface{}
+	if opts.argsFile != "" {
+		if fileArgs, err = readArgsFile(opts.argsFile, os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if opts.template != "" {
+		tmpl, err := parseOutputTemplate(opts.template)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		out.template = tmpl
+	}
+	if opts.dryRun && (opts.all || len(opts.servers) > 0) {
+		fmt.Fprintln(os.Stderr, "--dry-run checks one server at a time")
+		return 1
+	}
+	if opts.all || len(opts.servers) > 0 {
+		if tool == "" || server != "" {
+			fmt.Fprintln(os.Stderr, "usage: mcpshim call --tool <tool> --all|--servers a,b [--flag value ...]")
+			return 1
+		}
+		if opts.verbose {
+			fmt.Fprintln(os.Stderr, "--verbose traces one server at a time")
+			return 1
+		}
+		dynamicArgs := parseDynamicArgs(rest)
+		for key, value := range opts.stringArgs {
+			dynamicArgs[key] = value
+		}
+		addFileArgs(dynamicArgs, fileArgs)
+		resp, err := call(protocol.Request{Action: "call", ID: opts.callID, Tool: tool, All: opts.all, Servers: opts.servers, Args: dynamicArgs, Stream: opts.progress, NoCache: opts.noCache}, socket)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printCallResponse(resp, opts, tool, out)
+	}
+	// --url/--transport/--command only describe an ad-hoc endpoint when no
+	// --server is given, so tools with a "url" argument keep working
+	var endpoint adHocEndpoint
+	if server == "" && tool != "" {
+		endpoint, rest, err = extractAdHocEndpoint(rest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if endpoint.isSet() {
+		if opts.dryRun {
+			fmt.Fprintln(os.Stderr, "--dry-run needs a configured --server to check arguments against")
+			return 1
+		}
+		dynamicArgs := parseDynamicArgs(rest)
+		addFileArgs(dynamicArgs, fileArgs)
+		resp, err := call(protocol.Request{
+			Action:    "call",
+			ID:        opts.callID,
+			Tool:      tool,
+			URL:       endpoint.url,
+			Transport: endpoint.transport,
+			Headers:   map[string]string(endpoint.headers),
+			Command:   []string(endpoint.command),
+			Env:       []string(endpoint.env),
+			Args:      dynamicArgs,
+			Verbose:   opts.verbose,
+			Stream:    opts.progress,
+		}, socket)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printCallResponse(resp, opts, tool, out)
+	}
 	if server == "" && len(rest) > 0 {
 		server = rest[0]
 		rest = rest[1:]
@@ -354,87 +1231,802 @@ func runCall(args []string, socket string, jsonOut bool) int {
 		return 1
 	}
 
-	if helpRequested {
+	if opts.help {
 		return printCallHelp(server, tool, socket)
 	}
 
+	rawArgs := rawDynamicArgs(rest)
 	dynamicArgs := parseDynamicArgs(rest)
-	if detail, err := fetchToolDetail(server, tool, socket); err == nil && detail != nil {
-		missing := []string{}
-		for _, p := range detail.Properties {
-			if p.Required {
-				if _, ok := dynamicArgs[p.Name]; !ok {
-					missing = append(missing, p.Name)
-				}
+	detail, detailErr := fetchToolDetail(server, tool, socket)
+	keepStringTypedArgs(dynamicArgs, rawArgs, detail)
+	for key, value := range opts.stringArgs {
+		rawArgs[key] = value
+		dynamicArgs[key] = value
+	}
+	addFileArgs(dynamicArgs, fileArgs)
+	if opts.explain {
+		printArgExplanation(rawArgs, dynamicArgs, detail)
+	}
+	if opts.dryRun {
+		return printDryRun(server, tool, dynamicArgs, detail, detailErr, out)
+	}
+	if detailErr == nil && detail != nil {
+		missing := missingRequired(detail, dynamicArgs)
+		if len(missing) > 0 && opts.interactive && stdinIsTerminal() {
+			answers, err := promptForArgs(os.Stdin, os.Stderr, missing)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			for key, value := range answers {
+				dynamicArgs[key] = value
 			}
+			missing = nil
 		}
 		if len(missing) > 0 {
 			fmt.Fprintf(os.Stderr, "missing required argument(s):")
-			for _, name := range missing {
-				fmt.Fprintf(os.Stderr, " --%s", name)
+			for _, p := range missing {
+				fmt.Fprintf(os.Stderr, " --%s", p.Name)
 			}
 			fmt.Fprintln(os.Stderr)
 			fmt.Fprintln(os.Stderr)
 			printCallHelpFromDetail(detail)
 			return 1
 		}
+		if problems := validateArgs(detail, dynamicArgs); len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			return 1
+		}
 	}
 
-	resp, err := call(protocol.Request{Action: "call", Server: server, Tool: tool, Args: dynamicArgs}, socket)
+	resp, err := call(protocol.Request{Action: "call", ID: opts.callID, Server: server, Tool: tool, Args: dynamicArgs, Verbose: opts.verbose, Stream: opts.progress, NoCache: opts.noCache}, socket)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	if parseTextJSON {
+	return printCallResponse(resp, opts, tool, out)
+}
+
+func missingRequired(detail *protocol.ToolDetail, args map[string]interface{}) []protocol.PropertyDetail {
+	missing := []protocol.PropertyDetail{}
+	for _, p := range detail.Properties {
+		if p.Required {
+			if _, ok := args[p.Name]; !ok {
+				missing = append(missing, p)
+			}
+		}
+	}
+	return missing
+}
+
+type dryRunPayload struct {
+	Server string                 `json:"server"`
+	Tool   string                 `json:"tool"`
+	Args   map[string]interface{} `json:"args"`
+}
+
+// checks each argument the schema describes against its type, enum and
+// const; a value the daemon would coerce, such as "5" for an integer,
+// passes, and arguments the schema does not mention are left alone
+func validateArgs(detail *protocol.ToolDetail, args map[string]interface{}) []string {
+	var problems []string
+	for _, p := range detail.Properties {
+		value, ok := args[p.Name]
+		if !ok {
+			continue
+		}
+		if p.Type != "" && !matchesSchemaType(value, p.Type) {
+			problems = append(problems, fmt.Sprintf("argument --%s must be %s", p.Name, strings.ReplaceAll(p.Type, "|", " or ")))
+			continue
+		}
+		if !isScalarArg(value) {
+			continue
+		}
+		if len(p.Enum) > 0 && !slices.ContainsFunc(p.Enum, func(want string) bool { return scalarMatches(value, want) }) {
+			problems = append(problems, fmt.Sprintf("argument --%s must be one of %s", p.Name, strings.Join(p.Enum, "|")))
+			continue
+		}
+		if p.Const != "" && !scalarMatches(value, p.Const) {
+			problems = append(problems, fmt.Sprintf("argument --%s must be %s", p.Name, p.Const))
+		}
+	}
+	return problems
+}
+
+// typ may be a union such as "string|null"; types it does not know pass
+func matchesSchemaType(value interface{}, typ string) bool {
+	for _, t := range strings.Split(typ, "|") {
+		switch t {
+		case "string":
+			if isScalarArg(value) && value != nil {
+				return true
+			}
+		case "integer":
+			switch v := value.(type) {
+			case int64:
+				return true
+			case float64:
+				if v == math.Trunc(v) {
+					return true
+				}
+			case string:
+				if _, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+					return true
+				}
+			}
+		case "number":
+			switch v := value.(type) {
+			case int64, float64:
+				return true
+			case string:
+				if _, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					return true
+				}
+			}
+		case "boolean":
+			switch v := value.(type) {
+			case bool:
+				return true
+			case string:
+				if _, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+					return true
+				}
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func isScalarArg(value interface{}) bool {
+	switch value.(type) {
+	case nil, string, bool, int64, float64:
+		return true
+	}
+	return false
+}
+
+// enum and const values arrive formatted with %v from decoded json, where
+// every number is a float64
+func scalarMatches(value interface{}, want string) bool {
+	switch v := value.(type) {
+	case int64:
+		return fmt.Sprintf("%v", float64(v)) == want
+	case string:
+		if v == want {
+			return true
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return fmt.Sprintf("%v", f) == want
+		}
+		return false
+	}
+	return fmt.Sprintf("%v", value) == want
+}
+
+// prints what call would send and checks it against the tool's schema; the
+// schema still comes from the daemon, but the call itself is never sent
+func printDryRun(server, tool string, args map[string]interface{}, detail *protocol.ToolDetail, detailErr error, out outputOptions) int {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	if err := out.encode(dryRunPayload{Server: server, Tool: tool, Args: args}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if detailErr != nil || detail == nil {
+		if detailErr == nil {
+			detailErr = errors.New("no schema returned")
+		}
+		fmt.Fprintf(os.Stderr, "required arguments not checked: %v\n", detailErr)
+		return 1
+	}
+	missing := missingRequired(detail, args)
+	problems := validateArgs(detail, args)
+	if len(missing) == 0 && len(problems) == 0 {
+		fmt.Fprintln(os.Stderr, "arguments: ok")
+		return 0
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "missing required argument(s):")
+		for _, p := range missing {
+			fmt.Fprintf(os.Stderr, " --%s", p.Name)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	return 1
+}
+
+// the file, or stdin for "-", has to hold a single json object
+func readArgsFile(path string, stdin io.Reader) (map[string]interface{}, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read --args-file: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid --args-file %s: %w", path, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid --args-file %s: more than one json value", path)
+	}
+	object, ok := plainNumbers(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid --args-file %s: expected a json object, got %s", path, jsonKind(value))
+	}
+	return object, nil
+}
+
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
+
+// flags given on the command line win over the same keys from --args-file
+func addFileArgs(args map[string]interface{}, fileArgs map[string]interface{}) {
+	for key, value := range fileArgs {
+		if _, ok := args[key]; !ok {
+			args[key] = value
+		}
+	}
+}
+
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func stderrIsTerminal() bool {
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func promptForArgs(in io.Reader, w io.Writer, props []protocol.PropertyDetail) (map[string]interface{}, error) {
+	reader := bufio.NewReader(in)
+	answers := make(map[string]interface{}, len(props))
+	for _, p := range props {
+		label := p.Name
+		if p.Type != "" {
+			label += " (" + p.Type + ")"
+		}
+		if p.Description != "" {
+			label += ": " + summarizeDescription(p.Description)
+		}
+		fmt.Fprintln(w, label)
+		if len(p.Enum) > 0 {
+			fmt.Fprintf(w, "  one of: %s\n", strings.Join(p.Enum, ", "))
+		}
+		for {
+			fmt.Fprintf(w, "%s> ", p.Name)
+			line, err := reader.ReadString('\n')
+			value := strings.TrimSpace(line)
+			if err != nil && (err != io.EOF || value == "") {
+				return nil, fmt.Errorf("reading --%s: %w", p.Name, err)
+			}
+			switch {
+			case value == "":
+				fmt.Fprintln(w, "  a value is required")
+				continue
+			case len(p.Enum) > 0 && !slices.Contains(p.Enum, value):
+				fmt.Fprintf(w, "  expected one of: %s\n", strings.Join(p.Enum, ", "))
+				continue
+			}
+			// answers convert like flag values, except string-typed properties stay verbatim
+			if p.Type == "string" {
+				answers[p.Name] = value
+			} else {
+				answers[p.Name] = normalize(value)
+			}
+			break
+		}
+	}
+	return answers, nil
+}
+
+func printExchange(w io.Writer, steps []protocol.ExchangeStep) {
+	for _, step := range steps {
+		fmt.Fprintf(w, "--> %s\n", step.Method)
+		writeExchangeJSON(w, step.Request)
+		if step.Error != "" {
+			fmt.Fprintf(w, "<-- %s failed after %dms: %s\n", step.Method, step.DurationMs, step.Error)
+			continue
+		}
+		fmt.Fprintf(w, "<-- %s %dms\n", step.Method, step.DurationMs)
+		writeExchangeJSON(w, step.Response)
+	}
+}
+
+func writeExchangeJSON(w io.Writer, value interface{}) {
+	if value == nil {
+		return
+	}
+	data, err := json.MarshalIndent(value, "    ", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "    %s\n", data)
+}
+
+func printCallResponse(resp *protocol.Response, opts callOptions, tool string, out outputOptions) int {
+	if len(resp.Exchange) > 0 {
+		// the exchange goes to stderr so stdout stays the plain result
+		printExchange(os.Stderr, resp.Exchange)
+		resp.Exchange = nil
+	}
+	if opts.saveBlobsDir != "" && resp.OK {
+		result, paths, err := saveContentBlobs(resp.Result, opts.saveBlobsDir, tool)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		resp.Result = result
+		for _, path := range paths {
+			fmt.Fprintln(os.Stderr, path)
+		}
+	}
+	if opts.parseTextJSON {
 		resp.Result = parseJSONLikeContentText(resp.Result)
 	}
-	return printResponse(resp, jsonOut)
+	code := printResponse(resp, out)
+	if code != 0 || len(opts.expect) == 0 {
+		return code
+	}
+	results := map[string]interface{}{"": resp.Result}
+	if opts.all || len(opts.servers) > 0 {
+		// fan-out results are keyed by server and every one has to pass
+		results = map[string]interface{}{}
+		byServer, _ := resp.Result.(map[string]interface{})
+		for name, item := range byServer {
+			if entry, ok := item.(map[string]interface{}); ok && entry["ok"] == true {
+				results[name] = entry["result"]
+			}
+		}
+	}
+	for name, result := range results {
+		root := expectRoot(result)
+		for _, e := range opts.expect {
+			if got, ok := e.check(root); !ok {
+				prefix := ""
+				if name != "" {
+					prefix = name + ": "
+				}
+				data, _ := json.Marshal(got)
+				fmt.Fprintf(os.Stderr, "%sexpectation failed: %s (got %s)\n", prefix, e.raw, data)
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+type expectation struct {
+	raw  string
+	path []interface{}
+	op   string
+	want interface{}
+}
+
+func parseExpectation(expr string) (expectation, error) {
+	e := expectation{raw: strings.TrimSpace(expr)}
+	lhs := e.raw
+	if i := strings.IndexAny(e.raw, "=!"); i >= 0 {
+		if i+1 >= len(e.raw) || e.raw[i+1] != '=' {
+			return expectation{}, fmt.Errorf("invalid --expect %q: expected == or !=", expr)
+		}
+		e.op = e.raw[i : i+2]
+		lhs = strings.TrimSpace(e.raw[:i])
+		literal := strings.TrimSpace(e.raw[i+2:])
+		if err := json.Unmarshal([]byte(literal), &e.want); err != nil {
+			return expectation{}, fmt.Errorf("invalid --expect %q: %s is not a JSON value (quote strings)", expr, literal)
+		}
+	}
+	if !strings.HasPrefix(lhs, ".") {
+		return expectation{}, fmt.Errorf("invalid --expect %q: path must start with '.'", expr)
+	}
+	rest := lhs[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return expectation{}, fmt.Errorf("invalid --expect %q: unclosed [", expr)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return expectation{}, fmt.Errorf("invalid --expect %q: bad index %q", expr, rest[1:end])
+			}
+			e.path = append(e.path, index)
+			rest = rest[end+1:]
+		default:
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return expectation{}, fmt.Errorf("invalid --expect %q: empty key", expr)
+			}
+			e.path = append(e.path, rest[:end])
+			rest = rest[end:]
+		}
+	}
+	return e, nil
+}
+
+func (e expectation) check(root interface{}) (interface{}, bool) {
+	value, found := root, true
+	for _, step := range e.path {
+		switch key := step.(type) {
+		case string:
+			obj, ok := value.(map[string]interface{})
+			if value, found = nil, false; ok {
+				value, found = obj[key]
+			}
+		case int:
+			list, ok := value.([]interface{})
+			if value, found = nil, false; ok && key < len(list) {
+				value, found = list[key], true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	switch e.op {
+	case "==":
+		return value, found && reflect.DeepEqual(value, e.want)
+	case "!=":
+		return value, !found || !reflect.DeepEqual(value, e.want)
+	default:
+		return value, found && value != nil && value != false
+	}
+}
+
+func expectRoot(result interface{}) interface{} {
+	// expressions address the tool's payload: structured content when the
+	// server sends it, else JSON in the first text block
+	root, ok := result.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	if structured, ok := root["structuredContent"]; ok && structured != nil {
+		return structured
+	}
+	blocks, _ := root["content"].([]interface{})
+	if len(blocks) == 0 {
+		return result
+	}
+	block, _ := blocks[0].(map[string]interface{})
+	switch text := block["text"].(type) {
+	case string:
+		if parsed, ok := tryParseJSONValue(text); ok {
+			return parsed
+		}
+	case map[string]interface{}, []interface{}:
+		// already parsed by --json
+		return text
+	}
+	return result
+}
+
+func saveContentBlobs(result interface{}, dir string, prefix string) (interface{}, []string, error) {
+	root, ok := result.(map[string]interface{})
+	if !ok {
+		return result, nil, nil
+	}
+	blocks, ok := root["content"].([]interface{})
+	if !ok {
+		return result, nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	paths := []string{}
+	for _, item := range blocks {
+		block, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// image and audio blocks carry data inline, embedded resources
+		// carry a blob under resource
+		holder, key := block, "data"
+		if resource, ok := block["resource"].(map[string]interface{}); ok {
+			holder, key = resource, "blob"
+		}
+		encoded, ok := holder[key].(string)
+		if !ok || encoded == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode %s content: %w", block["type"], err)
+		}
+		mimeType, _ := holder["mimeType"].(string)
+		ext := ".bin"
+		if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+		f, err := os.CreateTemp(dir, prefix+"-*"+ext)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		delete(holder, key)
+		holder["path"] = f.Name()
+		if mimeType == "" {
+			holder["mimeType"] = "application/octet-stream"
+		}
+		paths = append(paths, f.Name())
+	}
+	return root, paths, nil
+}
+
+type callOptions struct {
+	server        string
+	tool          string
+	callID        string
+	rest          []string
+	help          bool
+	parseTextJSON bool
+	explain       bool
+	saveBlobsDir  string
+	stringArgs    map[string]string
+	interactive   bool
+	all           bool
+	servers       []string
+	expect        []expectation
+	verbose       bool
+	template      string
+	argsFile      string
+	progress      bool
+	noCache       bool
+	dryRun        bool
+}
+
+func parseCallArgs(args []string) (callOptions, error) {
+	opts := callOptions{rest: make([]string, 0, len(args)), stringArgs: map[string]string{}}
+	passthrough := false
+	for i := 0; i < len(args); i++ {
+		item := args[i]
+		if passthrough {
+			opts.rest = append(opts.rest, item)
+			continue
+		}
+		switch {
+		case item == "--":
+			passthrough = true
+		case item == "--help" || item == "-h":
+			opts.help = true
+		case item == "--explain":
+			opts.explain = true
+		case item == "--interactive":
+			opts.interactive = true
+		case item == "--verbose":
+			opts.verbose = true
+		case item == "--progress":
+			opts.progress = true
+		case item == "--no-cache":
+			opts.noCache = true
+		case item == "--dry-run":
+			opts.dryRun = true
+		case item == "--all":
+			opts.all = true
+		case item == "--servers" || strings.HasPrefix(item, "--servers="):
+			value := strings.TrimPrefix(item, "--servers=")
+			if item == "--servers" {
+				if i+1 >= len(args) {
+					return callOptions{}, errors.New("missing value for --servers")
+				}
+				value = args[i+1]
+				i++
+			}
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					opts.servers = append(opts.servers, name)
+				}
+			}
+		case item == "--expect" || strings.HasPrefix(item, "--expect="):
+			value := strings.TrimPrefix(item, "--expect=")
+			if item == "--expect" {
+				if i+1 >= len(args) {
+					return callOptions{}, errors.New("missing value for --expect")
+				}
+				value = args[i+1]
+				i++
+			}
+			expect, err := parseExpectation(value)
+			if err != nil {
+				return callOptions{}, err
+			}
+			opts.expect = append(opts.expect, expect)
+		case item == "--json":
+			opts.parseTextJSON = true
+		case item == "--json=true":
+			opts.parseTextJSON = true
+		case item == "--json=false":
+			opts.parseTextJSON = false
+		case item == "--server":
+			if i+1 >= len(args) {
+				return callOptions{}, errors.New("missing value for --server")
+			}
+			opts.server = args[i+1]
+			i++
+		case item == "--tool":
+			if i+1 >= len(args) {
+				return callOptions{}, errors.New("missing value for --tool")
+			}
+			opts.tool = args[i+1]
+			i++
+		case item == "--call-id":
+			if i+1 >= len(args) {
+				return callOptions{}, errors.New("missing value for --call-id")
+			}
+			opts.callID = args[i+1]
+			i++
+		case strings.HasPrefix(item, "--server="):
+			opts.server = strings.TrimPrefix(item, "--server=")
+		case strings.HasPrefix(item, "--tool="):
+			opts.tool = strings.TrimPrefix(item, "--tool=")
+		case strings.HasPrefix(item, "--call-id="):
+			opts.callID = strings.TrimPrefix(item, "--call-id=")
+		case item == "--template" || strings.HasPrefix(item, "--template="):
+			value := strings.TrimPrefix(item, "--template=")
+			if item == "--template" {
+				if i+1 >= len(args) {
+					return callOptions{}, errors.New("missing value for --template")
+				}
+				value = args[i+1]
+				i++
+			}
+			opts.template = value
+		case item == "--args-file" || strings.HasPrefix(item, "--args-file="):
+			value := strings.TrimPrefix(item, "--args-file=")
+			if item == "--args-file" {
+				if i+1 >= len(args) {
+					return callOptions{}, errors.New("missing value for --args-file")
+				}
+				value = args[i+1]
+				i++
+			}
+			opts.argsFile = value
+		case item == "--save-blobs":
+			if i+1 >= len(args) {
+				return callOptions{}, errors.New("missing value for --save-blobs")
+			}
+			opts.saveBlobsDir = args[i+1]
+			i++
+		case strings.HasPrefix(item, "--save-blobs="):
+			opts.saveBlobsDir = strings.TrimPrefix(item, "--save-blobs=")
+		case item == "--str" || strings.HasPrefix(item, "--str="):
+			value := strings.TrimPrefix(item, "--str=")
+			if item == "--str" {
+				if i+1 >= len(args) {
+					return callOptions{}, errors.New("missing value for --str")
+				}
+				value = args[i+1]
+				i++
+			}
+			key, val, ok := strings.Cut(value, "=")
+			if !ok || key == "" {
+				return callOptions{}, fmt.Errorf("invalid --str %q, expected key=value", value)
+			}
+			opts.stringArgs[key] = val
+		default:
+			opts.rest = append(opts.rest, item)
+		}
+	}
+	return opts, nil
+}
+
+type adHocEndpoint struct {
+	url       string
+	transport string
+	headers   headerArgs
+	command   stringSliceFlag
+	env       envArgs
+}
+
+func (e adHocEndpoint) isSet() bool {
+	return e.url != "" || len(e.command) > 0
 }
 
-func parseCallArgs(args []string) (server string, tool string, rest []string, help bool, parseTextJSON bool, err error) {
-	rest = make([]string, 0, len(args))
-	passthrough := false
+func extractAdHocEndpoint(args []string) (adHocEndpoint, []string, error) {
+	var endpoint adHocEndpoint
+	rest := make([]string, 0, len(args))
 	for i := 0; i < len(args); i++ {
 		item := args[i]
-		if passthrough {
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(item, "--"), "=")
+		if !strings.HasPrefix(item, "--") {
 			rest = append(rest, item)
 			continue
 		}
-		switch {
-		case item == "--":
-			passthrough = true
-		case item == "--help" || item == "-h":
-			help = true
-		case item == "--json":
-			parseTextJSON = true
-		case item == "--json=true":
-			parseTextJSON = true
-		case item == "--json=false":
-			parseTextJSON = false
-		case item == "--server":
-			if i+1 >= len(args) {
-				return "", "", nil, false, false, errors.New("missing value for --server")
-			}
-			server = args[i+1]
-			i++
-		case item == "--tool":
+		switch name {
+		case "url", "transport", "command", "header", "env":
+		default:
+			rest = append(rest, item)
+			continue
+		}
+		if !hasValue {
 			if i+1 >= len(args) {
-				return "", "", nil, false, false, errors.New("missing value for --tool")
+				return adHocEndpoint{}, nil, fmt.Errorf("missing value for --%s", name)
 			}
-			tool = args[i+1]
+			value = args[i+1]
 			i++
-		case strings.HasPrefix(item, "--server="):
-			server = strings.TrimPrefix(item, "--server=")
-		case strings.HasPrefix(item, "--tool="):
-			tool = strings.TrimPrefix(item, "--tool=")
-		default:
-			rest = append(rest, item)
 		}
+		var err error
+		switch name {
+		case "url":
+			endpoint.url = value
+		case "transport":
+			endpoint.transport = value
+		case "command":
+			err = endpoint.command.Set(value)
+		case "header":
+			err = endpoint.headers.Set(value)
+		case "env":
+			err = endpoint.env.Set(value)
+		}
+		if err != nil {
+			return adHocEndpoint{}, nil, err
+		}
+	}
+	if endpoint.transport == "" && len(endpoint.command) > 0 && endpoint.url == "" {
+		endpoint.transport = "stdio"
 	}
-	return server, tool, rest, help, parseTextJSON, nil
+	return endpoint, rest, nil
 }
 
 func parseJSONLikeContentText(result interface{}) interface{} {
+	// a bare string result is the whole payload, so it wins over walking for
+	// nested content[].text fields
+	if text, ok := result.(string); ok {
+		if parsed, ok := tryParseJSONValue(text); ok {
+			return parsed
+		}
+		return result
+	}
 	value, _ := walkAndParseJSONText(result)
 	return value
 }
@@ -512,8 +2104,14 @@ func printCallHelp(server, tool, socket string) int {
 	} else {
 		fmt.Println("usage: mcpshim call --server <name> --tool <tool> [--json] [--arg value ...]")
 	}
+	fmt.Println("       --call-id assigns an id that `mcpshim cancel <id>` can abort")
 	fmt.Println("       mcpshim call --server <name> --tool <tool> -- [--reserved-arg value ...]")
-	fmt.Println("       --json parses JSON-like content text fields in tool results")
+	fmt.Println("       --json parses a JSON string result, or else JSON-like content[].text fields")
+	fmt.Println("       --explain prints how each argument was coerced against the tool schema")
+	fmt.Println("       --str key=value passes value as a string without numeric/boolean coercion")
+	fmt.Println("       --args-file path reads a JSON object of arguments (- for stdin); flags override its keys")
+	fmt.Println("       --no-cache skips a cached result for a tool marked cacheable")
+	fmt.Println("       --dry-run prints the arguments that would be sent and checks them, without calling")
 	fmt.Println()
 	detail, err := fetchToolDetail(server, tool, socket)
 	if err != nil {
@@ -524,6 +2122,86 @@ func printCallHelp(server, tool, socket string) int {
 	return 0
 }
 
+func keepStringTypedArgs(args map[string]interface{}, raw map[string]string, detail *protocol.ToolDetail) {
+	if detail == nil {
+		return
+	}
+	for _, p := range detail.Properties {
+		if p.Type != "string" {
+			continue
+		}
+		if value, ok := raw[p.Name]; ok {
+			args[p.Name] = value
+		}
+	}
+}
+
+func printArgExplanation(raw map[string]string, args map[string]interface{}, detail *protocol.ToolDetail) {
+	schemaTypes := map[string]string{}
+	if detail != nil {
+		for _, p := range detail.Properties {
+			schemaTypes[p.Name] = p.Type
+		}
+	}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintln(os.Stderr, "explain:")
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "  (no arguments)")
+	}
+	for _, key := range keys {
+		value := args[key]
+		inferred := argTypeName(value)
+		expected, known := schemaTypes[key]
+		schema := expected
+		switch {
+		case detail == nil:
+			schema = "(schema unavailable)"
+		case !known:
+			schema = "(not in schema)"
+		case expected == "":
+			schema = "any"
+		}
+		line := fmt.Sprintf("  --%-20s %q -> %s %v  schema: %s", key, raw[key], inferred, value, schema)
+		if known && conflictsWithSchema(value, expected) {
+			line += "  CONFLICT"
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func argTypeName(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func conflictsWithSchema(value interface{}, expected string) bool {
+	actual := argTypeName(value)
+	switch expected {
+	case "", actual:
+		return false
+	case "number":
+		return actual != "integer"
+	default:
+		return true
+	}
+}
+
 func fetchToolDetail(server, tool, socket string) (*protocol.ToolDetail, error) {
 	resp, err := call(protocol.Request{Action: "inspect", Server: server, Tool: tool}, socket)
 	if err != nil {
@@ -555,16 +2233,7 @@ func printCallHelpFromDetail(d *protocol.ToolDetail) {
 			if p.Required {
 				req = " (required)"
 			}
-			typ := p.Type
-			if typ == "" {
-				typ = "any"
-			}
-			if len(p.Enum) > 0 {
-				typ += " enum(" + strings.Join(p.Enum, "|") + ")"
-			}
-			if p.Const != "" {
-				typ += " const(" + p.Const + ")"
-			}
+			typ := propertyType(p)
 			if p.Description != "" {
 				descLines := splitNonEmptyLines(p.Description)
 				first := ""
@@ -578,7 +2247,62 @@ func printCallHelpFromDetail(d *protocol.ToolDetail) {
 			} else {
 				fmt.Printf("  --%-20s %s%s\n", p.Name, typ, req)
 			}
+			printNestedProperties(p.Properties, "      ")
+		}
+	}
+	printOutputProperties(d.OutputProperties)
+}
+
+func propertyType(p protocol.PropertyDetail) string {
+	typ := p.Type
+	if typ == "" {
+		typ = "any"
+	}
+	if p.Items != "" {
+		typ += " of " + p.Items
+	}
+	if len(p.Enum) > 0 {
+		typ += " enum(" + strings.Join(p.Enum, "|") + ")"
+	}
+	if p.Const != "" {
+		typ += " const(" + p.Const + ")"
+	}
+	return typ
+}
+
+// the fields of an object argument, or of each element of an array of
+// objects, indented under their parent with the types still in one column
+func printNestedProperties(props []protocol.PropertyDetail, indent string) {
+	for _, p := range props {
+		line := fmt.Sprintf("%s%-*s %s", indent, max(24-len(indent), len(p.Name)), p.Name, propertyType(p))
+		if p.Required {
+			line += " (required)"
+		}
+		if lines := splitNonEmptyLines(p.Description); len(lines) > 0 {
+			line += " — " + lines[0]
+		}
+		fmt.Println(line)
+		printNestedProperties(p.Properties, indent+"  ")
+	}
+}
+
+// the fields a call returns in structuredContent; required ones are always set
+func printOutputProperties(props []protocol.PropertyDetail) {
+	if len(props) == 0 {
+		return
+	}
+	fmt.Println("\noutput:")
+	for _, p := range props {
+		typ := propertyType(p)
+		if p.Required {
+			typ += " (always)"
+		}
+		if p.Description != "" {
+			fmt.Printf("  %-22s %s — %s\n", p.Name, typ, strings.Join(splitNonEmptyLines(p.Description), " "))
+		} else {
+			fmt.Printf("  %-22s %s\n", p.Name, typ)
 		}
+		printNestedProperties(p.Properties, "    ")
 	}
 }
 
@@ -612,6 +2336,40 @@ func splitNonEmptyLines(text string) []string {
 	return out
 }
 
+func toolMarkdown(d *protocol.ToolDetail) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n", d.Name)
+	if desc := normalizeMultiline(d.Description); desc != "" {
+		fmt.Fprintf(&b, "\n%s\n", desc)
+	}
+	if len(d.Properties) == 0 {
+		b.WriteString("\nNo parameters.\n")
+		return b.String()
+	}
+	b.WriteString("\n| Parameter | Type | Required | Description |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, p := range d.Properties {
+		typ := p.Type
+		if typ == "" {
+			typ = "any"
+		}
+		if len(p.Enum) > 0 {
+			typ += " (" + strings.Join(p.Enum, ", ") + ")"
+		}
+		required := "no"
+		if p.Required {
+			required = "yes"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", p.Name, markdownCell(typ), required, markdownCell(p.Description))
+	}
+	return b.String()
+}
+
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(normalizeMultiline(text), "|", "\\|")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
 func printToolsList(items []protocol.ToolInfo, full bool) {
 	if len(items) == 0 {
 		return
@@ -673,6 +2431,159 @@ func printToolsList(items []protocol.ToolInfo, full bool) {
 	}
 }
 
+func sortServers(items []protocol.ServerInfo, by string) error {
+	var key func(protocol.ServerInfo) string
+	switch by {
+	case "", "name":
+		key = func(s protocol.ServerInfo) string { return "" }
+	case "alias":
+		key = func(s protocol.ServerInfo) string { return s.Alias }
+	case "transport":
+		key = func(s protocol.ServerInfo) string { return s.Transport }
+	default:
+		return fmt.Errorf("invalid --sort %q, expected name, alias or transport", by)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		ki, kj := key(items[i]), key(items[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return items[i].Name < items[j].Name
+	})
+	return nil
+}
+
+// enabled servers, or just the one picked by name or alias
+func countedServers(items []protocol.ServerInfo, server string) []string {
+	names := []string{}
+	for _, item := range items {
+		if item.Disabled {
+			continue
+		}
+		if server != "" && item.Name != server && item.Alias != server {
+			continue
+		}
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+// every server in seed is listed, at 0 when it has no tools
+func toolCounts(tools []protocol.ToolInfo, seed []string) (map[string]int, []string) {
+	counts := map[string]int{}
+	servers := []string{}
+	for _, name := range seed {
+		if _, ok := counts[name]; !ok {
+			counts[name] = 0
+			servers = append(servers, name)
+		}
+	}
+	for _, item := range tools {
+		if _, ok := counts[item.Server]; !ok {
+			servers = append(servers, item.Server)
+		}
+		counts[item.Server]++
+	}
+	sort.Strings(servers)
+	return counts, servers
+}
+
+func printToolCounts(resp *protocol.Response, seed []string, out outputOptions) int {
+	if !resp.OK {
+		fmt.Fprintln(os.Stderr, resp.Error)
+		return 1
+	}
+	if !out.structured() {
+		printStaleNotice(resp)
+	}
+	counts, servers := toolCounts(resp.Tools, seed)
+	if out.structured() {
+		_ = out.encode(map[string]interface{}{"servers": counts, "total": len(resp.Tools)})
+		return 0
+	}
+	for _, name := range servers {
+		fmt.Printf("%-30s  %d\n", name, counts[name])
+	}
+	fmt.Printf("%-30s  %d\n", "total", len(resp.Tools))
+	return 0
+}
+
+// one line per server even when some failed, so a probe's log shows which
+func printHealth(resp *protocol.Response, out outputOptions) int {
+	if out.structured() {
+		_ = out.encode(resp)
+	} else {
+		for _, h := range resp.Health {
+			state := "ok"
+			if !h.OK {
+				state = "FAIL"
+			}
+			line := fmt.Sprintf("%-30s  %-4s  %6dms", h.Server, state, h.LatencyMs)
+			if h.Error != "" {
+				line += "  " + h.Error
+			}
+			fmt.Println(line)
+		}
+		if !resp.OK {
+			fmt.Fprintln(os.Stderr, resp.Error)
+		}
+	}
+	if !resp.OK {
+		return 1
+	}
+	return 0
+}
+
+// always server/tool, since matches usually span servers
+func printSearchResults(resp *protocol.Response, out outputOptions) int {
+	if out.structured() || !resp.OK {
+		return printResponse(resp, out)
+	}
+	if len(resp.Tools) == 0 {
+		fmt.Fprintln(os.Stderr, "no matching tools")
+		return 1
+	}
+	for _, item := range resp.Tools {
+		name := item.Server + "/" + item.Name
+		if summary := summarizeDescription(item.Description); summary != "" {
+			fmt.Printf("%-30s  %s\n", name, summary)
+		} else {
+			fmt.Println(name)
+		}
+	}
+	return 0
+}
+
+func printStaleNotice(resp *protocol.Response) {
+	if !resp.Stale {
+		return
+	}
+	if resp.RefreshedAt != nil {
+		fmt.Fprintf(os.Stderr, "stale (refreshed %s)\n", formatAge(*resp.RefreshedAt))
+		return
+	}
+	fmt.Fprintln(os.Stderr, "stale (served from cache)")
+}
+
+// the age, and the time itself so it can be matched against logs
+func formatRefresh(t time.Time) string {
+	if t.IsZero() {
+		return formatAge(t)
+	}
+	return formatAge(t) + " (" + t.Local().Format(time.RFC3339) + ")"
+}
+
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	age := time.Since(t)
+	if age < time.Second {
+		return "just now"
+	}
+	return age.Round(time.Second).String() + " ago"
+}
+
 func summarizeDescription(input string) string {
 	text := normalizeMultiline(input)
 	if text == "" {
@@ -732,13 +2643,222 @@ func normalizeMultiline(input string) string {
 	for len(lines) > 0 && lines[0] == "" {
 		lines = lines[1:]
 	}
-	for len(lines) > 0 && lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func runLoginLocal(servers []string, manual bool, out outputOptions) int {
+	cfg, err := config.Load(config.DefaultConfigPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	dbStore, err := store.Open(cfg.Server.DBPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbStore.Close()
+
+	registry := mcp.NewRegistry(cfg, dbStore)
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Minute)
+	defer cancel()
+	// each login gets its own browser tab; they complete independently
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			errs[i] = registry.Login(ctx, server, manual)
+		}(i, server)
+	}
+	wg.Wait()
+
+	code := 0
+	for i, server := range servers {
+		if errs[i] != nil {
+			if len(servers) > 1 {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", server, errs[i])
+			} else {
+				fmt.Fprintln(os.Stderr, errs[i])
+			}
+			code = 1
+			continue
+		}
+		if !out.quiet {
+			fmt.Printf("oauth login completed for %s\n", server)
+		}
+	}
+	return code
+}
+
+// stdio servers have no oauth, so for them login is where their roots get
+// set; the servers that still need an oauth flow are returned
+func runLoginRoots(servers []string, roots []string, socketPath string, out outputOptions) ([]string, int) {
+	cfg, err := config.Load(config.DefaultConfigPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 1
+	}
+	oauth := make([]string, 0, len(servers))
+	for _, name := range servers {
+		s, ok := config.FindServer(cfg, name)
+		if !ok {
+			// the oauth flow reports the unknown server
+			oauth = append(oauth, name)
+			continue
+		}
+		stdio := s.Transport == "stdio"
+		dirs, err := absRoots(roots)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, 1
+		}
+		if len(dirs) == 0 && stdio {
+			if !stdinIsTerminal() {
+				fmt.Fprintf(os.Stderr, "server %q uses stdio transport; pass --root to set the directories it may access\n", s.Name)
+				return nil, 1
+			}
+			dirs, err = promptForRoots(os.Stdin, os.Stderr, s)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return nil, 1
+			}
+		}
+		if len(dirs) > 0 {
+			resp, err := call(protocol.Request{Action: "update_server", Name: s.Name, Roots: dirs}, socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return nil, 1
+			}
+			if !resp.OK {
+				fmt.Fprintln(os.Stderr, resp.Error)
+				return nil, 1
+			}
+			if !out.quiet {
+				fmt.Printf("roots for %s: %s\n", s.Name, strings.Join(dirs, ", "))
+			}
+		}
+		if !stdio {
+			oauth = append(oauth, name)
+		}
+	}
+	return oauth, 0
+}
+
+func promptForRoots(in io.Reader, w io.Writer, s config.MCPServer) ([]string, error) {
+	reader := bufio.NewReader(in)
+	fmt.Fprintf(w, "directories %s may access, one per line; finish with an empty line\n", s.Name)
+	if len(s.Roots) > 0 {
+		fmt.Fprintf(w, "  current: %s (an empty first line keeps them)\n", strings.Join(s.Roots, ", "))
+	}
+	var dirs []string
+	for {
+		fmt.Fprint(w, "root> ")
+		line, err := reader.ReadString('\n')
+		value := strings.TrimSpace(line)
+		if value == "" {
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("reading roots: %w", err)
+			}
+			break
+		}
+		resolved, rootErr := absRoots([]string{value})
+		if rootErr == nil {
+			rootErr = config.CheckRoots(resolved)
+		}
+		if rootErr != nil {
+			fmt.Fprintf(w, "  %v\n", rootErr)
+		} else {
+			dirs = append(dirs, resolved[0])
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(dirs) == 0 {
+		if len(s.Roots) > 0 {
+			return s.Roots, nil
+		}
+		return nil, fmt.Errorf("no directories given for %s", s.Name)
+	}
+	return dirs, nil
+}
+
+// the daemon may run in another directory, so roots travel as absolute paths
+func absRoots(roots []string) ([]string, error) {
+	out := make([]string, 0, len(roots))
+	for _, root := range roots {
+		if strings.HasPrefix(root, "file://") {
+			out = append(out, root)
+			continue
+		}
+		if root == "~" || strings.HasPrefix(root, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			root = filepath.Join(home, strings.TrimPrefix(root, "~"))
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, fmt.Errorf("root %q: %w", root, err)
+		}
+		out = append(out, abs)
+	}
+	return out, nil
+}
+
+func runHistoryLocal(dbPath string, q store.HistoryQuery, withTotal bool, out outputOptions) int {
+	dbStore, err := store.OpenReadOnly(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbStore.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	items, err := dbStore.ListHistory(ctx, q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp := &protocol.Response{OK: true, History: items}
+	if withTotal {
+		total, err := dbStore.CountHistory(ctx, q)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		resp.Total = &total
+	}
+	return printResponse(resp, out)
+}
+
+func runHistoryExportLocal(dbPath string, q store.HistoryQuery) int {
+	dbStore, err := store.OpenReadOnly(dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer dbStore.Close()
+
+	enc := json.NewEncoder(os.Stdout)
+	err = dbStore.StreamHistory(context.Background(), q, func(item protocol.HistoryItem) error {
+		return enc.Encode(item)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
-	return strings.Join(lines, "\n")
+	return 0
 }
 
-func runLoginLocal(server string, manual bool) int {
+func runLoginCheckLocal(server string, out outputOptions) int {
 	cfg, err := config.Load(config.DefaultConfigPath())
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -752,18 +2872,29 @@ func runLoginLocal(server string, manual bool) int {
 	defer dbStore.Close()
 
 	registry := mcp.NewRegistry(cfg, dbStore)
-	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	if err := registry.Login(ctx, server, manual); err != nil {
+	if err := registry.CheckLogin(ctx, server); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return 1
 	}
-	fmt.Printf("oauth login completed for %s\n", server)
+	if !out.quiet {
+		fmt.Printf("authenticated: %s\n", server)
+	}
 	return 0
 }
 
 func parseDynamicArgs(args []string) map[string]interface{} {
-	out := map[string]interface{}{}
+	raw := rawDynamicArgs(args)
+	out := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		out[key] = normalize(value)
+	}
+	return out
+}
+
+func rawDynamicArgs(args []string) map[string]string {
+	out := map[string]string{}
 	for i := 0; i < len(args); i++ {
 		item := args[i]
 		if !strings.HasPrefix(item, "--") {
@@ -772,15 +2903,15 @@ func parseDynamicArgs(args []string) map[string]interface{} {
 		key := strings.TrimPrefix(item, "--")
 		if strings.Contains(key, "=") {
 			parts := strings.SplitN(key, "=", 2)
-			out[parts[0]] = normalize(parts[1])
+			out[parts[0]] = parts[1]
 			continue
 		}
 		if i+1 < len(args) && !strings.HasPrefix(args[i+1], "--") {
-			out[key] = normalize(args[i+1])
+			out[key] = args[i+1]
 			i++
 			continue
 		}
-		out[key] = true
+		out[key] = "true"
 	}
 	return out
 }
@@ -798,7 +2929,30 @@ func normalize(v string) interface{} {
 	return v
 }
 
-func call(req protocol.Request, socketPath string) (*protocol.Response, error) {
+// set from client.auto_start and --auto-start; dial then starts a daemon
+// instead of failing when none answers
+var autoStart bool
+
+// how long a started daemon gets to open its socket
+const autoStartTimeout = 10 * time.Second
+
+func autoStartConfigured() bool {
+	cfg, err := config.Load(config.DefaultConfigPath())
+	return err == nil && cfg.Client.AutoStart
+}
+
+func dial(socketPath string) (net.Conn, error) {
+	conn, err := dialDaemon(socketPath)
+	if autoStart && daemonNotRunning(err) {
+		conn, err = startDaemon(socketPath)
+	}
+	if daemonNotRunning(err) {
+		return nil, fmt.Errorf("mcpshimd is not running (no socket at %s); start it with mcpshimd, set client.auto_start, or point --socket, MCPSHIM_SOCKET or MCPSHIM_PROFILE at a running daemon", socketPath)
+	}
+	return conn, err
+}
+
+func dialDaemon(socketPath string) (net.Conn, error) {
 	conn, err := net.DialTimeout("unix", socketPath, 4*time.Second)
 	if err != nil {
 		fallback := fallbackSocketPath(socketPath)
@@ -806,22 +2960,268 @@ func call(req protocol.Request, socketPath string) (*protocol.Response, error) {
 			conn, err = net.DialTimeout("unix", fallback, 4*time.Second)
 		}
 	}
+	return conn, err
+}
+
+func daemonNotRunning(err error) bool {
+	return errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func startDaemon(socketPath string) (net.Conn, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
+		return nil, err
+	}
+	// the daemon replaces whatever is at the socket path, so two clients
+	// starting one at the same time would cut each other off
+	lock, err := os.OpenFile(socketPath+".lock", os.O_CREATE|os.O_RDWR, 0o600)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-	_ = conn.SetDeadline(time.Now().Add(70 * time.Second))
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	if conn, err := dialDaemon(socketPath); !daemonNotRunning(err) {
+		return conn, err
+	}
 
-	enc := json.NewEncoder(conn)
-	dec := json.NewDecoder(conn)
-	if err := enc.Encode(req); err != nil {
+	bin, err := daemonBinary()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(bin, "--config", config.DefaultConfigPath(), "--socket", socketPath)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start mcpshimd: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.Now().Add(autoStartTimeout)
+	for {
+		conn, err := net.DialTimeout("unix", socketPath, time.Second)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case waitErr := <-exited:
+			return nil, fmt.Errorf("mcpshimd exited before opening %s (%v); run mcpshimd directly to see why", socketPath, waitErr)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("started %s but %s did not open within %s", bin, socketPath, autoStartTimeout)
+		}
+	}
+}
+
+// next to this binary first, so an install keeps its two halves together
+func daemonBinary() (string, error) {
+	if self, err := os.Executable(); err == nil {
+		candidate := filepath.Join(filepath.Dir(self), "mcpshimd")
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	bin, err := exec.LookPath("mcpshimd")
+	if err != nil {
+		return "", errors.New("auto-start: mcpshimd not found next to mcpshim or in PATH")
+	}
+	return bin, nil
+}
+
+func call(req protocol.Request, socketPath string) (*protocol.Response, error) {
+	sess, err := openSession(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	return sess.call(req)
+}
+
+// a connection kept open for several requests, so a loop of calls pays for
+// the dial once; the daemon answers them in order. After an error the
+// stream may be out of step, so the session should be closed
+type session struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+func openSession(socketPath string) (*session, error) {
+	conn, err := dial(socketPath)
+	if err != nil {
 		return nil, err
 	}
-	var resp protocol.Response
-	if err := dec.Decode(&resp); err != nil {
+	return &session{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+}
+
+func (s *session) Close() error {
+	return s.conn.Close()
+}
+
+func (s *session) call(req protocol.Request) (*protocol.Response, error) {
+	_ = s.conn.SetDeadline(time.Now().Add(70 * time.Second))
+	// an idle session has no deadline to run out
+	defer s.conn.SetDeadline(time.Time{})
+
+	req.Heartbeat = true
+	if err := s.enc.Encode(req); err != nil {
 		return nil, err
 	}
-	return &resp, nil
+	alive := false
+	for {
+		var resp protocol.Response
+		if err := s.dec.Decode(&resp); err != nil {
+			var netErr net.Error
+			if alive && errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, fmt.Errorf("mcpshimd stopped responding (no heartbeat for %s)", heartbeatTimeout)
+			}
+			return nil, err
+		}
+		if resp.Progress != nil {
+			printProgress(os.Stderr, resp.Progress)
+			alive = true
+			_ = s.conn.SetDeadline(time.Now().Add(heartbeatTimeout))
+			continue
+		}
+		if !resp.Heartbeat {
+			return &resp, nil
+		}
+		// without --call-id this is the only way to learn what to cancel;
+		// --progress asks for it even when stderr goes to a log
+		if resp.CallID != "" && req.ID == "" && (req.Stream || stderrIsTerminal()) {
+			fmt.Fprintf(os.Stderr, "call id %s\n", resp.CallID)
+		}
+		// the daemon is alive and still working; wait as long as it keeps
+		// sending heartbeats
+		alive = true
+		_ = s.conn.SetDeadline(time.Now().Add(heartbeatTimeout))
+	}
+}
+
+// one line per update, such as "notion: 3/10 (30%) indexing"
+func printProgress(w io.Writer, p *protocol.Progress) {
+	line := fmt.Sprintf("%g", p.Progress)
+	if p.Total > 0 {
+		line = fmt.Sprintf("%g/%g (%.0f%%)", p.Progress, p.Total, 100*p.Progress/p.Total)
+	}
+	if p.Message != "" {
+		line += " " + p.Message
+	}
+	if p.Server != "" {
+		line = p.Server + ": " + line
+	}
+	fmt.Fprintln(w, line)
+}
+
+func runStream(req protocol.Request, socketPath string, out outputOptions) int {
+	conn, err := dial(socketPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// one frame per line so the stream can be piped into jq or a loop
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		var resp protocol.Response
+		if err := dec.Decode(&resp); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0
+			}
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if !resp.OK {
+			fmt.Fprintln(os.Stderr, resp.Error)
+			return 1
+		}
+		if resp.Text != "" {
+			if !out.quiet {
+				fmt.Fprintln(os.Stderr, resp.Text)
+			}
+			continue
+		}
+		_ = enc.Encode(resp.Result)
+	}
+}
+
+func runStatusWatch(socketPath string, interval time.Duration, out outputOptions) int {
+	for {
+		status, statusErr := call(protocol.Request{Action: "status"}, socketPath)
+		var history []protocol.HistoryItem
+		if statusErr == nil {
+			if resp, err := call(protocol.Request{Action: "history", Limit: 200}, socketPath); err == nil && resp.OK {
+				history = resp.History
+			}
+		}
+		switch {
+		case out.structured() && statusErr == nil:
+			// one status object per tick, for piping into jq or a log
+			_ = out.encode(status)
+		case out.structured():
+			fmt.Fprintln(os.Stderr, statusErr)
+		default:
+			fmt.Print("\033[H\033[2J")
+			fmt.Printf("mcpshim status (every %s, ctrl-c to quit)\n\n", interval)
+			if statusErr != nil {
+				fmt.Printf("daemon unreachable: %v\n", statusErr)
+			} else if !status.OK {
+				fmt.Printf("error: %s\n", status.Error)
+			} else {
+				fmt.Print(renderStatusDashboard(status.Status, history, time.Now()))
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+func renderStatusDashboard(st *protocol.Status, history []protocol.HistoryItem, now time.Time) string {
+	if st == nil {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "uptime     %s\n", (time.Duration(st.UptimeSec) * time.Second).String())
+	fmt.Fprintf(&b, "servers    %d (%d cached)\n", st.ServerCount, st.CachedServerCount)
+	fmt.Fprintf(&b, "tools      %d\n", st.ToolCount)
+	fmt.Fprintf(&b, "refreshed  %s\n", formatRefresh(st.LastRefresh))
+	if len(st.OpenCircuits) > 0 {
+		fmt.Fprintf(&b, "circuits   open: %s\n", strings.Join(st.OpenCircuits, ", "))
+	}
+
+	calls, failed := 0, 0
+	failures := []protocol.HistoryItem{}
+	for _, h := range history {
+		if now.Sub(h.At) > time.Minute {
+			continue
+		}
+		calls++
+		if !h.Success {
+			failed++
+			failures = append(failures, h)
+		}
+	}
+	fmt.Fprintf(&b, "calls/min  %d (%d failed)\n", calls, failed)
+	if len(failures) > 0 {
+		b.WriteString("\nrecent failures:\n")
+		if len(failures) > 5 {
+			failures = failures[:5]
+		}
+		for _, h := range failures {
+			msg := strings.TrimSpace(strings.SplitN(h.Error, "\n", 2)[0])
+			if len(msg) > 80 {
+				msg = msg[:77] + "..."
+			}
+			fmt.Fprintf(&b, "  %s %s/%s: %s\n", h.At.Local().Format("15:04:05"), h.Server, h.Tool, msg)
+		}
+	}
+	return b.String()
 }
 
 func fallbackSocketPath(requested string) string {
@@ -835,32 +3235,263 @@ func fallbackSocketPath(requested string) string {
 	return strings.TrimSpace(cfg.Server.SocketPath)
 }
 
-func printResponse(resp *protocol.Response, jsonOut bool) int {
+// --output and --json win over MCPSHIM_OUTPUT, which wins over
+// client.default_output; without either, json is for pipes and text for terminals
+func defaultOutputFormat() (string, error) {
+	format := strings.TrimSpace(os.Getenv("MCPSHIM_OUTPUT"))
+	source := "MCPSHIM_OUTPUT"
+	if format == "" {
+		// a missing or broken config is reported by the commands that need it
+		if cfg, err := config.Load(config.DefaultConfigPath()); err == nil {
+			format, source = cfg.Client.DefaultOutput, "client.default_output"
+		}
+	}
+	if err := config.CheckOutputFormat(format); err != nil {
+		return "", fmt.Errorf("%s: %w", source, err)
+	}
+	if format == "" && isTerminal(os.Stdout.Fd()) {
+		return "text", nil
+	}
+	if format == "" {
+		return "json", nil
+	}
+	return format, nil
+}
+
+type outputOptions struct {
+	format    string
+	quiet     bool
+	indent    string
+	maxOutput int
+	template  *template.Template
+}
+
+func parseOutputTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("output").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// the template sees the response as its json form, so field names match
+// what --json prints (.ok, .result, .error) and numbers keep their digits
+func renderTemplate(w io.Writer, tmpl *template.Template, resp *protocol.Response) error {
+	decoded, err := jsonValue(resp)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, decoded); err != nil {
+		return err
+	}
+	text := b.String()
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	_, err = io.WriteString(w, text)
+	return err
+}
+
+// v as plain maps and slices keyed by its json field names, with numbers
+// kept as json.Number so large ids survive
+func jsonValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// json and yaml both print the whole response for other tools to read
+func (o outputOptions) structured() bool {
+	return o.format == "json" || o.format == "yaml"
+}
+
+func (o outputOptions) encode(v interface{}) error {
+	if o.format != "yaml" {
+		return o.encoder().Encode(v)
+	}
+	return encodeYAML(os.Stdout, v, len(o.indent))
+}
+
+// yaml gets the same keys as --json, since the protocol types only carry
+// json tags
+func encodeYAML(w io.Writer, v interface{}, indent int) error {
+	decoded, err := jsonValue(v)
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	// --compact has no meaning for yaml, so it keeps a readable indent
+	enc.SetIndent(max(indent, 2))
+	if err := enc.Encode(plainNumbers(decoded)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// json.Number values become the int64 or float64 that flag args parse to;
+// yaml would otherwise quote them like strings
+func plainNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = plainNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = plainNumbers(item)
+		}
+	case json.Number:
+		if n, err := value.Int64(); err == nil {
+			return n
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
+
+func (o outputOptions) encoder() *json.Encoder {
+	enc := json.NewEncoder(os.Stdout)
+	if o.indent != "" {
+		enc.SetIndent("", o.indent)
+	}
+	return enc
+}
+
+// text contents are printed as they are, one after another; binary ones
+// are only described, since --json carries their base64 data
+func printResourceContents(w io.Writer, result interface{}) {
+	body, _ := result.(map[string]interface{})
+	contents, _ := body["contents"].([]interface{})
+	for _, item := range contents {
+		content, _ := item.(map[string]interface{})
+		if text, ok := content["text"].(string); ok {
+			if len(contents) > 1 {
+				fmt.Fprintf(w, "==> %v <==\n", content["uri"])
+			}
+			masked, _ := maskBinaryText(text)
+			fmt.Fprint(w, masked)
+			if !strings.HasSuffix(text, "\n") {
+				fmt.Fprintln(w)
+			}
+			continue
+		}
+		blob, _ := content["blob"].(string)
+		mimeType, _ := content["mimeType"].(string)
+		if mimeType == "" {
+			mimeType = "unknown type"
+		}
+		fmt.Fprintf(w, "%v: binary (%s, %d bytes base64); use --json for the data\n", content["uri"], mimeType, len(blob))
+	}
+}
+
+// one block per message under its role, the way a chat transcript reads
+func printPrompt(w io.Writer, p *protocol.PromptResult) {
+	if p.Description != "" {
+		fmt.Fprintf(w, "# %s\n\n", p.Description)
+	}
+	for i, msg := range p.Messages {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "[%s]\n%s\n", msg.Role, strings.TrimRight(promptContentText(msg.Content), "\n"))
+	}
+}
+
+func promptContentText(content interface{}) string {
+	block, _ := content.(map[string]interface{})
+	kind, _ := block["type"].(string)
+	switch kind {
+	case "text":
+		text, _ := block["text"].(string)
+		masked, _ := maskBinaryText(text)
+		return fmt.Sprint(masked)
+	case "image", "audio":
+		data, _ := block["data"].(string)
+		return fmt.Sprintf("<%s, %v, %d bytes base64>", kind, block["mimeType"], len(data))
+	case "resource":
+		resource, _ := block["resource"].(map[string]interface{})
+		if text, ok := resource["text"].(string); ok {
+			return fmt.Sprintf("<resource %v>\n%s", resource["uri"], text)
+		}
+		return fmt.Sprintf("<resource %v, binary>", resource["uri"])
+	default:
+		data, _ := json.Marshal(content)
+		return string(data)
+	}
+}
+
+func printResponse(resp *protocol.Response, out outputOptions) int {
 	if resp == nil {
 		fmt.Fprintln(os.Stderr, "empty response")
 		return 1
 	}
-	if jsonOut {
-		enc := json.NewEncoder(os.Stdout)
-		enc.SetIndent("", "  ")
-		_ = enc.Encode(resp)
+	if out.template != nil && resp.OK {
+		if err := renderTemplate(os.Stdout, out.template, resp); err != nil {
+			fmt.Fprintf(os.Stderr, "--template: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if out.structured() {
+		if out.quiet && resp.OK && resp.Result != nil {
+			_ = out.encode(resp.Result)
+		} else {
+			_ = out.encode(resp)
+		}
 	} else {
 		if !resp.OK {
 			fmt.Fprintln(os.Stderr, resp.Error)
 			return 1
 		}
-		if resp.Text != "" {
+		if resp.Text != "" && !out.quiet {
 			fmt.Println(resp.Text)
 		}
-		if resp.Status != nil {
-			fmt.Printf("uptime=%ds servers=%d tools=%d\n", resp.Status.UptimeSec, resp.Status.ServerCount, resp.Status.ToolCount)
+		if resp.Status != nil && !out.quiet {
+			fmt.Printf("uptime=%ds servers=%d cached_servers=%d tools=%d last_refresh=%s\n", resp.Status.UptimeSec, resp.Status.ServerCount, resp.Status.CachedServerCount, resp.Status.ToolCount, formatRefresh(resp.Status.LastRefresh))
+			if len(resp.Status.OpenCircuits) > 0 {
+				fmt.Printf("open circuits: %s\n", strings.Join(resp.Status.OpenCircuits, ", "))
+			}
+		}
+		if len(resp.Connections) > 0 {
+			fmt.Printf("%-30s  %6s  %6s  %6s\n", "server", "active", "opened", "failed")
+			for _, c := range resp.Connections {
+				fmt.Printf("%-30s  %6d  %6d  %6d\n", c.Server, c.Active, c.Opened, c.Failed)
+			}
 		}
 		if len(resp.Servers) > 0 {
 			for _, s := range resp.Servers {
+				target := s.URL
 				if s.Transport == "stdio" {
-					fmt.Printf("%s (%s) %s\n", s.Name, s.Transport, strings.Join(s.Command, " "))
-				} else {
-					fmt.Printf("%s (%s) %s\n", s.Name, s.Transport, s.URL)
+					target = strings.Join(s.Command, " ")
+				}
+				if s.ImplName != "" {
+					target += fmt.Sprintf(" [%s]", strings.TrimSpace(s.ImplName+" "+s.ImplVersion))
+				}
+				if s.Disabled {
+					target += " [disabled]"
+				}
+				fmt.Printf("%s (%s) %s\n", s.Name, s.Transport, target)
+				if s.Circuit != "" {
+					fmt.Printf("  circuit %s after %d consecutive failures\n", s.Circuit, s.ConsecutiveFailures)
+				}
+				if s.Instructions != "" {
+					fmt.Printf("  instructions: %s\n", summarizeDescription(s.Instructions))
 				}
 			}
 		}
@@ -870,19 +3501,70 @@ func printResponse(resp *protocol.Response, jsonOut bool) int {
 				if !h.Success {
 					status = "error"
 				}
-				fmt.Printf("%s %s/%s %s (%dms)\n", h.At.Format(time.RFC3339), h.Server, h.Tool, status, h.DurationMs)
+				fmt.Printf("#%d %s %s/%s %s (%dms: connect %dms, call %dms)\n", h.ID, h.At.Format(time.RFC3339), h.Server, h.Tool, status, h.DurationMs, h.ConnectMs, h.CallMs)
 				if !h.Success && h.Error != "" {
 					fmt.Printf("  error: %s\n", h.Error)
 				}
-				if len(h.Args) > 0 {
+				if h.ArgsTruncated {
+					fmt.Printf("  args (truncated from %v bytes): %v\n", h.Args["_truncated_bytes"], h.Args["_preview"])
+				} else if len(h.Args) > 0 {
 					data, _ := json.Marshal(h.Args)
 					fmt.Printf("  args: %s\n", string(data))
 				}
 			}
 		}
+		if resp.Total != nil && !out.quiet {
+			fmt.Printf("%d of %d matching entries\n", len(resp.History), *resp.Total)
+		}
 		if len(resp.Tools) > 0 {
 			printToolsList(resp.Tools, false)
 		}
+		for _, p := range resp.Prompts {
+			fmt.Println(p.Name)
+			if p.Description != "" {
+				fmt.Printf("  description: %s\n", summarizeDescription(p.Description))
+			}
+			for _, arg := range p.Arguments {
+				req := ""
+				if arg.Required {
+					req = " (required)"
+				}
+				if arg.Description != "" {
+					fmt.Printf("  --arg %s=...%s — %s\n", arg.Name, req, arg.Description)
+				} else {
+					fmt.Printf("  --arg %s=...%s\n", arg.Name, req)
+				}
+			}
+		}
+		for _, r := range resp.Resources {
+			label := r.URI
+			if r.MIMEType != "" {
+				label += " (" + r.MIMEType + ")"
+			}
+			fmt.Println(label)
+			if r.Name != "" && r.Name != r.URI {
+				fmt.Printf("  name: %s\n", r.Name)
+			}
+			if r.Description != "" {
+				fmt.Printf("  description: %s\n", summarizeDescription(r.Description))
+			}
+		}
+		if resp.ToolDiff != nil {
+			d := resp.ToolDiff
+			fmt.Printf("server: %s (last change %s)\n", d.Server, formatAge(d.ChangedAt))
+			if len(d.Added)+len(d.Removed)+len(d.Changed) == 0 {
+				fmt.Println("no tool changes recorded")
+			}
+			for _, name := range d.Added {
+				fmt.Printf("+ %s\n", name)
+			}
+			for _, name := range d.Removed {
+				fmt.Printf("- %s\n", name)
+			}
+			for _, name := range d.Changed {
+				fmt.Printf("~ %s (schema changed)\n", name)
+			}
+		}
 		if resp.ToolDetail != nil {
 			d := resp.ToolDetail
 			fmt.Printf("server: %s\ntool:   %s\n", d.Server, d.Name)
@@ -900,21 +3582,34 @@ func printResponse(resp *protocol.Response, jsonOut bool) int {
 					if typ == "" {
 						typ = "any"
 					}
+					if p.Items != "" {
+						typ += " of " + p.Items
+					}
 					if p.Description != "" {
 						fmt.Printf("  --%-20s %s%s — %s\n", p.Name, typ, req, p.Description)
 					} else {
 						fmt.Printf("  --%-20s %s%s\n", p.Name, typ, req)
 					}
+					printNestedProperties(p.Properties, "      ")
 				}
 			}
+			printOutputProperties(d.OutputProperties)
+			if len(d.Meta) > 0 {
+				data, _ := json.MarshalIndent(d.Meta, "  ", "  ")
+				fmt.Printf("\n_meta:\n  %s\n", data)
+			}
 		}
 		if resp.Result != nil {
-			data, _ := json.MarshalIndent(resp.Result, "", "  ")
-			fmt.Println(string(data))
+			result, masked := maskBinaryText(resp.Result)
+			data, _ := json.MarshalIndent(result, "", "  ")
+			fmt.Println(truncateOutput(string(data), out.maxOutput))
+			if masked > 0 {
+				fmt.Fprintf(os.Stderr, "%d text value(s) looked binary and are shown as a hex preview; use --json for the full escaped value, or --save-blobs for image, audio and resource blocks\n", masked)
+			}
 		}
 	}
 	if !resp.OK {
-		if !jsonOut {
+		if !out.structured() {
 			fmt.Fprintln(os.Stderr, resp.Error)
 		}
 		return 1
@@ -922,6 +3617,74 @@ func printResponse(resp *protocol.Response, jsonOut bool) int {
 	return 0
 }
 
+// how many leading bytes of a binary-looking string the text output shows
+const binaryPreviewBytes = 16
+
+// a tool that returns raw bytes as "text" reaches us with invalid utf-8
+// already turned into U+FFFD; that, a NUL, or mostly control characters
+// means the value is not meant to be read
+func looksBinary(text string) bool {
+	if text == "" {
+		return false
+	}
+	if !utf8.ValidString(text) || strings.ContainsRune(text, utf8.RuneError) || strings.ContainsRune(text, 0) {
+		return true
+	}
+	control, total := 0, 0
+	for _, r := range text {
+		total++
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' || r == 0x7f {
+			control++
+		}
+	}
+	return control*10 > total
+}
+
+func maskBinaryText(value interface{}) (interface{}, int) {
+	switch v := value.(type) {
+	case string:
+		if !looksBinary(v) {
+			return v, 0
+		}
+		head, more := v, ""
+		if len(head) > binaryPreviewBytes {
+			head, more = head[:binaryPreviewBytes], " ..."
+		}
+		return fmt.Sprintf("<binary, %d bytes: % x%s>", len(v), head, more), 1
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		masked := 0
+		for key, item := range v {
+			var n int
+			out[key], n = maskBinaryText(item)
+			masked += n
+		}
+		return out, masked
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		masked := 0
+		for i, item := range v {
+			var n int
+			out[i], n = maskBinaryText(item)
+			masked += n
+		}
+		return out, masked
+	default:
+		return value, 0
+	}
+}
+
+func truncateOutput(text string, limit int) string {
+	if limit <= 0 || len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s\n...(truncated, %d more bytes)", text[:cut], len(text)-cut)
+}
+
 func printAliasScript(items []protocol.ServerInfo) {
 	fmt.Println("# source this in your shell")
 	for _, item := range items {
@@ -939,10 +3702,18 @@ func printAliasScript(items []protocol.ServerInfo) {
 	}
 }
 
-func installAliasScripts(dir string, items []protocol.ServerInfo) error {
+func installAliasScripts(dir string, items []protocol.ServerInfo, socket string) error {
 	if dir == "" {
 		return errors.New("directory is required")
 	}
+	// wrappers installed against a non-default daemon keep pointing at it,
+	// unless the caller picks one with MCPSHIM_SOCKET or MCPSHIM_PROFILE
+	envLines := ""
+	if socket != "" && (socket != config.DefaultSocketPath() || os.Getenv("MCPSHIM_SOCKET") != "" || config.Profile() != "") {
+		envLines = "if [ -z \"${MCPSHIM_SOCKET:-}\" ] && [ -z \"${MCPSHIM_PROFILE:-}\" ]; then\n" +
+			"  export MCPSHIM_SOCKET=" + shellQuote(socket) + "\n" +
+			"fi\n"
+	}
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return err
 	}
@@ -957,6 +3728,7 @@ func installAliasScripts(dir string, items []protocol.ServerInfo) error {
 		path := filepath.Join(dir, name)
 		content := "#!/usr/bin/env bash\n" +
 			"set -euo pipefail\n" +
+			envLines +
 			"if [ $# -lt 1 ]; then\n" +
 			"  mcpshim tools --server " + shellQuote(item.Name) + "\n" +
 			"  exit 1\n" +
@@ -991,21 +3763,42 @@ func isTerminal(fd uintptr) bool {
 }
 
 func usage() {
-	fmt.Println("mcpshim <command>")
-	fmt.Println("  servers")
-	fmt.Println("  tools [--server name] [--full]")
-	fmt.Println("  inspect --server name --tool name")
-	fmt.Println("  call --server name --tool name [--json] [--arg value]")
+	fmt.Println("mcpshim [--socket path] [--output text|json|yaml | --json] [--quiet] [--compact | --indent n] [--max-output n] [--auto-start] <command>")
+	fmt.Println("  servers [--sort name|alias|transport]")
+	fmt.Println("  tools [--server name] [--full] [--count] [--format text|md]")
+	fmt.Println("  tools --diff --server name")
+	fmt.Println("  inspect --server name --tool name [--format text|md]")
+	fmt.Println("  resources --server name")
+	fmt.Println("  read --server name --uri uri")
+	fmt.Println("  prompts --server name")
+	fmt.Println("  prompt --server name --name prompt [--arg key=value ...]")
+	fmt.Println("  call --server name --tool name [--json] [--explain] [--str key=value] [--call-id id] [--save-blobs dir] [--interactive] [--expect expr] [--verbose] [--progress] [--no-cache] [--dry-run] [--template text] [--args-file path|-] [--arg value]")
 	fmt.Println("       use '--' before tool args to pass reserved names (e.g. --help, --server)")
-	fmt.Println("  add --name x --url http://... [--transport http|sse|stdio] [--alias short] [--header K=V]")
-	fmt.Println("  add --name x --transport stdio --command prog [--command arg] [--env K=V]")
-	fmt.Println("  set auth --server x [--header K=V]")
-	fmt.Println("  remove --name x")
+	fmt.Println("  call --tool name --all | --servers a,b [--arg value]")
+	fmt.Println("  call --url http://... [--transport http|sse] [--header K=V] --tool name [--arg value]")
+	fmt.Println("  call --command prog [--command arg] [--env K=V] --tool name [--arg value]")
+	fmt.Println("  add --name x --url http://... [--transport http|sse|stdio] [--alias short] [--header K=V] [--bearer token]")
+	fmt.Println("  add --name x --transport stdio --command prog [--command arg] [--env K=V] [--root dir]")
+	fmt.Println("  update --name x [--alias a] [--url u] [--transport t] [--header K=V] [--command c] [--env K=V] [--root dir]")
+	fmt.Println("  set auth --server x [--header K=V] [--header @headers.env] [--bearer token]")
+	fmt.Println("  set enabled --server x --value true|false")
+	fmt.Println("  remove --name x [--purge [--history]]")
 	fmt.Println("  reload")
-	fmt.Println("  validate [--config path]")
-	fmt.Println("  login --server name [--manual]")
-	fmt.Println("  status")
-	fmt.Println("  history [--server name] [--tool name] [--limit 50]")
+	fmt.Println("  invalidate [--server name]")
+	fmt.Println("  validate [--config path] [--strict]")
+	fmt.Println("  login --server name [name...] [--manual] [--check] [--root dir]")
+	fmt.Println("  logout --server name")
+	fmt.Println("  cancel <call-id>")
+	fmt.Println("  subscribe --server name --uri uri")
+	fmt.Println("  rpc --server name --method tools/list [--params '{}']   (requires mcpshimd --debug)")
+	fmt.Println("  status [--watch] [--interval 2s] [--connections]")
+	fmt.Println("  health [--server name]")
+	fmt.Println("  search <query> [--regex]")
+	fmt.Println("  history [--server name] [--tool name] [--limit 50] [--page n | --before id | --after id] [--with-total] [--db path]")
+	fmt.Println("  history export [--server name] [--tool name] [--db path]")
+	fmt.Println("  history --clear [--server name] [--tool name]")
 	fmt.Println("  script [--install] [--dir ~/.local/bin]")
+	fmt.Println("  completion bash|zsh|fish")
+	fmt.Println("  shell [server]")
 	fmt.Println("  <server-alias> <tool> [--arg value]")
 }
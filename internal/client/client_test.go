@@ -0,0 +1,644 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	"github.com/prbarcelon/mcpshim/internal/config"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+func decodeResult(t *testing.T, value interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("marshal result: %v", err)
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unmarshal result: %v", err)
+	}
+	return out
+}
+
+func TestParseJSONLikeContentTextCallToolResult(t *testing.T) {
+	result := decodeResult(t, mcpproto.NewToolResultText(`{"items":[1,2],"next":null}`))
+
+	parsed := parseJSONLikeContentText(result)
+
+	root, ok := parsed.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected object result, got %T", parsed)
+	}
+	content, ok := root["content"].([]interface{})
+	if !ok || len(content) != 1 {
+		t.Fatalf("expected one content block, got %v", root["content"])
+	}
+	block := content[0].(map[string]interface{})
+	if block["type"] != "text" {
+		t.Errorf("expected type=text to be preserved, got %v", block["type"])
+	}
+	text, ok := block["text"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected text to be decoded into an object, got %T", block["text"])
+	}
+	if items, ok := text["items"].([]interface{}); !ok || len(items) != 2 {
+		t.Errorf("expected items=[1 2], got %v", text["items"])
+	}
+}
+
+func TestParseJSONLikeContentTextLeavesPlainText(t *testing.T) {
+	result := decodeResult(t, mcpproto.NewToolResultText("hello world"))
+
+	parsed := parseJSONLikeContentText(result).(map[string]interface{})
+	block := parsed["content"].([]interface{})[0].(map[string]interface{})
+	if block["text"] != "hello world" {
+		t.Errorf("expected plain text to be unchanged, got %v", block["text"])
+	}
+}
+
+func TestParseJSONLikeContentTextTopLevelString(t *testing.T) {
+	parsed := parseJSONLikeContentText(`[{"id":1}]`)
+
+	items, ok := parsed.([]interface{})
+	if !ok || len(items) != 1 {
+		t.Fatalf("expected top-level JSON string to be decoded, got %v", parsed)
+	}
+}
+
+func TestParseJSONLikeContentTextScalars(t *testing.T) {
+	if got := parseJSONLikeContentText("not json"); got != "not json" {
+		t.Errorf("expected plain string unchanged, got %v", got)
+	}
+	if got := parseJSONLikeContentText(float64(42)); got != float64(42) {
+		t.Errorf("expected number unchanged, got %v", got)
+	}
+	if got := parseJSONLikeContentText(nil); got != nil {
+		t.Errorf("expected nil unchanged, got %v", got)
+	}
+}
+
+func TestExtractAdHocEndpoint(t *testing.T) {
+	endpoint, rest, err := extractAdHocEndpoint([]string{"--url", "https://x/mcp", "--transport=sse", "--header", "X-Tenant=a", "--query", "foo"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if endpoint.url != "https://x/mcp" || endpoint.transport != "sse" {
+		t.Errorf("unexpected endpoint %+v", endpoint)
+	}
+	if endpoint.headers["X-Tenant"] != "a" {
+		t.Errorf("expected X-Tenant header, got %v", endpoint.headers)
+	}
+	if len(rest) != 2 || rest[0] != "--query" || rest[1] != "foo" {
+		t.Errorf("expected tool args to be preserved, got %v", rest)
+	}
+}
+
+func TestExtractAdHocEndpointStdioDefault(t *testing.T) {
+	endpoint, _, err := extractAdHocEndpoint([]string{"--command", "python", "--command", "-m"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !endpoint.isSet() || endpoint.transport != "stdio" {
+		t.Errorf("expected stdio endpoint, got %+v", endpoint)
+	}
+}
+
+func TestKeepStringTypedArgs(t *testing.T) {
+	rest := []string{"--zip", "01234", "--limit", "10", "--version=1.0"}
+	raw := rawDynamicArgs(rest)
+	args := parseDynamicArgs(rest)
+	detail := &protocol.ToolDetail{Properties: []protocol.PropertyDetail{
+		{Name: "zip", Type: "string"},
+		{Name: "limit", Type: "integer"},
+		{Name: "version", Type: "string"},
+	}}
+
+	keepStringTypedArgs(args, raw, detail)
+
+	if args["zip"] != "01234" {
+		t.Errorf("expected zip to stay a string, got %#v", args["zip"])
+	}
+	if args["version"] != "1.0" {
+		t.Errorf("expected version to stay a string, got %#v", args["version"])
+	}
+	if args["limit"] != int64(10) {
+		t.Errorf("expected limit to be coerced to integer, got %#v", args["limit"])
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	detail := &protocol.ToolDetail{Properties: []protocol.PropertyDetail{
+		{Name: "limit", Type: "integer"},
+		{Name: "page", Type: "integer"},
+		{Name: "sort", Type: "string", Enum: []string{"asc", "desc"}},
+		{Name: "level", Type: "integer", Enum: []string{"1", "2"}},
+		{Name: "kind", Type: "string", Const: "issue"},
+		{Name: "tags", Type: "array"},
+		{Name: "cursor", Type: "string|null"},
+	}}
+	args := map[string]interface{}{
+		"limit":  "ten",
+		"page":   "3",
+		"sort":   "up",
+		"level":  int64(2),
+		"kind":   "issue",
+		"tags":   "a",
+		"cursor": nil,
+		"extra":  map[string]interface{}{},
+	}
+
+	got := strings.Join(validateArgs(detail, args), "\n")
+	want := strings.Join([]string{
+		"argument --limit must be integer",
+		"argument --sort must be one of asc|desc",
+		"argument --tags must be array",
+	}, "\n")
+	if got != want {
+		t.Errorf("unexpected problems:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPropertyValues(t *testing.T) {
+	detail := &protocol.ToolDetail{Properties: []protocol.PropertyDetail{
+		{Name: "sort", Type: "string", Enum: []string{"asc", "desc"}},
+		{Name: "kind", Type: "string", Const: "issue"},
+		{Name: "query", Type: "string"},
+	}}
+	if got := strings.Join(propertyValues(detail, "sort"), ","); got != "asc,desc" {
+		t.Errorf("expected the enum values, got %q", got)
+	}
+	if got := strings.Join(propertyValues(detail, "kind"), ","); got != "issue" {
+		t.Errorf("expected the const value, got %q", got)
+	}
+	if got := propertyValues(detail, "query"); len(got) != 0 {
+		t.Errorf("expected no values for a free-form property, got %v", got)
+	}
+}
+
+func TestParseCallArgsStr(t *testing.T) {
+	opts, err := parseCallArgs([]string{"--server", "s", "--tool", "t", "--str", "phone=0123", "--str=id=7"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.stringArgs["phone"] != "0123" || opts.stringArgs["id"] != "7" {
+		t.Errorf("unexpected string args %v", opts.stringArgs)
+	}
+	if _, err := parseCallArgs([]string{"--str", "novalue"}); err == nil {
+		t.Error("expected error for --str without key=value")
+	}
+}
+
+func TestReadArgsFile(t *testing.T) {
+	args, err := readArgsFile("-", strings.NewReader(`{"query": "projects", "limit": 10, "filter": {"tags": ["a"]}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if args["limit"] != int64(10) {
+		t.Errorf("expected an integer limit, got %#v", args["limit"])
+	}
+	flags := map[string]interface{}{"query": "overridden"}
+	addFileArgs(flags, args)
+	if flags["query"] != "overridden" || flags["filter"] == nil {
+		t.Errorf("expected flags to win and file keys to fill in, got %v", flags)
+	}
+	if _, err := readArgsFile("-", strings.NewReader(`[1, 2]`)); err == nil || !strings.Contains(err.Error(), "got an array") {
+		t.Errorf("expected an array to be rejected, got %v", err)
+	}
+	if _, err := readArgsFile(filepath.Join(t.TempDir(), "missing.json"), nil); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestSortServers(t *testing.T) {
+	items := []protocol.ServerInfo{
+		{Name: "zeta", Alias: "a", Transport: "stdio"},
+		{Name: "alpha", Alias: "z", Transport: "http"},
+		{Name: "mid", Alias: "m", Transport: "http"},
+	}
+	if err := sortServers(items, "name"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if items[0].Name != "alpha" || items[1].Name != "mid" || items[2].Name != "zeta" {
+		t.Fatalf("unexpected name order: %+v", items)
+	}
+	if err := sortServers(items, "alias"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if items[0].Name != "zeta" || items[2].Name != "alpha" {
+		t.Fatalf("unexpected alias order: %+v", items)
+	}
+	if err := sortServers(items, "transport"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if items[0].Name != "alpha" || items[1].Name != "mid" || items[2].Name != "zeta" {
+		t.Fatalf("unexpected transport order: %+v", items)
+	}
+	if err := sortServers(items, "size"); err == nil {
+		t.Fatal("expected error for unknown sort key")
+	}
+}
+
+func TestSaveContentBlobs(t *testing.T) {
+	dir := t.TempDir()
+	result := map[string]interface{}{
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "hello"},
+			map[string]interface{}{"type": "image", "mimeType": "image/png", "data": base64.StdEncoding.EncodeToString([]byte("png-bytes"))},
+			map[string]interface{}{"type": "resource", "resource": map[string]interface{}{"uri": "file:///x", "blob": base64.StdEncoding.EncodeToString([]byte("raw"))}},
+		},
+	}
+	out, paths, err := saveContentBlobs(result, dir, "shot")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 saved blobs, got %v", paths)
+	}
+	if !strings.HasSuffix(paths[0], ".png") || !strings.HasSuffix(paths[1], ".bin") {
+		t.Fatalf("unexpected file names: %v", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil || string(data) != "png-bytes" {
+		t.Fatalf("unexpected blob contents %q: %v", data, err)
+	}
+	blocks := out.(map[string]interface{})["content"].([]interface{})
+	image := blocks[1].(map[string]interface{})
+	if _, ok := image["data"]; ok || image["path"] != paths[0] {
+		t.Fatalf("expected image data replaced by path, got %v", image)
+	}
+	resource := blocks[2].(map[string]interface{})["resource"].(map[string]interface{})
+	if resource["mimeType"] != "application/octet-stream" || resource["path"] != paths[1] {
+		t.Fatalf("unexpected resource block: %v", resource)
+	}
+}
+
+func TestTruncateOutput(t *testing.T) {
+	if got := truncateOutput("short", 0); got != "short" {
+		t.Fatalf("expected unlimited output, got %q", got)
+	}
+	if got := truncateOutput("short", 10); got != "short" {
+		t.Fatalf("expected untouched output, got %q", got)
+	}
+	if got := truncateOutput("abcdefghij", 4); got != "abcd\n...(truncated, 6 more bytes)" {
+		t.Fatalf("unexpected truncation: %q", got)
+	}
+	// never split a multi-byte rune
+	if got := truncateOutput("aé", 2); got != "a\n...(truncated, 2 more bytes)" {
+		t.Fatalf("unexpected rune-safe truncation: %q", got)
+	}
+}
+
+func TestToolMarkdown(t *testing.T) {
+	got := toolMarkdown(&protocol.ToolDetail{
+		Name:        "search",
+		Description: "Search pages.",
+		Properties: []protocol.PropertyDetail{
+			{Name: "query", Type: "string", Required: true, Description: "text | phrase\nto find"},
+			{Name: "mode", Enum: []string{"fast", "full"}},
+		},
+	})
+	want := "## search\n\nSearch pages.\n\n" +
+		"| Parameter | Type | Required | Description |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| `query` | string | yes | text \\| phrase<br>to find |\n" +
+		"| `mode` | any (fast, full) | no |  |\n"
+	if got != want {
+		t.Fatalf("unexpected markdown:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRenderStatusDashboard(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	st := &protocol.Status{UptimeSec: 3725, ServerCount: 2, CachedServerCount: 1, ToolCount: 14}
+	history := []protocol.HistoryItem{
+		{At: now.Add(-10 * time.Second), Server: "notion", Tool: "search", Success: true},
+		{At: now.Add(-20 * time.Second), Server: "notion", Tool: "fetch", Error: "upstream timeout"},
+		{At: now.Add(-5 * time.Minute), Server: "notion", Tool: "search", Error: "old failure"},
+	}
+	out := renderStatusDashboard(st, history, now)
+	for _, want := range []string{"uptime     1h2m5s", "servers    2 (1 cached)", "tools      14", "calls/min  2 (1 failed)", "notion/fetch: upstream timeout"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in dashboard:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "old failure") {
+		t.Errorf("expected failures older than a minute to be skipped:\n%s", out)
+	}
+}
+
+func TestPromptForArgs(t *testing.T) {
+	props := []protocol.PropertyDetail{
+		{Name: "query", Type: "string", Description: "Search text", Required: true},
+		{Name: "sort", Type: "string", Enum: []string{"asc", "desc"}, Required: true},
+		{Name: "limit", Type: "integer", Required: true},
+	}
+	var prompts strings.Builder
+	answers, err := promptForArgs(strings.NewReader("0042\n\nsideways\ndesc\n10\n"), &prompts, props)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answers["query"] != "0042" || answers["sort"] != "desc" || answers["limit"] != int64(10) {
+		t.Errorf("unexpected answers: %#v", answers)
+	}
+	for _, want := range []string{"query (string): Search text", "one of: asc, desc", "a value is required", "expected one of: asc, desc"} {
+		if !strings.Contains(prompts.String(), want) {
+			t.Errorf("expected %q in prompts:\n%s", want, prompts.String())
+		}
+	}
+
+	if _, err := promptForArgs(strings.NewReader(""), &prompts, props[:1]); err == nil {
+		t.Error("expected closed input to fail")
+	}
+}
+
+func TestPromptForRoots(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var prompts strings.Builder
+	input := filepath.Join(dir, "missing") + "\n" + file + "\n" + dir + "\n\n"
+	dirs, err := promptForRoots(strings.NewReader(input), &prompts, config.MCPServer{Name: "files"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 1 || dirs[0] != dir {
+		t.Errorf("expected only the existing directory, got %v", dirs)
+	}
+	for _, want := range []string{"does not exist", "is not a directory"} {
+		if !strings.Contains(prompts.String(), want) {
+			t.Errorf("expected %q in prompts:\n%s", want, prompts.String())
+		}
+	}
+
+	kept, err := promptForRoots(strings.NewReader("\n"), &prompts, config.MCPServer{Name: "files", Roots: []string{"/srv/data"}})
+	if err != nil || len(kept) != 1 || kept[0] != "/srv/data" {
+		t.Errorf("expected an empty answer to keep current roots, got %v %v", kept, err)
+	}
+	if _, err := promptForRoots(strings.NewReader(""), &prompts, config.MCPServer{Name: "files"}); err == nil {
+		t.Error("expected no directories to fail")
+	}
+}
+
+func TestExpectation(t *testing.T) {
+	result := decodeResult(t, mcpproto.NewToolResultText(`{"status":"error","items":[{"id":7}]}`))
+	root := expectRoot(result)
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`.status=="ok"`, false},
+		{`.status == "error"`, true},
+		{`.status != "ok"`, true},
+		{`.items[0].id == 7`, true},
+		{`.items[1].id`, false},
+		{`.items`, true},
+		{`.missing != null`, true},
+	}
+	for _, tc := range cases {
+		e, err := parseExpectation(tc.expr)
+		if err != nil {
+			t.Fatalf("parse %s: %v", tc.expr, err)
+		}
+		if _, ok := e.check(root); ok != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.want, ok)
+		}
+	}
+	for _, bad := range []string{`status=="ok"`, `.status = "ok"`, `.status == ok`, `.items[x]`} {
+		if _, err := parseExpectation(bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestDefaultOutputFormatPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("client:\n  default_output: text\nservers: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MCPSHIM_CONFIG", path)
+	t.Setenv("MCPSHIM_OUTPUT", "")
+	if format, err := defaultOutputFormat(); err != nil || format != "text" {
+		t.Errorf("expected the config to pick text, got %q err=%v", format, err)
+	}
+	t.Setenv("MCPSHIM_OUTPUT", "yaml")
+	if format, err := defaultOutputFormat(); err != nil || format != "yaml" {
+		t.Errorf("expected MCPSHIM_OUTPUT to win over the config, got %q err=%v", format, err)
+	}
+	t.Setenv("MCPSHIM_OUTPUT", "xml")
+	if _, err := defaultOutputFormat(); err == nil || !strings.Contains(err.Error(), "MCPSHIM_OUTPUT") {
+		t.Errorf("expected an unsupported format to name its source, got %v", err)
+	}
+}
+
+func TestEncodeYAMLUsesJSONFieldNames(t *testing.T) {
+	var b strings.Builder
+	total := 12
+	resp := &protocol.Response{OK: true, Total: &total, Tools: []protocol.ToolInfo{{Server: "notion", Name: "search"}}}
+	if err := encodeYAML(&b, resp, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := "ok: true\ntools:\n  - name: search\n    server: notion\ntotal: 12\n"
+	if b.String() != want {
+		t.Errorf("unexpected yaml:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl, err := parseOutputTemplate(`{{.result.items | len}} items, first {{index .result.items 0 "id"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &protocol.Response{OK: true, Result: map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"id": 12345678901}, map[string]interface{}{"id": 2}},
+	}}
+	var b strings.Builder
+	if err := renderTemplate(&b, tmpl, resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != "2 items, first 12345678901\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+	if _, err := parseOutputTemplate("{{.result"); err == nil || !strings.Contains(err.Error(), "--template") {
+		t.Errorf("expected a parse error naming --template, got %v", err)
+	}
+	tmpl, _ = parseOutputTemplate("{{index .result 3}}")
+	if err := renderTemplate(&b, tmpl, resp); err == nil {
+		t.Error("expected indexing a map with a number to fail")
+	}
+}
+
+func TestMaskBinaryText(t *testing.T) {
+	result := map[string]interface{}{
+		"content": []interface{}{
+			map[string]interface{}{"type": "text", "text": "PNG��\x00\x01"},
+			map[string]interface{}{"type": "text", "text": "line one\n\tline two"},
+		},
+	}
+	masked, n := maskBinaryText(result)
+	if n != 1 {
+		t.Fatalf("expected one masked value, got %d", n)
+	}
+	content := masked.(map[string]interface{})["content"].([]interface{})
+	if text := content[0].(map[string]interface{})["text"].(string); !strings.HasPrefix(text, "<binary, 11 bytes: 50 4e 47 ef bf bd") {
+		t.Errorf("unexpected preview %q", text)
+	}
+	if text := content[1].(map[string]interface{})["text"]; text != "line one\n\tline two" {
+		t.Errorf("expected plain text untouched, got %q", text)
+	}
+	if looksBinary("\x1b[31mred\x1b[0m and a lot of ordinary words after it") {
+		t.Error("expected a few escape codes in prose not to count as binary")
+	}
+}
+
+func TestPrintPrompt(t *testing.T) {
+	var b strings.Builder
+	printPrompt(&b, &protocol.PromptResult{Description: "a greeting", Messages: []protocol.PromptMessage{
+		{Role: "user", Content: map[string]interface{}{"type": "text", "text": "Say hello to Bob\n"}},
+		{Role: "assistant", Content: map[string]interface{}{"type": "image", "mimeType": "image/png", "data": "aGk="}},
+	}})
+	want := "# a greeting\n\n[user]\nSay hello to Bob\n\n[assistant]\n<image, image/png, 4 bytes base64>\n"
+	if got := b.String(); got != want {
+		t.Errorf("unexpected rendering:\n%s", got)
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	cases := map[string][]string{
+		`search --query roadmap`:           {"search", "--query", "roadmap"},
+		`  search   --query "q3 roadmap" `: {"search", "--query", "q3 roadmap"},
+		`create --title 'it''s' --tag ""`:  {"create", "--title", "its", "--tag", ""},
+		`note --text it\'s\ here`:          {"note", "--text", "it's here"},
+		`note --text 'a\b' "c\"d"`:         {"note", "--text", `a\b`, `c"d`},
+		``:                                 nil,
+	}
+	for line, want := range cases {
+		got, err := splitShellWords(line)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", line, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Errorf("%q: got %q, want %q", line, got, want)
+		}
+	}
+	for _, line := range []string{`call "open`, `call x\`} {
+		if _, err := splitShellWords(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestKeyValueFileFlags(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.env")
+	content := "# issued by the gateway team\r\nAuthorization=Bearer abc\r\n\r\n  X-Tenant = acme  \r\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var headers headerArgs
+	if err := headers.Set("@" + path); err != nil {
+		t.Fatal(err)
+	}
+	if err := headers.Set("X-Trace=1"); err != nil {
+		t.Fatal(err)
+	}
+	want := headerArgs{"Authorization": "Bearer abc", "X-Tenant": "acme", "X-Trace": "1"}
+	if len(headers) != len(want) {
+		t.Fatalf("expected %v, got %v", want, headers)
+	}
+	for k, v := range want {
+		if headers[k] != v {
+			t.Errorf("header %s: expected %q, got %q", k, v, headers[k])
+		}
+	}
+
+	var env envArgs
+	if err := env.Set("@" + path); err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 2 || env[0] != "Authorization=Bearer abc" || env[1] != "X-Tenant = acme" {
+		t.Errorf("unexpected env: %q", env)
+	}
+
+	if err := headers.Set("@" + filepath.Join(dir, "missing.env")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+	if err := env.Set("@"); err == nil || !strings.Contains(err.Error(), "missing file path") {
+		t.Errorf("expected an empty path to fail, got %v", err)
+	}
+	bad := filepath.Join(dir, "bad.env")
+	if err := os.WriteFile(bad, []byte("# ok\nTOKEN\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Set("@" + bad); err == nil || !strings.Contains(err.Error(), "bad.env:2") {
+		t.Errorf("expected the bad line to be named, got %v", err)
+	}
+}
+
+func TestAddFailsOnUnreadableHeaderFile(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "mcpshim.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var dialed atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dialed.Add(1)
+			conn.Close()
+		}
+	}()
+	t.Setenv("MCPSHIM_SOCKET", socketPath)
+	missing := "@" + filepath.Join(t.TempDir(), "missing.env")
+	for _, argv := range [][]string{
+		{"add", "--name", "notion", "--url", "https://mcp.notion.com/mcp", "--header", missing},
+		{"update", "--name", "notion", "--header", missing},
+		{"set", "auth", "--server", "notion", "--header", missing},
+	} {
+		if code := Run("mcpshim", argv); code != 1 {
+			t.Errorf("%v: expected exit 1, got %d", argv, code)
+		}
+	}
+	if n := dialed.Load(); n != 0 {
+		t.Errorf("expected no request to reach the daemon, got %d connections", n)
+	}
+}
+
+func TestToolCountsListsServersWithoutTools(t *testing.T) {
+	servers := []protocol.ServerInfo{
+		{Name: "github", Alias: "gh"},
+		{Name: "notion"},
+		{Name: "legacy", Disabled: true},
+	}
+	tools := []protocol.ToolInfo{
+		{Server: "github", Name: "list_issues"},
+		{Server: "github", Name: "create_issue"},
+	}
+	counts, names := toolCounts(tools, countedServers(servers, ""))
+	if strings.Join(names, ",") != "github,notion" || counts["github"] != 2 || counts["notion"] != 0 {
+		t.Errorf("expected notion at 0 and legacy left out, got %v %v", names, counts)
+	}
+	if _, ok := counts["legacy"]; ok {
+		t.Error("expected a disabled server to be left out")
+	}
+	if got := countedServers(servers, "gh"); len(got) != 1 || got[0] != "github" {
+		t.Errorf("expected --server to match the alias, got %v", got)
+	}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/prbarcelon/mcpshim/internal/config"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+const shellHelp = `commands:
+  use <server>                 pick the server the next commands go to
+  servers                      list registered servers
+  tools                        list the current server's tools
+  inspect <tool>               show a tool's schema
+  call <tool> [--arg value]    call a tool; the call keyword may be left out
+  help                         show this help
+  exit                         leave the shell (ctrl-d works too)`
+
+// an interactive loop over one daemon connection; it reads commands from
+// stdin, so it can also run a file of them
+type shell struct {
+	socket string
+	out    outputOptions
+	sess   *session
+	server string
+	prompt bool
+}
+
+func runShell(args []string, socket string, out outputOptions) int {
+	sh := &shell{socket: socket, out: out, prompt: stdinIsTerminal()}
+	if len(args) > 1 || (len(args) == 1 && strings.HasPrefix(args[0], "-")) {
+		fmt.Fprintln(os.Stderr, "usage: mcpshim shell [server]")
+		return 1
+	}
+	defer sh.close()
+	status := 0
+	if len(args) == 1 {
+		status = sh.use(args[0])
+	}
+	if sh.prompt {
+		fmt.Println("mcpshim shell; type help for commands")
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for {
+		if sh.prompt {
+			fmt.Print(sh.promptText())
+		}
+		if !scanner.Scan() {
+			break
+		}
+		words, err := splitShellWords(scanner.Text())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		if len(words) == 0 || strings.HasPrefix(words[0], "#") {
+			continue
+		}
+		if words[0] == "exit" || words[0] == "quit" {
+			return status
+		}
+		status = sh.run(words)
+	}
+	if sh.prompt {
+		fmt.Println()
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return status
+}
+
+func (sh *shell) promptText() string {
+	if sh.server == "" {
+		return "mcpshim> "
+	}
+	return "mcpshim:" + sh.server + "> "
+}
+
+func (sh *shell) run(words []string) int {
+	cmd, rest := words[0], words[1:]
+	switch cmd {
+	case "help":
+		fmt.Println(shellHelp)
+		return 0
+	case "use":
+		if len(rest) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: use <server>")
+			return 1
+		}
+		return sh.use(rest[0])
+	case "servers":
+		resp, err := sh.call(protocol.Request{Action: "servers"})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, sh.out)
+	}
+
+	if sh.server == "" {
+		fmt.Fprintln(os.Stderr, "no server selected; run use <server> first")
+		return 1
+	}
+	switch cmd {
+	case "tools":
+		resp, err := sh.call(protocol.Request{Action: "tools", Server: sh.server})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if sh.out.structured() || !resp.OK {
+			return printResponse(resp, sh.out)
+		}
+		printStaleNotice(resp)
+		printToolsList(resp.Tools, false)
+		return 0
+	case "inspect":
+		if len(rest) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: inspect <tool>")
+			return 1
+		}
+		resp, err := sh.call(protocol.Request{Action: "inspect", Server: sh.server, Tool: rest[0]})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return printResponse(resp, sh.out)
+	case "call":
+		if len(rest) == 0 || strings.HasPrefix(rest[0], "-") {
+			fmt.Fprintln(os.Stderr, "usage: call <tool> [--arg value ...]")
+			return 1
+		}
+		cmd, rest = rest[0], rest[1:]
+	}
+	resp, err := sh.call(protocol.Request{Action: "call", Server: sh.server, Tool: cmd, Args: parseDynamicArgs(rest)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return printResponse(resp, sh.out)
+}
+
+func (sh *shell) use(server string) int {
+	resp, err := sh.call(protocol.Request{Action: "servers"})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, s := range resp.Servers {
+		if config.SameName(s.Name, server) || (s.Alias != "" && config.SameName(s.Alias, server)) {
+			sh.server = s.Name
+			return 0
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown server %q\n", server)
+	return 1
+}
+
+// a dropped connection is reopened for the next command, never retried for
+// the one that failed, since a tool call may already have run
+func (sh *shell) call(req protocol.Request) (*protocol.Response, error) {
+	if sh.sess == nil {
+		sess, err := openSession(sh.socket)
+		if err != nil {
+			return nil, err
+		}
+		sh.sess = sess
+	}
+	resp, err := sh.sess.call(req)
+	if err != nil {
+		sh.close()
+		if errors.Is(err, io.EOF) {
+			err = errors.New("mcpshimd closed the connection")
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (sh *shell) close() {
+	if sh.sess != nil {
+		_ = sh.sess.Close()
+		sh.sess = nil
+	}
+}
+
+// splits a command line into words the way a shell would for simple
+// input: whitespace separates words, quotes group them, and a backslash
+// escapes the next character outside single quotes
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, errors.New("line ends with a backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -4,22 +4,72 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
 	"gopkg.in/yaml.v3"
 )
 
+const MinStdioLineBytes = 64 * 1024
+
+const CommandHeaderPrefix = "cmd:"
+
 type Config struct {
-	Server  ServerConfig `yaml:"server"`
-	Servers []MCPServer  `yaml:"servers"`
+	Server  ServerConfig  `yaml:"server"`
+	Client  ClientConfig  `yaml:"client,omitempty"`
+	History HistoryConfig `yaml:"history,omitempty"`
+	Servers []MCPServer   `yaml:"servers"`
+}
+
+// settings only the mcpshim cli reads
+type ClientConfig struct {
+	DefaultOutput string `yaml:"default_output,omitempty"`
+	AutoStart     bool   `yaml:"auto_start,omitempty"`
+}
+
+type HistoryConfig struct {
+	Retention HistoryRetention `yaml:"retention,omitempty"`
+}
+
+// zero means no limit; with both set, whichever removes more wins
+type HistoryRetention struct {
+	Days    int `yaml:"days,omitempty"`
+	MaxRows int `yaml:"max_rows,omitempty"`
 }
 
 type ServerConfig struct {
 	SocketPath string `yaml:"socket_path"`
 	DBPath     string `yaml:"db_path"`
+
+	HistoryMaxArgsBytes int    `yaml:"history_max_args_bytes,omitempty"`
+	MaxArgsKeys         int    `yaml:"max_args_keys,omitempty"`
+	MaxArgsBytes        int    `yaml:"max_args_bytes,omitempty"`
+	GRPCAddr            string `yaml:"grpc_addr,omitempty"`
+	GRPCTokenFile       string `yaml:"grpc_token_file,omitempty"`
+	OTelEndpoint        string `yaml:"otel_endpoint,omitempty"`
+	ProtocolVersion     string `yaml:"protocol_version,omitempty"`
+	RefreshConcurrency  int    `yaml:"refresh_concurrency,omitempty"`
+
+	// sent as clientInfo in initialize; servers may override either
+	ClientName    string `yaml:"client_name,omitempty"`
+	ClientVersion string `yaml:"client_version,omitempty"`
+
+	Middleware []Middleware `yaml:"middleware,omitempty"`
+}
+
+type Middleware struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	Env     []string `yaml:"env,omitempty"`
 }
 
 type MCPServer struct {
@@ -30,9 +80,68 @@ type MCPServer struct {
 	Headers   map[string]string `yaml:"headers,omitempty"`
 	Command   []string          `yaml:"command,omitempty"`
 	Env       []string          `yaml:"env,omitempty"`
+
+	Roots []string `yaml:"roots,omitempty"`
+
+	AllowCommandHeaders bool `yaml:"allow_command_headers,omitempty"`
+
+	ProtocolVersion        string `yaml:"protocol_version,omitempty"`
+	DefaultProtocolVersion string `yaml:"-"`
+
+	ClientName           string `yaml:"client_name,omitempty"`
+	ClientVersion        string `yaml:"client_version,omitempty"`
+	DefaultClientName    string `yaml:"-"`
+	DefaultClientVersion string `yaml:"-"`
+
+	StdioMaxLineBytes int `yaml:"stdio_max_line_bytes,omitempty"`
+
+	// deadline for each call or tool listing; zero keeps the daemon defaults
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	CallHeaders map[string]string      `yaml:"call_headers,omitempty"`
+	BaseArgs    map[string]interface{} `yaml:"base_args,omitempty"`
+
+	HTTPOptions *HTTPOptions  `yaml:"http_options,omitempty"`
+	OAuth       *OAuthOptions `yaml:"oauth,omitempty"`
+
+	// pem files for mutual tls; the ca is trusted on top of the system roots
+	TLSClientCert string `yaml:"tls_client_cert,omitempty"`
+	TLSClientKey  string `yaml:"tls_client_key,omitempty"`
+	TLSCACert     string `yaml:"tls_ca_cert,omitempty"`
+
+	// nil means a 401 may start the oauth flow
+	OAuthFallback *bool `yaml:"oauth_fallback,omitempty"`
+
+	// results of these tools are reused until their ttl, or cache_ttl when
+	// the tool has none, runs out; meant for pure reads
+	Cacheable map[string]time.Duration `yaml:"cacheable,omitempty"`
+	CacheTTL  time.Duration            `yaml:"cache_ttl,omitempty"`
+
+	// kept in the config, with its token and history, but never contacted
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	DefaultsFile string                            `yaml:"defaults_file,omitempty"`
+	Defaults     map[string]map[string]interface{} `yaml:"-"`
 }
 
-func normalizeTransport(value string) (string, error) {
+type OAuthOptions struct {
+	ClientName string   `yaml:"client_name,omitempty"`
+	Scopes     []string `yaml:"scopes,omitempty"`
+	SoftwareID string   `yaml:"software_id,omitempty"`
+
+	// a pre-registered client skips dynamic registration; the secret is a
+	// ${VAR} or cmd: reference resolved at login, never the value itself
+	ClientID     string `yaml:"client_id,omitempty"`
+	ClientSecret string `yaml:"client_secret,omitempty"`
+}
+
+type HTTPOptions struct {
+	Accept              string `yaml:"accept,omitempty"`
+	Host                string `yaml:"host,omitempty"`
+	ContinuousListening bool   `yaml:"continuous_listening,omitempty"`
+}
+
+func NormalizeTransport(value string) (string, error) {
 	switch strings.TrimSpace(strings.ToLower(value)) {
 	case "", "http", "streamable-http":
 		return "http", nil
@@ -49,14 +158,28 @@ func DefaultConfigPath() string {
 	if envPath := strings.TrimSpace(os.Getenv("MCPSHIM_CONFIG")); envPath != "" {
 		return envPath
 	}
+	if profile := Profile(); profile != "" {
+		return filepath.Join(xdgConfigHome(), "mcpshim", profile+".yaml")
+	}
 	return filepath.Join(xdgConfigHome(), "mcpshim", "config.yaml")
 }
 
 func DefaultSocketPath() string {
+	if envPath := strings.TrimSpace(os.Getenv("MCPSHIM_SOCKET")); envPath != "" {
+		return envPath
+	}
+	name := "mcpshim"
+	if profile := Profile(); profile != "" {
+		name += "-" + profile
+	}
 	if runtimeDir := strings.TrimSpace(os.Getenv("XDG_RUNTIME_DIR")); runtimeDir != "" {
-		return filepath.Join(runtimeDir, "mcpshim.sock")
+		return filepath.Join(runtimeDir, name+".sock")
 	}
-	return fmt.Sprintf("/tmp/mcpshim-%d.sock", os.Getuid())
+	return fmt.Sprintf("/tmp/%s-%d.sock", name, os.Getuid())
+}
+
+func Profile() string {
+	return strings.TrimSpace(os.Getenv("MCPSHIM_PROFILE"))
 }
 
 func DefaultDBPath() string {
@@ -66,6 +189,18 @@ func DefaultDBPath() string {
 	return filepath.Join(homeDir(), ".local", "share", "mcpshim", "mcpshim.db")
 }
 
+// the grpc bearer token; it sits next to the database unless configured
+func (c ServerConfig) GRPCTokenPath() string {
+	if c.GRPCTokenFile != "" {
+		return c.GRPCTokenFile
+	}
+	dbPath := c.DBPath
+	if dbPath == "" {
+		dbPath = DefaultDBPath()
+	}
+	return filepath.Join(filepath.Dir(dbPath), "grpc.token")
+}
+
 func xdgConfigHome() string {
 	if dir := strings.TrimSpace(os.Getenv("XDG_CONFIG_HOME")); dir != "" {
 		return dir
@@ -80,6 +215,27 @@ func homeDir() string {
 	return "/tmp/mcpshim-" + strconv.Itoa(os.Getuid())
 }
 
+func Ephemeral() bool {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv("MCPSHIM_EPHEMERAL")))
+	return value == "1" || value == "true"
+}
+
+func LoadEphemeral() (*Config, error) {
+	cfg := Config{Servers: []MCPServer{}}
+	if raw := strings.TrimSpace(os.Getenv("MCPSHIM_SERVERS")); raw != "" {
+		// json is valid yaml, and decoding as yaml reuses the yaml field names
+		dec := yaml.NewDecoder(strings.NewReader(raw))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg.Servers); err != nil {
+			return nil, fmt.Errorf("MCPSHIM_SERVERS: %w", err)
+		}
+	}
+	if err := normalize(&cfg, ""); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -89,8 +245,78 @@ func Load(path string) (*Config, error) {
 	dec := yaml.NewDecoder(bytes.NewReader(data))
 	dec.KnownFields(true)
 	if err := dec.Decode(&cfg); err != nil {
-		return nil, err
+		return nil, decodeError(path, err)
 	}
+	if err := normalize(&cfg, path); err != nil {
+		var serverErr *ServerError
+		if errors.As(err, &serverErr) {
+			if line, column, ok := serverPosition(data, serverErr.Index); ok {
+				return nil, fmt.Errorf("%s:%d:%d: %w", path, line, column, err)
+			}
+		}
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+type ServerError struct {
+	Index int
+	Err   error
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("servers[%d]: %v", e.Index, e.Err)
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func decodeError(path string, err error) error {
+	// rewrite yaml's "line N: msg" as "path:N: msg" so editors can jump to it
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		lines := make([]string, 0, len(typeErr.Errors))
+		for _, msg := range typeErr.Errors {
+			lines = append(lines, prefixYAMLLine(path, msg))
+		}
+		return errors.New(strings.Join(lines, "\n"))
+	}
+	return errors.New(prefixYAMLLine(path, err.Error()))
+}
+
+func prefixYAMLLine(path string, msg string) string {
+	if m := yamlLinePattern.FindStringSubmatch(msg); m != nil {
+		return fmt.Sprintf("%s:%s: %s", path, m[1], m[2])
+	}
+	return fmt.Sprintf("%s: %s", path, strings.TrimPrefix(msg, "yaml: "))
+}
+
+func serverPosition(data []byte, index int) (int, int, bool) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 {
+		return 0, 0, false
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return 0, 0, false
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "servers" {
+			continue
+		}
+		items := doc.Content[i+1]
+		if items.Kind != yaml.SequenceNode || index < 0 || index >= len(items.Content) {
+			return 0, 0, false
+		}
+		return items.Content[index].Line, items.Content[index].Column, true
+	}
+	return 0, 0, false
+}
+
+func normalize(cfg *Config, path string) error {
 	if cfg.Server.SocketPath == "" {
 		cfg.Server.SocketPath = DefaultSocketPath()
 	}
@@ -102,28 +328,67 @@ func Load(path string) (*Config, error) {
 		s.URL = os.ExpandEnv(s.URL)
 		if s.Headers != nil {
 			for k, v := range s.Headers {
+				// command headers run through a shell, which expands variables itself
+				if IsCommandHeader(v) {
+					continue
+				}
 				s.Headers[k] = os.ExpandEnv(v)
 			}
 		}
+		for k, v := range s.CallHeaders {
+			s.CallHeaders[k] = os.ExpandEnv(v)
+		}
 		for j, v := range s.Command {
 			s.Command[j] = os.ExpandEnv(v)
 		}
 		for j, v := range s.Env {
 			s.Env[j] = os.ExpandEnv(v)
 		}
-		transport, transportErr := normalizeTransport(s.Transport)
+		for j, v := range s.Roots {
+			s.Roots[j] = os.ExpandEnv(v)
+		}
+		for _, file := range []*string{&s.TLSClientCert, &s.TLSClientKey, &s.TLSCACert} {
+			*file = resolveRelative(path, os.ExpandEnv(*file))
+		}
+		if s.DefaultsFile != "" {
+			defaults, defaultsErr := loadDefaultsFile(resolveRelative(path, os.ExpandEnv(s.DefaultsFile)))
+			if defaultsErr != nil {
+				return &ServerError{Index: i, Err: fmt.Errorf("server %q defaults_file: %w", s.Name, defaultsErr)}
+			}
+			s.Defaults = defaults
+		}
+		transport, transportErr := NormalizeTransport(s.Transport)
 		if transportErr != nil {
-			return nil, transportErr
+			return &ServerError{Index: i, Err: fmt.Errorf("server %q: %w", s.Name, transportErr)}
 		}
 		s.Transport = transport
+		s.Name = strings.TrimSpace(s.Name)
+		s.Alias = strings.TrimSpace(s.Alias)
 		if s.Alias == "" {
 			s.Alias = s.Name
 		}
+		cfg.Server.ApplyDefaults(s)
 	}
-	if err := validate(&cfg); err != nil {
+	return validate(cfg)
+}
+
+func loadDefaultsFile(path string) (map[string]map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
-	return &cfg, nil
+	defaults := map[string]map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &defaults); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return defaults, nil
+}
+
+func resolveRelative(configPath string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
 }
 
 func Save(path string, cfg *Config) error {
@@ -133,6 +398,9 @@ func Save(path string, cfg *Config) error {
 	if err := validate(cfg); err != nil {
 		return err
 	}
+	if Ephemeral() {
+		return nil
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
 		return err
 	}
@@ -156,6 +424,9 @@ func Save(path string, cfg *Config) error {
 }
 
 func LoadOrInit(path string) (*Config, error) {
+	if Ephemeral() {
+		return LoadEphemeral()
+	}
 	cfg, err := Load(path)
 	if err == nil {
 		return cfg, nil
@@ -177,65 +448,502 @@ func LoadOrInit(path string) (*Config, error) {
 }
 
 func validate(cfg *Config) error {
+	if err := CheckOutputFormat(cfg.Client.DefaultOutput); err != nil {
+		return fmt.Errorf("client.default_output: %w", err)
+	}
+	if cfg.Server.HistoryMaxArgsBytes < 0 {
+		return errors.New("server.history_max_args_bytes must not be negative")
+	}
+	if cfg.Server.MaxArgsKeys < 0 {
+		return errors.New("server.max_args_keys must not be negative")
+	}
+	if cfg.Server.MaxArgsBytes < 0 {
+		return errors.New("server.max_args_bytes must not be negative")
+	}
+	if cfg.History.Retention.Days < 0 {
+		return errors.New("history.retention.days must not be negative")
+	}
+	if cfg.History.Retention.MaxRows < 0 {
+		return errors.New("history.retention.max_rows must not be negative")
+	}
+	if cfg.Server.RefreshConcurrency < 0 {
+		return errors.New("server.refresh_concurrency must not be negative")
+	}
+	if cfg.Server.GRPCAddr != "" {
+		// the token only guards against other local users; keep it off the network
+		host, _, err := net.SplitHostPort(cfg.Server.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("server.grpc_addr: %w", err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("server.grpc_addr %q must be a loopback address", cfg.Server.GRPCAddr)
+		}
+	}
+	seenMiddleware := map[string]bool{}
+	for i, m := range cfg.Server.Middleware {
+		if strings.TrimSpace(m.Name) == "" {
+			return fmt.Errorf("server.middleware[%d]: name is required", i)
+		}
+		if seenMiddleware[m.Name] {
+			return fmt.Errorf("server.middleware[%d]: duplicate name %q", i, m.Name)
+		}
+		seenMiddleware[m.Name] = true
+		if len(m.Command) == 0 {
+			return fmt.Errorf("server.middleware[%d]: command is required", i)
+		}
+	}
+	if err := checkProtocolVersion(cfg.Server.ProtocolVersion); err != nil {
+		return fmt.Errorf("server.protocol_version: %w", err)
+	}
+	if cfg.Server.OTelEndpoint != "" {
+		u, err := url.Parse(cfg.Server.OTelEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("server.otel_endpoint %q must be an http(s) url such as http://localhost:4318", cfg.Server.OTelEndpoint)
+		}
+	}
 	seen := map[string]bool{}
 	aliases := map[string]bool{}
-	for _, s := range cfg.Servers {
-		if s.Name == "" {
-			return errors.New("server name is required")
+	for i, s := range cfg.Servers {
+		if err := ValidateServer(s); err != nil {
+			return &ServerError{Index: i, Err: err}
 		}
-		transport, err := normalizeTransport(s.Transport)
-		if err != nil {
-			return fmt.Errorf("server %q: %w", s.Name, err)
+		if seen[nameKey(s.Name)] {
+			return &ServerError{Index: i, Err: fmt.Errorf("duplicate server name %q (names are matched ignoring case)", s.Name)}
 		}
-		if transport == "stdio" {
-			if len(s.Command) == 0 {
-				return fmt.Errorf("server %q command is required for stdio transport", s.Name)
-			}
-		} else {
-			if s.URL == "" {
-				return fmt.Errorf("server %q url is required", s.Name)
-			}
+		seen[nameKey(s.Name)] = true
+		alias := s.Alias
+		if alias == "" {
+			alias = s.Name
 		}
-		if seen[s.Name] {
-			return fmt.Errorf("duplicate server name %q", s.Name)
+		if aliases[nameKey(alias)] {
+			return &ServerError{Index: i, Err: fmt.Errorf("duplicate alias %q (aliases are matched ignoring case)", alias)}
 		}
-		seen[s.Name] = true
+		aliases[nameKey(alias)] = true
+	}
+	return nil
+}
+
+func StrictIssues(cfg *Config, reserved []string) []string {
+	reservedSet := map[string]bool{"mcpshim": true, "mcpshimd": true}
+	for _, name := range reserved {
+		reservedSet[name] = true
+	}
+	issues := []string{}
+	urls := map[string]string{}
+	for _, s := range cfg.Servers {
 		alias := s.Alias
 		if alias == "" {
 			alias = s.Name
 		}
-		if aliases[alias] {
-			return fmt.Errorf("duplicate alias %q", alias)
+		if reservedSet[alias] {
+			issues = append(issues, fmt.Sprintf("server %q: alias %q shadows the %q subcommand; set a different alias", s.Name, alias, alias))
+		}
+		for _, value := range []string{s.Name, alias} {
+			if !isWrapperSafe(value) {
+				issues = append(issues, fmt.Sprintf("server %q: %q is not usable as a wrapper or shell function name; use only letters, digits, '.', '_' and '-', not starting with '-'", s.Name, value))
+			}
+		}
+		if MissingAuthScheme(s.Headers) {
+			issues = append(issues, fmt.Sprintf("server %q: Authorization header has no scheme; write it as \"Bearer <token>\"", s.Name))
+		}
+		if s.URL != "" {
+			key := strings.TrimRight(s.URL, "/")
+			if other, ok := urls[key]; ok {
+				issues = append(issues, fmt.Sprintf("server %q: url %s is also used by %q; remove one or give them distinct endpoints", s.Name, s.URL, other))
+			} else {
+				urls[key] = s.Name
+			}
+		}
+	}
+	return issues
+}
+
+func CheckEndpointURL(raw string) error {
+	if strings.ContainsAny(raw, " \t\r\n") {
+		return fmt.Errorf("url %q contains whitespace; quote it or remove the spaces", raw)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("url %q is malformed: %w", raw, err)
+	}
+	if u.Scheme == "" || !strings.Contains(raw, "://") {
+		return fmt.Errorf("url %q is missing a scheme; did you mean https://%s", raw, strings.TrimPrefix(raw, "//"))
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q has unsupported scheme %q (expected http or https)", raw, u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("url %q has no host", raw)
+	}
+	return nil
+}
+
+// roots are checked when they are set rather than on load, so a directory
+// that disappears later does not stop the daemon from starting
+func CheckRoots(roots []string) error {
+	for _, root := range roots {
+		path := strings.TrimPrefix(root, "file://")
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("root %q must be an absolute path", root)
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("root %s does not exist", root)
+		}
+		if err != nil {
+			return fmt.Errorf("root %s: %w", root, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("root %s is not a directory", root)
+		}
+	}
+	return nil
+}
+
+func CheckOutputFormat(format string) error {
+	switch format {
+	case "", "text", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("unsupported output %q (expected text, json or yaml)", format)
+}
+
+func isWrapperSafe(name string) bool {
+	if name == "" || name[0] == '-' || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func ValidateServer(s MCPServer) error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("server name is required")
+	}
+	transport, err := NormalizeTransport(s.Transport)
+	if err != nil {
+		return fmt.Errorf("server %q: %w", s.Name, err)
+	}
+	if transport == "stdio" {
+		if len(s.Command) == 0 {
+			return fmt.Errorf("server %q command is required for stdio transport", s.Name)
+		}
+	} else {
+		if s.URL == "" {
+			return fmt.Errorf("server %q url is required", s.Name)
+		}
+		if err := CheckEndpointURL(s.URL); err != nil {
+			return fmt.Errorf("server %q: %w", s.Name, err)
+		}
+	}
+	if s.StdioMaxLineBytes != 0 {
+		if transport != "stdio" {
+			return fmt.Errorf("server %q stdio_max_line_bytes only applies to stdio transport", s.Name)
+		}
+		if s.StdioMaxLineBytes < MinStdioLineBytes {
+			return fmt.Errorf("server %q stdio_max_line_bytes must be at least %d", s.Name, MinStdioLineBytes)
+		}
+	}
+	for key, value := range s.Headers {
+		if !IsCommandHeader(value) {
+			continue
+		}
+		if !s.AllowCommandHeaders {
+			return fmt.Errorf("server %q header %s runs a command; set allow_command_headers: true to allow it", s.Name, key)
+		}
+		if strings.TrimSpace(strings.TrimPrefix(value, CommandHeaderPrefix)) == "" {
+			return fmt.Errorf("server %q header %s has an empty command", s.Name, key)
+		}
+	}
+	if err := checkProtocolVersion(s.ProtocolVersion); err != nil {
+		return fmt.Errorf("server %q protocol_version: %w", s.Name, err)
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("server %q timeout must be positive, such as 120s", s.Name)
+	}
+	if err := checkTLSFiles(s, transport); err != nil {
+		return err
+	}
+	if s.CacheTTL < 0 {
+		return fmt.Errorf("server %q cache_ttl must be positive, such as 5m", s.Name)
+	}
+	for tool, ttl := range s.Cacheable {
+		if ttl < 0 || (ttl == 0 && s.CacheTTL == 0) {
+			return fmt.Errorf("server %q cacheable tool %q needs a positive ttl, such as 5m, or a cache_ttl for the server", s.Name, tool)
+		}
+	}
+	if len(s.CallHeaders) > 0 && transport == "stdio" {
+		return fmt.Errorf("server %q call_headers are not supported for stdio transport", s.Name)
+	}
+	if s.HTTPOptions != nil && transport != "http" {
+		return fmt.Errorf("server %q http_options only apply to http transport", s.Name)
+	}
+	if s.OAuth != nil && transport == "stdio" {
+		return fmt.Errorf("server %q oauth is not supported for stdio transport", s.Name)
+	}
+	if s.OAuth != nil && !s.OAuthFallbackEnabled() {
+		return fmt.Errorf("server %q oauth options have no effect with oauth_fallback: false", s.Name)
+	}
+	if s.OAuth != nil && s.OAuth.ClientSecret != "" {
+		secret := strings.TrimSpace(s.OAuth.ClientSecret)
+		switch {
+		case s.OAuth.ClientID == "":
+			return fmt.Errorf("server %q oauth.client_secret requires oauth.client_id", s.Name)
+		case IsCommandHeader(secret) && !s.AllowCommandHeaders:
+			return fmt.Errorf("server %q oauth.client_secret runs a command; set allow_command_headers: true to allow it", s.Name)
+		case !IsCommandHeader(secret) && !strings.HasPrefix(secret, "$"):
+			return fmt.Errorf("server %q oauth.client_secret must be a ${VAR} or cmd: reference, not the secret itself", s.Name)
+		}
+	}
+	return nil
+}
+
+func (s MCPServer) RequestTimeout(fallback time.Duration) time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return fallback
+}
+
+func checkTLSFiles(s MCPServer, transport string) error {
+	if s.TLSClientCert == "" && s.TLSClientKey == "" && s.TLSCACert == "" {
+		return nil
+	}
+	if transport == "stdio" {
+		return fmt.Errorf("server %q tls settings are not supported for stdio transport", s.Name)
+	}
+	if (s.TLSClientCert == "") != (s.TLSClientKey == "") {
+		return fmt.Errorf("server %q tls_client_cert and tls_client_key must be set together", s.Name)
+	}
+	for _, file := range []struct{ key, path string }{
+		{"tls_client_cert", s.TLSClientCert},
+		{"tls_client_key", s.TLSClientKey},
+		{"tls_ca_cert", s.TLSCACert},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return fmt.Errorf("server %q %s: %w", s.Name, file.key, err)
 		}
-		aliases[alias] = true
 	}
 	return nil
 }
 
-func UpsertServer(cfg *Config, item MCPServer) {
-	transport, err := normalizeTransport(item.Transport)
+// how long a result of tool may be reused; zero means never
+func (s MCPServer) ResultTTL(tool string) time.Duration {
+	ttl, ok := s.Cacheable[tool]
+	if !ok {
+		return 0
+	}
+	if ttl > 0 {
+		return ttl
+	}
+	return s.CacheTTL
+}
+
+// copies the daemon-wide settings a server falls back to when it leaves
+// its own unset
+func (c ServerConfig) ApplyDefaults(s *MCPServer) {
+	s.DefaultProtocolVersion = c.ProtocolVersion
+	s.DefaultClientName = c.ClientName
+	s.DefaultClientVersion = c.ClientVersion
+}
+
+// the name and version sent as clientInfo in initialize
+func (s MCPServer) ClientIdentity() (string, string) {
+	name, version := "mcpshimd", "dev"
+	if s.DefaultClientName != "" {
+		name = s.DefaultClientName
+	}
+	if s.ClientName != "" {
+		name = s.ClientName
+	}
+	if s.DefaultClientVersion != "" {
+		version = s.DefaultClientVersion
+	}
+	if s.ClientVersion != "" {
+		version = s.ClientVersion
+	}
+	return name, version
+}
+
+func (s MCPServer) OAuthFallbackEnabled() bool {
+	return s.OAuthFallback == nil || *s.OAuthFallback
+}
+
+func checkProtocolVersion(version string) error {
+	if version == "" || slices.Contains(mcpproto.ValidProtocolVersions, version) {
+		return nil
+	}
+	return fmt.Errorf("unknown version %q (expected one of %s)", version, strings.Join(mcpproto.ValidProtocolVersions, ", "))
+}
+
+func IsCommandHeader(value string) bool {
+	return strings.HasPrefix(value, CommandHeaderPrefix)
+}
+
+// "Bearer abc" and "Basic abc" carry a scheme; a bare token is almost always
+// a forgotten "Bearer " and gets a 401
+func MissingAuthScheme(headers map[string]string) bool {
+	for key, value := range headers {
+		value = strings.TrimSpace(value)
+		if !strings.EqualFold(key, "Authorization") || value == "" || IsCommandHeader(value) {
+			continue
+		}
+		if !strings.ContainsAny(value, " \t") {
+			return true
+		}
+	}
+	return false
+}
+
+type UpsertResult string
+
+const (
+	ServerAdded     UpsertResult = "added"
+	ServerUpdated   UpsertResult = "updated"
+	ServerUnchanged UpsertResult = "unchanged"
+)
+
+func UpsertServer(cfg *Config, item MCPServer) UpsertResult {
+	transport, err := NormalizeTransport(item.Transport)
 	if err != nil {
 		transport = "http"
 	}
 	item.Transport = transport
+	item.Name = strings.TrimSpace(item.Name)
+	item.Alias = strings.TrimSpace(item.Alias)
+	for _, existing := range cfg.Servers {
+		if SameName(existing.Name, item.Name) {
+			// keep the stored spelling, which tokens and history are keyed by
+			item.Name = existing.Name
+		}
+	}
 	if item.Alias == "" {
 		item.Alias = item.Name
 	}
 	for i := range cfg.Servers {
 		if cfg.Servers[i].Name == item.Name {
-			cfg.Servers[i] = item
-			return
+			// only the fields add can set are replaced; oauth, timeouts, tls
+			// and the rest stay as the config file has them
+			merged := cfg.Servers[i]
+			merged.Alias = item.Alias
+			merged.URL = item.URL
+			merged.Transport = item.Transport
+			merged.Headers = item.Headers
+			merged.Command = item.Command
+			merged.Env = item.Env
+			merged.Roots = item.Roots
+			if sameServer(cfg.Servers[i], merged) {
+				return ServerUnchanged
+			}
+			cfg.Servers[i] = merged
+			return ServerUpdated
 		}
 	}
+	cfg.Server.ApplyDefaults(&item)
 	cfg.Servers = append(cfg.Servers, item)
+	return ServerAdded
+}
+
+func sameServer(a, b MCPServer) bool {
+	// empty and missing lists/maps round-trip the same through yaml
+	for _, s := range []*MCPServer{&a, &b} {
+		if len(s.Headers) == 0 {
+			s.Headers = nil
+		}
+		if len(s.Command) == 0 {
+			s.Command = nil
+		}
+		if len(s.Env) == 0 {
+			s.Env = nil
+		}
+		if len(s.Roots) == 0 {
+			s.Roots = nil
+		}
+	}
+	return reflect.DeepEqual(a, b)
 }
 
-func RemoveServer(cfg *Config, name string) bool {
+func MergeServer(cfg *Config, patch MCPServer) (MCPServer, error) {
+	for _, existing := range cfg.Servers {
+		if !SameName(existing.Name, patch.Name) {
+			continue
+		}
+		merged := existing
+		if alias := strings.TrimSpace(patch.Alias); alias != "" {
+			merged.Alias = alias
+		}
+		if patch.URL != "" {
+			merged.URL = patch.URL
+		}
+		if patch.Transport != "" {
+			merged.Transport = patch.Transport
+		}
+		if len(patch.Headers) > 0 {
+			headers := make(map[string]string, len(existing.Headers)+len(patch.Headers))
+			for k, v := range existing.Headers {
+				headers[k] = v
+			}
+			for k, v := range patch.Headers {
+				headers[k] = v
+			}
+			merged.Headers = headers
+		}
+		if len(patch.Command) > 0 {
+			merged.Command = patch.Command
+		}
+		if len(patch.Env) > 0 {
+			merged.Env = patch.Env
+		}
+		if len(patch.Roots) > 0 {
+			merged.Roots = patch.Roots
+		}
+		return merged, nil
+	}
+	return MCPServer{}, fmt.Errorf("server %q not found", patch.Name)
+}
+
+// returns the stored name of the removed server
+func RemoveServer(cfg *Config, name string) (string, bool) {
 	for i := range cfg.Servers {
-		if cfg.Servers[i].Name == name {
+		if SameName(cfg.Servers[i].Name, name) {
+			removed := cfg.Servers[i].Name
 			cfg.Servers = append(cfg.Servers[:i], cfg.Servers[i+1:]...)
-			return true
+			return removed, true
 		}
 	}
-	return false
+	return "", false
+}
+
+// server names and aliases are trimmed when stored and matched ignoring
+// case, so "Notion " finds "notion" and the two cannot both be registered
+func SameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func nameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func FindServer(cfg *Config, nameOrAlias string) (MCPServer, bool) {
+	// a name match wins over another server's alias
+	for _, s := range cfg.Servers {
+		if SameName(s.Name, nameOrAlias) {
+			return s, true
+		}
+	}
+	for _, s := range cfg.Servers {
+		if SameName(s.Alias, nameOrAlias) {
+			return s, true
+		}
+	}
+	return MCPServer{}, false
 }
@@ -0,0 +1,290 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckEndpointURL(t *testing.T) {
+	cases := map[string]string{
+		"https://mcp.example.com/mcp": "",
+		"http://localhost:8080/mcp":   "",
+		"mcp.example.com/mcp":         "missing a scheme",
+		"localhost:8080/mcp":          "missing a scheme",
+		"https://mcp.example.com/a b": "whitespace",
+		"ftp://mcp.example.com":       "unsupported scheme",
+		"https:///mcp":                "no host",
+	}
+	for raw, want := range cases {
+		err := CheckEndpointURL(raw)
+		if want == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", raw, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", raw, want, err)
+		}
+	}
+}
+
+func TestMergeServerKeepsUnsetFields(t *testing.T) {
+	cfg := &Config{Servers: []MCPServer{{
+		Name:      "notion",
+		Alias:     "notion",
+		URL:       "https://mcp.notion.com/mcp",
+		Transport: "http",
+		Headers:   map[string]string{"Authorization": "Bearer a"},
+	}}}
+	merged, err := MergeServer(cfg, MCPServer{Name: "notion", Alias: "n", Headers: map[string]string{"X-Team": "core"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if merged.Alias != "n" || merged.URL != "https://mcp.notion.com/mcp" || merged.Transport != "http" {
+		t.Fatalf("unexpected merged server: %+v", merged)
+	}
+	if merged.Headers["Authorization"] != "Bearer a" || merged.Headers["X-Team"] != "core" {
+		t.Fatalf("expected headers to be merged, got %v", merged.Headers)
+	}
+	if _, ok := cfg.Servers[0].Headers["X-Team"]; ok {
+		t.Fatal("merge must not mutate the stored headers")
+	}
+	if _, err := MergeServer(cfg, MCPServer{Name: "missing"}); err == nil {
+		t.Fatal("expected error for unknown server")
+	}
+}
+
+func TestUpsertServerReportsChange(t *testing.T) {
+	cfg := &Config{}
+	item := MCPServer{Name: "notion", URL: "https://mcp.notion.com/mcp", Headers: map[string]string{}}
+	if got := UpsertServer(cfg, item); got != ServerAdded {
+		t.Fatalf("expected added, got %s", got)
+	}
+	if got := UpsertServer(cfg, MCPServer{Name: "notion", URL: "https://mcp.notion.com/mcp"}); got != ServerUnchanged {
+		t.Fatalf("expected unchanged, got %s", got)
+	}
+	if got := UpsertServer(cfg, MCPServer{Name: "notion", URL: "https://mcp.notion.com/v2"}); got != ServerUpdated {
+		t.Fatalf("expected updated, got %s", got)
+	}
+	if len(cfg.Servers) != 1 || cfg.Servers[0].URL != "https://mcp.notion.com/v2" {
+		t.Fatalf("unexpected servers: %+v", cfg.Servers)
+	}
+}
+
+func TestUpsertServerKeepsConfigOnlyFields(t *testing.T) {
+	cfg := &Config{Servers: []MCPServer{{
+		Name:        "notion",
+		Alias:       "notion",
+		URL:         "https://mcp.notion.com/mcp",
+		Transport:   "http",
+		Timeout:     30 * time.Second,
+		CallHeaders: map[string]string{"X-Tenant": "acme"},
+		BaseArgs:    map[string]interface{}{"workspace": "acme"},
+		OAuth:       &OAuthOptions{Scopes: []string{"read"}},
+	}}}
+	if got := UpsertServer(cfg, MCPServer{Name: "notion", URL: "https://mcp.notion.com/mcp"}); got != ServerUnchanged {
+		t.Fatalf("expected re-adding the same entry to be unchanged, got %s", got)
+	}
+	if got := UpsertServer(cfg, MCPServer{Name: "notion", URL: "https://mcp.notion.com/v2"}); got != ServerUpdated {
+		t.Fatalf("expected updated, got %s", got)
+	}
+	s := cfg.Servers[0]
+	if s.URL != "https://mcp.notion.com/v2" || s.Timeout != 30*time.Second || s.CallHeaders["X-Tenant"] != "acme" || s.BaseArgs["workspace"] != "acme" || s.OAuth == nil {
+		t.Fatalf("expected config-only fields to survive the update, got %+v", s)
+	}
+}
+
+func TestServerNamesIgnoreCaseAndSpace(t *testing.T) {
+	cfg := &Config{}
+	UpsertServer(cfg, MCPServer{Name: " Notion ", Alias: "n", URL: "https://mcp.notion.com/mcp"})
+	if got := UpsertServer(cfg, MCPServer{Name: "notion", Alias: "n", URL: "https://mcp.notion.com/v2"}); got != ServerUpdated {
+		t.Fatalf("expected a differently cased name to update the entry, got %s", got)
+	}
+	if len(cfg.Servers) != 1 || cfg.Servers[0].Name != "Notion" {
+		t.Fatalf("expected one entry keeping its stored name, got %+v", cfg.Servers)
+	}
+	for _, query := range []string{"notion", "NOTION ", "N"} {
+		if s, ok := FindServer(cfg, query); !ok || s.Name != "Notion" {
+			t.Errorf("expected %q to find Notion, got %+v %v", query, s, ok)
+		}
+	}
+	if _, err := MergeServer(cfg, MCPServer{Name: "NOTION", Alias: "nt"}); err != nil {
+		t.Errorf("expected merge to match ignoring case, got %v", err)
+	}
+	if name, ok := RemoveServer(cfg, "notion"); !ok || name != "Notion" {
+		t.Errorf("expected removal to report the stored name, got %q %v", name, ok)
+	}
+
+	path := writeConfig(t, "servers:\n  - name: notion\n    url: https://a.example.com/mcp\n  - name: Notion\n    url: https://b.example.com/mcp\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "duplicate server name") {
+		t.Errorf("expected names differing only in case to clash, got %v", err)
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	path := writeConfig(t, "servers:\n  - name: export\n    url: https://export.example.com/mcp\n    timeout: 5m\n")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Servers[0].RequestTimeout(time.Minute); got != 5*time.Minute {
+		t.Errorf("expected the configured timeout, got %s", got)
+	}
+	if got := (MCPServer{}).RequestTimeout(time.Minute); got != time.Minute {
+		t.Errorf("expected the fallback without a timeout, got %s", got)
+	}
+	path = writeConfig(t, "servers:\n  - name: export\n    url: https://export.example.com/mcp\n    timeout: soon\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "time.Duration") {
+		t.Errorf("expected an unparsable duration to fail, got %v", err)
+	}
+	if err := ValidateServer(MCPServer{Name: "export", URL: "https://export.example.com/mcp", Timeout: -time.Second}); err == nil {
+		t.Error("expected a negative timeout to fail validation")
+	}
+}
+
+func TestLoadEphemeralFromEnv(t *testing.T) {
+	t.Setenv("MCPSHIM_EPHEMERAL", "1")
+	t.Setenv("MCPSHIM_SERVERS", `[{"name":"notion","url":"https://mcp.notion.com/mcp","call_headers":{"X-Team":"core"}},{"name":"local","transport":"stdio","command":["python","-m","srv"]}]`)
+	path := t.TempDir() + "/config.yaml"
+
+	cfg, err := LoadOrInit(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Servers) != 2 {
+		t.Fatalf("expected 2 servers, got %+v", cfg.Servers)
+	}
+	if s := cfg.Servers[0]; s.Transport != "http" || s.Alias != "notion" || s.CallHeaders["X-Team"] != "core" {
+		t.Fatalf("unexpected normalized server: %+v", s)
+	}
+	if err := Save(path, cfg); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no config file in ephemeral mode, stat err: %v", err)
+	}
+}
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadReportsYAMLLine(t *testing.T) {
+	path := writeConfig(t, "servers:\n  - name: notion\n    url: [unterminated\n")
+	_, err := Load(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+":") {
+		t.Fatalf("expected error prefixed with %s, got %v", path, err)
+	}
+
+	path = writeConfig(t, "servers:\n  - name: notion\n    url: https://mcp.notion.com/mcp\n    tranport: http\n")
+	_, err = Load(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+":4: field tranport not found") {
+		t.Fatalf("expected unknown field on line 4, got %v", err)
+	}
+}
+
+func TestLoadReportsInvalidServerPosition(t *testing.T) {
+	path := writeConfig(t, `servers:
+  - name: notion
+    url: https://mcp.notion.com/mcp
+  - name: local
+    transport: ftp
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("expected invalid transport to fail")
+	}
+	want := path + `:4:5: servers[1]: server "local": unsupported transport "ftp"`
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("expected error starting with %q, got %q", want, err.Error())
+	}
+}
+
+func TestValidateServerOAuthClientSecret(t *testing.T) {
+	base := MCPServer{Name: "corp", URL: "https://mcp.corp.example.com/mcp"}
+	cases := []struct {
+		oauth   OAuthOptions
+		allow   bool
+		wantErr string
+	}{
+		{oauth: OAuthOptions{ClientID: "abc", ClientSecret: "${CORP_SECRET}"}},
+		{oauth: OAuthOptions{ClientID: "abc", ClientSecret: "cmd:vault read secret"}, allow: true},
+		{oauth: OAuthOptions{ClientID: "abc", ClientSecret: "hunter2"}, wantErr: "not the secret itself"},
+		{oauth: OAuthOptions{ClientID: "abc", ClientSecret: "cmd:vault read secret"}, wantErr: "allow_command_headers"},
+		{oauth: OAuthOptions{ClientSecret: "${CORP_SECRET}"}, wantErr: "requires oauth.client_id"},
+	}
+	for _, tc := range cases {
+		s := base
+		oauth := tc.oauth
+		s.OAuth = &oauth
+		s.AllowCommandHeaders = tc.allow
+		err := ValidateServer(s)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %v", tc.oauth, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%+v: expected error containing %q, got %v", tc.oauth, tc.wantErr, err)
+		}
+	}
+}
+
+func TestServerResultTTL(t *testing.T) {
+	path := writeConfig(t, "servers:\n  - name: projects\n    url: https://mcp.example.com/mcp\n    cache_ttl: 1m\n    cacheable:\n      list_projects:\n      get_schema: 10m\n")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Servers[0]
+	if s.ResultTTL("list_projects") != time.Minute || s.ResultTTL("get_schema") != 10*time.Minute || s.ResultTTL("create_project") != 0 {
+		t.Fatalf("unexpected ttls: %+v", s.Cacheable)
+	}
+
+	path = writeConfig(t, "servers:\n  - name: projects\n    url: https://mcp.example.com/mcp\n    cacheable:\n      list_projects:\n")
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "needs a positive ttl") {
+		t.Fatalf("expected missing ttl error, got %v", err)
+	}
+}
+
+func TestValidateServerTLSFiles(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "client.pem")
+	if err := os.WriteFile(cert, []byte("pem"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		server  MCPServer
+		wantErr string
+	}{
+		{name: "no tls", server: MCPServer{Name: "a", URL: "https://a.example.com/mcp"}},
+		{name: "ca only", server: MCPServer{Name: "a", URL: "https://a.example.com/mcp", TLSCACert: cert}},
+		{name: "cert and key", server: MCPServer{Name: "a", URL: "https://a.example.com/mcp", TLSClientCert: cert, TLSClientKey: cert}},
+		{name: "cert without key", server: MCPServer{Name: "a", URL: "https://a.example.com/mcp", TLSClientCert: cert}, wantErr: "must be set together"},
+		{name: "missing file", server: MCPServer{Name: "a", URL: "https://a.example.com/mcp", TLSCACert: filepath.Join(dir, "nope.pem")}, wantErr: "tls_ca_cert"},
+		{name: "stdio", server: MCPServer{Name: "a", Transport: "stdio", Command: []string{"srv"}, TLSCACert: cert}, wantErr: "stdio"},
+	}
+	for _, tc := range cases {
+		err := ValidateServer(tc.server)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: expected error mentioning %s, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	"github.com/prbarcelon/mcpshim/internal/config"
+)
+
+const (
+	// consecutive failed tool listings before a server's circuit opens
+	breakerThreshold = 3
+	// how long an open circuit skips live attempts before letting one probe through
+	breakerCooldown = time.Minute
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+	lastErr  string
+}
+
+func (b *breaker) stateLocked(now time.Time) string {
+	switch {
+	case b.openedAt.IsZero():
+		return breakerClosed
+	case now.Sub(b.openedAt) < breakerCooldown:
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+// once the cooldown is over a single caller probes the server; the rest
+// keep failing fast until that probe reports back
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stateLocked(now) {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *breaker) record(err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil {
+		b.failures, b.openedAt, b.lastErr = 0, time.Time{}, ""
+		return
+	}
+	b.failures++
+	b.lastErr = err.Error()
+	if b.failures >= breakerThreshold {
+		b.openedAt = now
+	}
+}
+
+func (b *breaker) snapshot(now time.Time) (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked(now), b.failures
+}
+
+func (r *Registry) breakerFor(server string) *breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[server]
+	if !ok {
+		b = &breaker{}
+		r.breakers[server] = b
+	}
+	return b
+}
+
+// a live tool listing under the server's timeout and guarded by its circuit,
+// so a server that keeps failing costs one fast error instead of a timeout
+// per request
+func (r *Registry) fetchToolsLive(ctx context.Context, s config.MCPServer, interactive bool) ([]mcpproto.Tool, error) {
+	b := r.breakerFor(s.Name)
+	if !b.allow(time.Now()) {
+		b.mu.Lock()
+		retry := breakerCooldown - time.Since(b.openedAt)
+		failures, lastErr := b.failures, b.lastErr
+		b.mu.Unlock()
+		return nil, fmt.Errorf("server %q circuit open after %d consecutive failures, next attempt in %s: %s", s.Name, failures, max(retry, 0).Round(time.Second), lastErr)
+	}
+	timeout := s.RequestTimeout(DefaultListTimeout)
+	listCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	raw, err := fetchToolsRaw(r.scoped(listCtx), s, r.store, interactive)
+	if err != nil && errors.Is(listCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("server %q timed out after %s listing tools: %w", s.Name, timeout, err)
+	}
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up; that says nothing about the server
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+		return raw, err
+	}
+	b.record(err, time.Now())
+	return raw, err
+}
+
+func (r *Registry) OpenCircuits() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	out := []string{}
+	for name, b := range r.breakers {
+		if state, _ := b.snapshot(now); state != breakerClosed {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
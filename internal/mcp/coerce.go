@@ -0,0 +1,108 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func schemaPropertyTypes(schema interface{}) map[string]string {
+	type inputSchema struct {
+		Properties map[string]map[string]interface{} `json:"properties"`
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return nil
+	}
+	var parsed inputSchema
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return nil
+	}
+	out := make(map[string]string, len(parsed.Properties))
+	for name, prop := range parsed.Properties {
+		if typ, ok := prop["type"].(string); ok {
+			out[name] = typ
+		}
+	}
+	return out
+}
+
+func coerceArgs(schema interface{}, args map[string]interface{}) (map[string]interface{}, error) {
+	types := schemaPropertyTypes(schema)
+	if len(types) == 0 || len(args) == 0 {
+		return args, nil
+	}
+	out := make(map[string]interface{}, len(args))
+	for key, value := range args {
+		typ, ok := types[key]
+		if !ok {
+			out[key] = value
+			continue
+		}
+		coerced, err := coerceValue(value, typ)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q: %w", key, err)
+		}
+		out[key] = coerced
+	}
+	return out, nil
+}
+
+func coerceValue(value interface{}, typ string) (interface{}, error) {
+	// an explicit null clears an optional field; the server decides whether
+	// that is allowed
+	if value == nil {
+		return nil, nil
+	}
+	switch typ {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		}
+	case "integer":
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			// outside int64 the conversion is undefined, and on amd64 it
+			// quietly turns into math.MinInt64
+			if v == math.Trunc(v) && v >= -(1<<63) && v < 1<<63 {
+				return int64(v), nil
+			}
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	case "number":
+		switch v := value.(type) {
+		case float64, int64:
+			return v, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f, nil
+			}
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot use %v (%T) as %s", value, value, typ)
+}
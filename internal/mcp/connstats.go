@@ -0,0 +1,85 @@
+package mcp
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+// connection counters per server name, kept by each registry; every
+// operation opens its own connection, so opened climbing with calls is
+// expected, while failed or a stuck active count points at a server that
+// keeps dying
+type connStats struct {
+	mu      sync.Mutex
+	servers map[string]*connCounters
+}
+
+type connCounters struct {
+	active atomic.Int64
+	opened atomic.Int64
+	failed atomic.Int64
+}
+
+func newConnStats() *connStats {
+	return &connStats{servers: map[string]*connCounters{}}
+}
+
+func (c *connStats) forServer(server string) *connCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counters, ok := c.servers[server]
+	if !ok {
+		counters = &connCounters{}
+		c.servers[server] = counters
+	}
+	return counters
+}
+
+type registryKey struct{}
+
+// connections opened under the returned context count towards this
+// registry's counters and report their handshake to it
+func (r *Registry) scoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// nil when the context does not belong to a registry
+func connCountersFor(ctx context.Context, server string) *connCounters {
+	r, _ := ctx.Value(registryKey{}).(*Registry)
+	if r == nil {
+		return nil
+	}
+	return r.conns.forServer(server)
+}
+
+func trackConnection(ctx context.Context, server string) func() {
+	counters := connCountersFor(ctx, server)
+	if counters == nil {
+		return func() {}
+	}
+	counters.active.Add(1)
+	return func() { counters.active.Add(-1) }
+}
+
+func (r *Registry) ConnStats() []protocol.ConnStats {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	out := make([]protocol.ConnStats, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		counters := r.conns.forServer(s.Name)
+		out = append(out, protocol.ConnStats{
+			Server: s.Name,
+			Active: counters.active.Load(),
+			Opened: counters.opened.Load(),
+			Failed: counters.failed.Load(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Server < out[j].Server })
+	return out
+}
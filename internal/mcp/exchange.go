@@ -0,0 +1,48 @@
+package mcp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+type exchangeKey struct{}
+
+// collects the messages a verbose call sends and receives, in order
+type Exchange struct {
+	mu    sync.Mutex
+	steps []protocol.ExchangeStep
+}
+
+func WithExchange(ctx context.Context) (context.Context, *Exchange) {
+	exchange := &Exchange{}
+	return context.WithValue(ctx, exchangeKey{}, exchange), exchange
+}
+
+func (e *Exchange) Steps() []protocol.ExchangeStep {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]protocol.ExchangeStep(nil), e.steps...)
+}
+
+func recordExchange(ctx context.Context, method string, request interface{}, response interface{}, err error, started time.Time) {
+	exchange, ok := ctx.Value(exchangeKey{}).(*Exchange)
+	if !ok {
+		return
+	}
+	step := protocol.ExchangeStep{
+		Method:     method,
+		Request:    request,
+		DurationMs: int64(time.Since(started) / time.Millisecond),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	} else {
+		step.Response = response
+	}
+	exchange.mu.Lock()
+	exchange.steps = append(exchange.steps, step)
+	exchange.mu.Unlock()
+}
@@ -0,0 +1,94 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prbarcelon/mcpshim/internal/config"
+)
+
+const (
+	headerCommandTimeout = 30 * time.Second
+	headerCommandTTL     = 5 * time.Minute
+)
+
+type cachedHeader struct {
+	value   string
+	expires time.Time
+}
+
+var headerCache = struct {
+	sync.Mutex
+	items map[string]cachedHeader
+}{items: map[string]cachedHeader{}}
+
+func withResolvedHeaders(ctx context.Context, s config.MCPServer) (config.MCPServer, error) {
+	if !s.AllowCommandHeaders {
+		return s, nil
+	}
+	// resolve into a copy so the token never lands in the shared config or on disk
+	headers := make(map[string]string, len(s.Headers))
+	for key, value := range s.Headers {
+		if config.IsCommandHeader(value) {
+			resolved, err := runHeaderCommand(ctx, strings.TrimSpace(strings.TrimPrefix(value, config.CommandHeaderPrefix)))
+			if err != nil {
+				return s, fmt.Errorf("server %q header %s: %w", s.Name, key, err)
+			}
+			value = resolved
+		}
+		headers[key] = value
+	}
+	s.Headers = headers
+	return s, nil
+}
+
+func resolveSecret(ctx context.Context, s config.MCPServer, ref string) (string, error) {
+	if config.IsCommandHeader(ref) {
+		if !s.AllowCommandHeaders {
+			return "", fmt.Errorf("command references need allow_command_headers: true")
+		}
+		return runHeaderCommand(ctx, strings.TrimSpace(strings.TrimPrefix(ref, config.CommandHeaderPrefix)))
+	}
+	value := os.ExpandEnv(ref)
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", ref)
+	}
+	return value, nil
+}
+
+func runHeaderCommand(ctx context.Context, command string) (string, error) {
+	headerCache.Lock()
+	cached, ok := headerCache.items[command]
+	headerCache.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, headerCommandTimeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("command failed: %w: %s", err, msg)
+		}
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+	value := strings.TrimSpace(stdout.String())
+	if value == "" {
+		return "", fmt.Errorf("command printed nothing")
+	}
+
+	headerCache.Lock()
+	headerCache.items[command] = cachedHeader{value: value, expires: time.Now().Add(headerCommandTTL)}
+	headerCache.Unlock()
+	return value, nil
+}
@@ -0,0 +1,59 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prbarcelon/mcpshim/internal/config"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+// connects to a server and completes initialize without calling anything;
+// an empty server checks every enabled one, all at once
+func (r *Registry) HealthCheck(ctx context.Context, server string) ([]protocol.ServerHealth, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	servers := enabledServers(cfg.Servers)
+	if server != "" {
+		s, err := activeServer(cfg, server)
+		if err != nil {
+			return nil, err
+		}
+		servers = []config.MCPServer{s}
+	}
+	out := make([]protocol.ServerHealth, 0, len(servers))
+	var mu sync.Mutex
+	eachServer(servers, max(len(servers), 1), func(s config.MCPServer) {
+		health := r.checkServer(ctx, s)
+		mu.Lock()
+		out = append(out, health)
+		mu.Unlock()
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Server < out[j].Server })
+	return out, nil
+}
+
+func (r *Registry) checkServer(ctx context.Context, s config.MCPServer) protocol.ServerHealth {
+	timeout := s.RequestTimeout(DefaultListTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	started := time.Now()
+	// a stored oauth token is used, but a probe never starts a login
+	_, err := runWithOAuthFallback(r.scoped(ctx), s, r.store, false, func(cli compatibleClient) (struct{}, error) {
+		return struct{}{}, nil
+	})
+	health := protocol.ServerHealth{Server: s.Name, OK: err == nil, LatencyMs: time.Since(started).Milliseconds()}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("server %q timed out after %s: %w", s.Name, timeout, err)
+		}
+		health.Error = err.Error()
+	}
+	return health
+}
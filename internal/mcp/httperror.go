@@ -0,0 +1,113 @@
+package mcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// how much of a failed response body is kept for the error
+const upstreamBodySnippet = 512
+
+// response headers worth showing when a gateway or auth server says no
+var upstreamErrorHeaders = []string{"WWW-Authenticate", "Retry-After", "X-Request-Id"}
+
+// the upstream response behind a failed http or sse exchange, which mcp-go
+// reduces to "unauthorized (401)" or a bare status
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Headers    map[string]string
+	Body       string
+	Err        error
+}
+
+func (e *HTTPError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v (upstream http %s", e.Err, e.Status)
+	for _, key := range upstreamErrorHeaders {
+		if value, ok := e.Headers[key]; ok {
+			fmt.Fprintf(&b, "; %s: %s", strings.ToLower(key), value)
+		}
+	}
+	// mcp-go already quotes the body for most statuses
+	if e.Body != "" && !strings.Contains(e.Err.Error(), e.Body) {
+		fmt.Fprintf(&b, "; body: %s", e.Body)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// each client gets its own recorder as round tripper, so an error from an
+// operation can name the last response that failed
+type responseRecorder struct {
+	mu   sync.Mutex
+	last *HTTPError
+	// the transport requests go out on; nil means http.DefaultTransport
+	base http.RoundTripper
+}
+
+func (r *responseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := r.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode < 400 {
+		r.mu.Lock()
+		r.last = nil
+		r.mu.Unlock()
+		return resp, nil
+	}
+	failure := &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Headers: map[string]string{}}
+	for _, key := range upstreamErrorHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			failure.Headers[key] = value
+		}
+	}
+	// peek at the start of the body and hand the whole of it back to mcp-go
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, upstreamBodySnippet))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+	failure.Body = strings.Join(strings.Fields(string(head)), " ")
+	r.mu.Lock()
+	r.last = failure
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *responseRecorder) httpClient() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *responseRecorder) annotate(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	var already *HTTPError
+	if errors.As(err, &already) {
+		return err
+	}
+	r.mu.Lock()
+	last := r.last
+	r.mu.Unlock()
+	if last == nil {
+		return err
+	}
+	annotated := *last
+	annotated.Err = err
+	return &annotated
+}
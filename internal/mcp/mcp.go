@@ -3,8 +3,16 @@ package mcp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,6 +22,14 @@ import (
 	"github.com/prbarcelon/mcpshim/internal/config"
 	"github.com/prbarcelon/mcpshim/internal/protocol"
 	"github.com/prbarcelon/mcpshim/internal/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// deadlines for servers without a timeout of their own
+const (
+	DefaultCallTimeout = 60 * time.Second
+	DefaultListTimeout = 20 * time.Second
 )
 
 type Registry struct {
@@ -21,19 +37,89 @@ type Registry struct {
 	cfg        *config.Config
 	store      *store.Store
 	toolCache  map[string][]protocol.ToolInfo
+	schemas    map[string]map[string]interface{}
 	cacheStamp time.Time
+	refreshed  map[string]time.Time
+	partial    bool
+	middleware []Middleware
+	breakers   map[string]*breaker
+	results    *resultCache
+	conns      *connStats
+	// latest initialize result per configured server, filled in by every handshake
+	impls map[string]*mcpproto.InitializeResult
 }
 
 func NewRegistry(cfg *config.Config, dbStore *store.Store) *Registry {
-	return &Registry{cfg: cfg, store: dbStore, toolCache: map[string][]protocol.ToolInfo{}}
+	return &Registry{
+		cfg:        cfg,
+		store:      dbStore,
+		toolCache:  map[string][]protocol.ToolInfo{},
+		schemas:    map[string]map[string]interface{}{},
+		refreshed:  map[string]time.Time{},
+		middleware: buildMiddleware(cfg.Server.Middleware),
+		breakers:   map[string]*breaker{},
+		results:    newResultCache(),
+		conns:      newConnStats(),
+		impls:      map[string]*mcpproto.InitializeResult{},
+	}
 }
 
 func (r *Registry) UpdateConfig(cfg *config.Config) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if !reflect.DeepEqual(r.cfg.Server.Middleware, cfg.Server.Middleware) {
+		// processes start on first use, so only the old ones need stopping
+		closeMiddleware(r.middleware)
+		r.middleware = buildMiddleware(cfg.Server.Middleware)
+	}
 	r.cfg = cfg
 	r.toolCache = map[string][]protocol.ToolInfo{}
+	r.schemas = map[string]map[string]interface{}{}
 	r.cacheStamp = time.Time{}
+	r.refreshed = map[string]time.Time{}
+	r.partial = false
+	// a changed config may well be the fix, so every server gets a fresh try
+	r.breakers = map[string]*breaker{}
+	r.results.clear()
+	// a removed or repointed server must not keep showing its old identity
+	r.impls = map[string]*mcpproto.InitializeResult{}
+}
+
+func (r *Registry) Invalidate(server string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if server == "" {
+		r.toolCache = map[string][]protocol.ToolInfo{}
+		r.schemas = map[string]map[string]interface{}{}
+		r.cacheStamp = time.Time{}
+		r.refreshed = map[string]time.Time{}
+		r.partial = false
+		r.results.clear()
+		return "", nil
+	}
+	s, ok := findServer(r.cfg, server)
+	if !ok {
+		return "", fmt.Errorf("unknown server %q", server)
+	}
+	delete(r.toolCache, s.Name)
+	delete(r.schemas, s.Name)
+	delete(r.refreshed, s.Name)
+	r.results.dropServer(s.Name)
+	// the remaining entries stay valid, but a combined listing would now miss this server
+	r.partial = true
+	return s.Name, nil
+}
+
+// ad-hoc targets are not listed anywhere, so only configured servers are kept
+func (r *Registry) rememberImpl(server string, result *mcpproto.InitializeResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.cfg.Servers {
+		if s.Name == server {
+			r.impls[server] = result
+			return
+		}
+	}
 }
 
 func (r *Registry) Servers() []protocol.ServerInfo {
@@ -41,7 +127,7 @@ func (r *Registry) Servers() []protocol.ServerInfo {
 	defer r.mu.RUnlock()
 	out := make([]protocol.ServerInfo, 0, len(r.cfg.Servers))
 	for _, s := range r.cfg.Servers {
-		out = append(out, protocol.ServerInfo{
+		info := protocol.ServerInfo{
 			Name:      s.Name,
 			Alias:     s.Alias,
 			URL:       s.URL,
@@ -49,39 +135,72 @@ func (r *Registry) Servers() []protocol.ServerInfo {
 			HasAuth:   hasAuthorizationHeader(s.Headers),
 			Command:   s.Command,
 			Env:       s.Env,
-		})
+			Disabled:  s.Disabled,
+		}
+		if stamp, ok := r.refreshed[s.Name]; ok {
+			info.LastRefresh = &stamp
+		}
+		if b, ok := r.breakers[s.Name]; ok {
+			state, failures := b.snapshot(time.Now())
+			if state != breakerClosed {
+				info.Circuit = state
+			}
+			info.ConsecutiveFailures = failures
+		}
+		if result, ok := r.impls[s.Name]; ok {
+			info.ImplName = result.ServerInfo.Name
+			info.ImplVersion = result.ServerInfo.Version
+			info.ProtocolVersion = result.ProtocolVersion
+			info.Instructions = result.Instructions
+		}
+		out = append(out, info)
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
 	return out
 }
 
-func (r *Registry) ListTools(ctx context.Context, server string) ([]protocol.ToolInfo, error) {
+func (r *Registry) ListTools(ctx context.Context, server string) ([]protocol.ToolInfo, time.Time, error) {
+	// the returned time is when the oldest list served from the persisted
+	// cache was fetched, and zero when every list came live
 	r.mu.RLock()
 	cfg := r.cfg
 	r.mu.RUnlock()
 
 	if server != "" {
-		s, ok := findServer(cfg, server)
-		if !ok {
-			return nil, fmt.Errorf("unknown server %q", server)
+		s, err := activeServer(cfg, server)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		raw, cachedAt, err := r.fetchTools(ctx, s)
+		if err != nil {
+			return nil, time.Time{}, err
 		}
-		return fetchToolsForServer(ctx, s, r.store, true)
+		return toolInfos(s, raw), cachedAt, nil
 	}
 
 	all := []protocol.ToolInfo{}
-	for _, s := range cfg.Servers {
-		items, err := fetchToolsForServer(ctx, s, r.store, true)
+	var oldest time.Time
+	var mu sync.Mutex
+	eachServer(enabledServers(cfg.Servers), fetchConcurrency(cfg), func(s config.MCPServer) {
+		raw, cachedAt, err := r.fetchTools(ctx, s)
 		if err != nil {
-			continue
+			return
 		}
-		all = append(all, items...)
-	}
+		tools := toolInfos(s, raw)
+		mu.Lock()
+		defer mu.Unlock()
+		if !cachedAt.IsZero() && (oldest.IsZero() || cachedAt.Before(oldest)) {
+			oldest = cachedAt
+		}
+		all = append(all, tools...)
+	})
 	sort.Slice(all, func(i, j int) bool {
 		if all[i].Server == all[j].Server {
 			return all[i].Name < all[j].Name
 		}
 		return all[i].Server < all[j].Server
 	})
-	return all, nil
+	return all, oldest, nil
 }
 
 func (r *Registry) Refresh(ctx context.Context) error {
@@ -90,21 +209,125 @@ func (r *Registry) Refresh(ctx context.Context) error {
 	r.mu.RUnlock()
 
 	cache := map[string][]protocol.ToolInfo{}
-	for _, s := range cfg.Servers {
-		tools, err := fetchToolsForServer(ctx, s, r.store, false)
+	schemas := map[string]map[string]interface{}{}
+	refreshed := map[string]time.Time{}
+	var mu sync.Mutex
+	// a failing server just stays out of the new cache
+	eachServer(enabledServers(cfg.Servers), fetchConcurrency(cfg), func(s config.MCPServer) {
+		raw, err := r.fetchToolsLive(ctx, s, false)
 		if err != nil {
-			continue
+			return
+		}
+		tools := toolInfos(s, raw)
+		if r.store != nil {
+			_ = r.store.SaveToolSnapshot(ctx, s.Name, toolSnapshot(raw))
+			r.saveToolCache(ctx, s.Name, raw)
 		}
+		warnUnknownDefaults(s, tools)
+		mu.Lock()
+		defer mu.Unlock()
 		cache[s.Name] = tools
-	}
+		schemas[s.Name] = toolSchemas(raw)
+		refreshed[s.Name] = time.Now().UTC()
+	})
 
 	r.mu.Lock()
 	r.toolCache = cache
+	r.schemas = schemas
 	r.cacheStamp = time.Now().UTC()
+	r.refreshed = refreshed
+	r.partial = false
 	r.mu.Unlock()
 	return nil
 }
 
+func (r *Registry) ToolDiff(ctx context.Context, server string) (*protocol.ToolDiff, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, ok := findServer(cfg, server)
+	if !ok {
+		return nil, fmt.Errorf("unknown server %q", server)
+	}
+	if r.store == nil {
+		return nil, fmt.Errorf("tool snapshots are not available")
+	}
+	previous, current, changedAt, err := r.store.GetToolSnapshots(ctx, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("no tool snapshot recorded for server %q yet", s.Name)
+	}
+	diff := diffToolSnapshots(previous, current)
+	diff.Server = s.Name
+	diff.ChangedAt = changedAt
+	return diff, nil
+}
+
+func (r *Registry) CacheStamp() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cacheStamp
+}
+
+func (r *Registry) CachedTools(server string) ([]protocol.ToolInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cacheStamp.IsZero() {
+		return nil, false
+	}
+	if server != "" {
+		s, ok := findServer(r.cfg, server)
+		if !ok {
+			return nil, false
+		}
+		items, ok := r.toolCache[s.Name]
+		return items, ok
+	}
+	if r.partial {
+		return nil, false
+	}
+	all := []protocol.ToolInfo{}
+	for _, items := range r.toolCache {
+		all = append(all, items...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Server == all[j].Server {
+			return all[i].Name < all[j].Name
+		}
+		return all[i].Server < all[j].Server
+	})
+	return all, true
+}
+
+func (r *Registry) ToolServers(ctx context.Context, tool string) ([]string, error) {
+	items, ok := r.CachedTools("")
+	if !ok {
+		var err error
+		if items, _, err = r.ListTools(ctx, ""); err != nil {
+			return nil, err
+		}
+	}
+	servers := []string{}
+	for _, item := range items {
+		if item.Name == tool && !slices.Contains(servers, item.Server) {
+			servers = append(servers, item.Server)
+		}
+	}
+	sort.Strings(servers)
+	return servers, nil
+}
+
+// servers whose tools are in the cache; fewer than the enabled servers means
+// some failed their last fetch or were invalidated since
+func (r *Registry) CachedServerCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.toolCache)
+}
+
 func (r *Registry) ToolCount() int {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -115,62 +338,233 @@ func (r *Registry) ToolCount() int {
 	return total
 }
 
-func (r *Registry) InspectTool(ctx context.Context, server, tool string) (*protocol.ToolDetail, error) {
+func (r *Registry) InspectTools(ctx context.Context, server string) ([]protocol.ToolDetail, time.Time, error) {
 	r.mu.RLock()
 	cfg := r.cfg
 	r.mu.RUnlock()
 
-	s, ok := findServer(cfg, server)
-	if !ok {
-		return nil, fmt.Errorf("unknown server %q", server)
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 
-	tools, err := fetchToolsRaw(ctx, s, r.store, true)
+	tools, cachedAt, err := r.fetchTools(ctx, s)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
+	}
+	out := make([]protocol.ToolDetail, 0, len(tools))
+	for _, t := range tools {
+		out = append(out, toolDetail(s.Name, t))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, cachedAt, nil
+}
+
+func (r *Registry) InspectTool(ctx context.Context, server, tool string) (*protocol.ToolDetail, time.Time, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	tools, cachedAt, err := r.fetchTools(ctx, s)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
 	for _, t := range tools {
 		if t.Name == tool {
-			required, _ := parseSchema(t.InputSchema)
-			return &protocol.ToolDetail{
-				Server:      s.Name,
-				Name:        t.Name,
-				Description: t.Description,
-				Properties:  parseSchemaDetail(t.InputSchema, required),
-			}, nil
+			detail := toolDetail(s.Name, t)
+			return &detail, cachedAt, nil
 		}
 	}
-	return nil, fmt.Errorf("tool %q not found on server %q", tool, server)
+	return nil, time.Time{}, fmt.Errorf("tool %q not found on server %q", tool, server)
 }
 
-func (r *Registry) Call(ctx context.Context, server string, tool string, args map[string]interface{}) (interface{}, error) {
+func toolDetail(server string, t mcpproto.Tool) protocol.ToolDetail {
+	required, _ := parseSchema(t.InputSchema)
+	outputRequired, _ := parseSchema(t.OutputSchema)
+	return protocol.ToolDetail{
+		Server:           server,
+		Name:             t.Name,
+		Description:      t.Description,
+		Properties:       parseSchemaDetail(t.InputSchema, required),
+		OutputProperties: parseSchemaDetail(t.OutputSchema, outputRequired),
+		Meta:             metaMap(t.Meta),
+	}
+}
+
+type CallTiming struct {
+	Connect time.Duration
+	Call    time.Duration
+	Cached  bool
+}
+
+func (r *Registry) Call(ctx context.Context, server string, tool string, args map[string]interface{}) (interface{}, CallTiming, error) {
 	r.mu.RLock()
 	cfg := r.cfg
 	r.mu.RUnlock()
 
-	s, ok := findServer(cfg, server)
-	if !ok {
-		return nil, fmt.Errorf("unknown server %q", server)
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, CallTiming{}, err
 	}
-	if args == nil {
-		args = map[string]interface{}{}
+	return r.CallServer(ctx, s, tool, args)
+}
+
+func (r *Registry) CallServer(ctx context.Context, s config.MCPServer, tool string, args map[string]interface{}) (interface{}, CallTiming, error) {
+	args = mergeDefaultArgs(s.BaseArgs, mergeDefaultArgs(s.Defaults[tool], args))
+
+	r.mu.RLock()
+	schema, hasSchema := r.schemas[s.Name][tool]
+	r.mu.RUnlock()
+	if hasSchema {
+		coerced, err := coerceArgs(schema, args)
+		if err != nil {
+			return nil, CallTiming{}, err
+		}
+		args = coerced
+	}
+
+	ttl := s.ResultTTL(tool)
+	cacheKey, cacheable := "", false
+	if ttl > 0 {
+		cacheKey, cacheable = resultKey(s.Name, tool, args)
+	}
+	if cacheable && ctx.Value(noCacheKey{}) == nil {
+		if result, ok := r.results.get(cacheKey, time.Now()); ok {
+			return result, CallTiming{Cached: true}, nil
+		}
+	}
+
+	ctx, span := tracer.Start(ctx, "mcp.call", trace.WithAttributes(
+		attribute.String("mcp.server", s.Name),
+		attribute.String("mcp.transport", s.Transport),
+		attribute.String("mcp.tool", tool),
+	))
+	timeout := s.RequestTimeout(DefaultCallTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var timing CallTiming
+	started := time.Now()
+	res, err := r.callWithMiddleware(ctx, s, tool, args, func(args map[string]interface{}) (interface{}, error) {
+		return runWithOAuthFallback(withoutReplay(r.scoped(ctx)), s, r.store, true, func(cli compatibleClient) (interface{}, error) {
+			req := mcpproto.CallToolRequest{}
+			req.Params.Name = tool
+			req.Params.Arguments = args
+			if len(s.CallHeaders) > 0 {
+				req.Header = http.Header{}
+				for k, v := range s.CallHeaders {
+					req.Header.Set(k, v)
+				}
+			}
+			watchProgress(ctx, s.Name, cli, &req)
+
+			toolCtx, toolSpan := tracer.Start(ctx, "mcp.tool")
+			callStarted := time.Now()
+			result, err := cli.CallTool(toolCtx, req)
+			timing.Call = time.Since(callStarted)
+			recordExchange(ctx, "tools/call", req.Params, result, err, callStarted)
+			endSpan(toolSpan, err)
+			if err != nil {
+				return nil, err
+			}
+			return result, nil
+		})
+	})
+	// everything outside the tool invocation itself (spawn, TLS, initialize,
+	// oauth retries) counts as connect time
+	timing.Connect = time.Since(started) - timing.Call
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("server %q timed out after %s: %w", s.Name, timeout, err)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, timing, err
+	}
+	if cacheable {
+		r.results.put(cacheKey, res, ttl, time.Now())
 	}
+	return res, timing, nil
+}
+
+func (r *Registry) RawCall(ctx context.Context, server string, method string, params interface{}) (interface{}, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
 
-	res, err := runWithOAuthFallback(ctx, s, r.store, true, func(cli compatibleClient) (interface{}, error) {
-		req := mcpproto.CallToolRequest{}
-		req.Params.Name = tool
-		req.Params.Arguments = args
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		return nil, fmt.Errorf("method is required")
+	}
 
-		result, err := cli.CallTool(ctx, req)
+	// any method can come through here, including tools/call
+	return runWithOAuthFallback(withoutReplay(r.scoped(ctx)), s, r.store, true, func(cli compatibleClient) (interface{}, error) {
+		// a string id keeps this request clear of the client's numeric id sequence
+		req := transport.JSONRPCRequest{
+			JSONRPC: mcpproto.JSONRPC_VERSION,
+			ID:      mcpproto.NewRequestId("mcpshim-rpc"),
+			Method:  method,
+			Params:  params,
+		}
+		resp, err := cli.GetTransport().SendRequest(ctx, req)
 		if err != nil {
 			return nil, err
 		}
-		return result, nil
+		return resp, nil
 	})
+}
+
+func (r *Registry) Subscribe(ctx context.Context, server string, uri string, onReady func(), onUpdate func(mcpproto.JSONRPCNotification)) error {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, err := activeServer(cfg, server)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return res, nil
+	if uri == "" {
+		return fmt.Errorf("uri is required")
+	}
+
+	var readyOnce sync.Once
+	_, err = runWithOAuthFallback(r.scoped(ctx), s, r.store, false, func(cli compatibleClient) (struct{}, error) {
+		cli.OnNotification(func(notification mcpproto.JSONRPCNotification) {
+			if notification.Method != mcpproto.MethodNotificationResourceUpdated {
+				return
+			}
+			if updated, _ := notification.Params.AdditionalFields["uri"].(string); updated != "" && updated != uri {
+				return
+			}
+			onUpdate(notification)
+		})
+		req := mcpproto.SubscribeRequest{}
+		req.Params.URI = uri
+		if err := cli.Subscribe(ctx, req); err != nil {
+			return struct{}{}, err
+		}
+		readyOnce.Do(onReady)
+		// hold the session open until the subscriber goes away; a dropped
+		// sse stream reconnects and subscribes again
+		select {
+		case <-ctx.Done():
+		case <-connectionLost(cli):
+			return struct{}{}, errSSEConnectionLost
+		}
+		unsub := mcpproto.UnsubscribeRequest{}
+		unsub.Params.URI = uri
+		unsubCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = cli.Unsubscribe(unsubCtx, unsub)
+		return struct{}{}, nil
+	})
+	return err
 }
 
 func (r *Registry) Login(ctx context.Context, server string, manual bool) error {
@@ -185,15 +579,187 @@ func (r *Registry) Login(ctx context.Context, server string, manual bool) error
 	if s.Transport == "stdio" {
 		return fmt.Errorf("server %q uses stdio transport; oauth login is not applicable", s.Name)
 	}
+	if !s.OAuthFallbackEnabled() {
+		return fmt.Errorf("server %q has oauth_fallback: false; its calls never use an oauth token", s.Name)
+	}
+
+	return runOAuthLogin(r.scoped(ctx), s, r.store, manual)
+}
+
+func (r *Registry) Logout(ctx context.Context, server string) (string, bool, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, ok := findServer(cfg, server)
+	if !ok {
+		return "", false, fmt.Errorf("unknown server %q", server)
+	}
+	if r.store == nil {
+		return "", false, fmt.Errorf("sqlite store is not available")
+	}
+	removed, err := r.store.DeleteToken(ctx, s.Name)
+	return s.Name, removed, err
+}
+
+func (r *Registry) CheckLogin(ctx context.Context, server string) error {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, ok := findServer(cfg, server)
+	if !ok {
+		return fmt.Errorf("unknown server %q", server)
+	}
+	if s.Transport == "stdio" {
+		return fmt.Errorf("server %q uses stdio transport; oauth login is not applicable", s.Name)
+	}
+
+	return runOAuthCheck(r.scoped(ctx), s, r.store)
+}
+
+func mergeDefaultArgs(defaults map[string]interface{}, args map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(args))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range args {
+		merged[k] = v
+	}
+	return merged
+}
+
+func warnUnknownDefaults(s config.MCPServer, tools []protocol.ToolInfo) {
+	if len(s.Defaults) == 0 {
+		return
+	}
+	known := make(map[string]bool, len(tools))
+	for _, t := range tools {
+		known[t.Name] = true
+	}
+	for name := range s.Defaults {
+		if !known[name] {
+			log.Printf("server %q defaults_file references unknown tool %q", s.Name, name)
+		}
+	}
+}
+
+// how many servers a refresh or a full tool list fetches at once
+const DefaultRefreshConcurrency = 4
+
+func fetchConcurrency(cfg *config.Config) int {
+	if cfg.Server.RefreshConcurrency > 0 {
+		return cfg.Server.RefreshConcurrency
+	}
+	return DefaultRefreshConcurrency
+}
 
-	return runOAuthLogin(ctx, s, r.store, manual)
+func enabledServers(servers []config.MCPServer) []config.MCPServer {
+	out := make([]config.MCPServer, 0, len(servers))
+	for _, s := range servers {
+		if !s.Disabled {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// runs fn for every server, with at most limit of them in flight
+func eachServer(servers []config.MCPServer, limit int, fn func(config.MCPServer)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, limit)
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s config.MCPServer) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			fn(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (r *Registry) fetchTools(ctx context.Context, s config.MCPServer) ([]mcpproto.Tool, time.Time, error) {
+	// discovery keeps working while a server is down by falling back to the
+	// last list persisted for it
+	raw, err := r.fetchToolsLive(ctx, s, true)
+	if err == nil {
+		r.saveToolCache(ctx, s.Name, raw)
+		return raw, time.Time{}, nil
+	}
+	if r.store == nil {
+		return nil, time.Time{}, err
+	}
+	// the failed fetch may have used up the request deadline
+	data, fetchedAt, cacheErr := r.store.GetToolCache(context.WithoutCancel(ctx), s.Name)
+	if cacheErr != nil || data == "" {
+		return nil, time.Time{}, err
+	}
+	var cached []mcpproto.Tool
+	if json.Unmarshal([]byte(data), &cached) != nil {
+		return nil, time.Time{}, err
+	}
+	return cached, fetchedAt, nil
 }
 
-func fetchToolsForServer(ctx context.Context, s config.MCPServer, dbStore *store.Store, interactive bool) ([]protocol.ToolInfo, error) {
-	raw, err := fetchToolsRaw(ctx, s, dbStore, interactive)
+func (r *Registry) saveToolCache(ctx context.Context, server string, raw []mcpproto.Tool) {
+	if r.store == nil {
+		return
+	}
+	data, err := json.Marshal(raw)
 	if err != nil {
-		return nil, err
+		return
+	}
+	_ = r.store.SaveToolCache(ctx, server, string(data))
+}
+
+func toolSnapshot(raw []mcpproto.Tool) map[string]string {
+	out := make(map[string]string, len(raw))
+	for _, t := range raw {
+		data, err := json.Marshal(t.InputSchema)
+		if err != nil {
+			continue
+		}
+		out[t.Name] = string(data)
+	}
+	return out
+}
+
+func diffToolSnapshots(previous, current map[string]string) *protocol.ToolDiff {
+	diff := &protocol.ToolDiff{}
+	if previous == nil {
+		return diff
+	}
+	for name, schema := range current {
+		old, ok := previous[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case old != schema:
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+func toolSchemas(raw []mcpproto.Tool) map[string]interface{} {
+	out := make(map[string]interface{}, len(raw))
+	for _, t := range raw {
+		out[t.Name] = t.InputSchema
 	}
+	return out
+}
+
+func toolInfos(s config.MCPServer, raw []mcpproto.Tool) []protocol.ToolInfo {
 	items := make([]protocol.ToolInfo, 0, len(raw))
 	for _, t := range raw {
 		required, properties := parseSchema(t.InputSchema)
@@ -205,7 +771,7 @@ func fetchToolsForServer(ctx context.Context, s config.MCPServer, dbStore *store
 			Properties:  properties,
 		})
 	}
-	return items, nil
+	return items
 }
 
 func fetchToolsRaw(ctx context.Context, s config.MCPServer, dbStore *store.Store, interactive bool) ([]mcpproto.Tool, error) {
@@ -239,15 +805,41 @@ func parseSchema(schema interface{}) ([]string, []string) {
 	return parsed.Required, props
 }
 
-func parseSchemaDetail(schema interface{}, requiredList []string) []protocol.PropertyDetail {
-	type propEntry struct {
-		Type        string        `json:"type"`
-		Enum        []interface{} `json:"enum"`
-		Const       interface{}   `json:"const"`
-		Description string        `json:"description"`
+// how many levels of object fields and array items are described under a
+// top-level property
+const schemaDetailDepth = 2
+
+// a json schema type is a name or a list of names, such as ["string","null"]
+type schemaType string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaType(name)
+		return nil
 	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*t = schemaType(strings.Join(names, "|"))
+	return nil
+}
+
+type schemaEntry struct {
+	Type        schemaType             `json:"type"`
+	Enum        []interface{}          `json:"enum"`
+	Const       interface{}            `json:"const"`
+	Description string                 `json:"description"`
+	Properties  map[string]schemaEntry `json:"properties"`
+	Required    []string               `json:"required"`
+	// kept raw, since a tuple schema gives a list here
+	Items json.RawMessage `json:"items"`
+}
+
+func parseSchemaDetail(schema interface{}, requiredList []string) []protocol.PropertyDetail {
 	type inputSchema struct {
-		Properties map[string]propEntry `json:"properties"`
+		Properties map[string]schemaEntry `json:"properties"`
 	}
 	b, err := json.Marshal(schema)
 	if err != nil {
@@ -257,21 +849,24 @@ func parseSchemaDetail(schema interface{}, requiredList []string) []protocol.Pro
 	if err := json.Unmarshal(b, &parsed); err != nil {
 		return nil
 	}
+	return propertyDetails(parsed.Properties, requiredList, schemaDetailDepth)
+}
 
+func propertyDetails(properties map[string]schemaEntry, requiredList []string, depth int) []protocol.PropertyDetail {
 	required := map[string]bool{}
 	for _, r := range requiredList {
 		required[r] = true
 	}
 
-	keys := make([]string, 0, len(parsed.Properties))
-	for k := range parsed.Properties {
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
 	out := make([]protocol.PropertyDetail, 0, len(keys))
 	for _, k := range keys {
-		p := parsed.Properties[k]
+		p := properties[k]
 		enum := []string{}
 		for _, v := range p.Enum {
 			enum = append(enum, fmt.Sprintf("%v", v))
@@ -280,14 +875,42 @@ func parseSchemaDetail(schema interface{}, requiredList []string) []protocol.Pro
 		if p.Const != nil {
 			constValue = fmt.Sprintf("%v", p.Const)
 		}
-		out = append(out, protocol.PropertyDetail{
+		detail := protocol.PropertyDetail{
 			Name:        k,
-			Type:        p.Type,
+			Type:        string(p.Type),
 			Enum:        enum,
 			Const:       constValue,
 			Description: p.Description,
 			Required:    required[k],
-		})
+		}
+		// an array of objects describes the fields of each element
+		fields := p
+		var items schemaEntry
+		if len(p.Items) > 0 && json.Unmarshal(p.Items, &items) == nil {
+			detail.Items = string(items.Type)
+			fields = items
+		}
+		if depth > 0 && len(fields.Properties) > 0 {
+			detail.Properties = propertyDetails(fields.Properties, fields.Required, depth-1)
+		}
+		out = append(out, detail)
+	}
+	return out
+}
+
+func metaMap(meta *mcpproto.Meta) map[string]interface{} {
+	if meta == nil {
+		return nil
+	}
+	out := map[string]interface{}{}
+	for k, v := range meta.AdditionalFields {
+		out[k] = v
+	}
+	if meta.ProgressToken != nil {
+		out["progressToken"] = meta.ProgressToken
+	}
+	if len(out) == 0 {
+		return nil
 	}
 	return out
 }
@@ -297,58 +920,156 @@ type compatibleClient interface {
 	Initialize(ctx context.Context, request mcpproto.InitializeRequest) (*mcpproto.InitializeResult, error)
 	ListTools(ctx context.Context, req mcpproto.ListToolsRequest) (*mcpproto.ListToolsResult, error)
 	CallTool(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error)
+	ListResources(ctx context.Context, req mcpproto.ListResourcesRequest) (*mcpproto.ListResourcesResult, error)
+	ReadResource(ctx context.Context, req mcpproto.ReadResourceRequest) (*mcpproto.ReadResourceResult, error)
+	ListPrompts(ctx context.Context, req mcpproto.ListPromptsRequest) (*mcpproto.ListPromptsResult, error)
+	GetPrompt(ctx context.Context, req mcpproto.GetPromptRequest) (*mcpproto.GetPromptResult, error)
+	Subscribe(ctx context.Context, req mcpproto.SubscribeRequest) error
+	Unsubscribe(ctx context.Context, req mcpproto.UnsubscribeRequest) error
+	OnNotification(handler func(notification mcpproto.JSONRPCNotification))
+	GetTransport() transport.Interface
 	Close() error
 }
 
-func newClient(s config.MCPServer) (compatibleClient, func(), error) {
-	var cli compatibleClient
+func newClient(s config.MCPServer, responses *responseRecorder) (compatibleClient, func(), error) {
+	s, err := withResolvedHeaders(context.Background(), s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.Transport != "stdio" {
+		if responses, err = withServerTransport(s, responses); err != nil {
+			return nil, nil, err
+		}
+	}
+	var trans transport.Interface
+	var sse *transport.SSE
 	switch s.Transport {
 	case "stdio":
 		if len(s.Command) == 0 {
 			return nil, nil, fmt.Errorf("stdio server %q has no command configured", s.Name)
 		}
-		c, err := mcpclient.NewStdioMCPClient(s.Command[0], s.Env, s.Command[1:]...)
+		stdio, err := startStdio(s)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("failed to start stdio transport: %w", err)
 		}
-		cli = c
+		trans = stdio
 	case "sse":
-		headers := map[string]string{}
-		for k, v := range s.Headers {
-			headers[k] = v
-		}
-		opts := []transport.ClientOption{}
-		if len(headers) > 0 {
-			opts = append(opts, transport.WithHeaders(headers))
-		}
-		c, err := mcpclient.NewSSEMCPClient(s.URL, opts...)
+		t, err := transport.NewSSE(s.URL, sseOptions(s, responses)...)
 		if err != nil {
 			return nil, nil, err
 		}
-		cli = c
+		trans, sse = t, t
 	default:
-		opts := []transport.StreamableHTTPCOption{}
-		headers := map[string]string{}
-		for k, v := range s.Headers {
-			headers[k] = v
-		}
-		if len(headers) > 0 {
-			opts = append(opts, transport.WithHTTPHeaders(headers))
-		}
-		c, err := mcpclient.NewStreamableHttpClient(s.URL, opts...)
+		t, err := transport.NewStreamableHTTP(s.URL, streamableHTTPOptions(s, responses)...)
 		if err != nil {
 			return nil, nil, err
 		}
-		cli = c
+		trans = t
+	}
+	cli := mcpclient.NewClient(trans, clientOptions(s)...)
+	if sse != nil {
+		return watchSSE(cli, sse), func() { _ = cli.Close() }, nil
 	}
 	return cli, func() { _ = cli.Close() }, nil
 }
 
-func findServer(cfg *config.Config, nameOrAlias string) (config.MCPServer, bool) {
-	for _, s := range cfg.Servers {
-		if s.Name == nameOrAlias || s.Alias == nameOrAlias {
-			return s, true
+// a server with tls files needs its requests to go out on its own
+// transport, which the recorder carries
+func withServerTransport(s config.MCPServer, responses *responseRecorder) (*responseRecorder, error) {
+	base, err := serverTransport(s)
+	if err != nil || base == nil {
+		return responses, err
+	}
+	if responses == nil {
+		responses = &responseRecorder{}
+	}
+	responses.base = base
+	return responses, nil
+}
+
+func clientOptions(s config.MCPServer) []mcpclient.ClientOption {
+	opts := []mcpclient.ClientOption{}
+	if len(s.Roots) > 0 {
+		opts = append(opts, mcpclient.WithRootsHandler(staticRoots(s.Roots)))
+	}
+	return opts
+}
+
+type staticRoots []string
+
+func (r staticRoots) ListRoots(ctx context.Context, request mcpproto.ListRootsRequest) (*mcpproto.ListRootsResult, error) {
+	roots := make([]mcpproto.Root, 0, len(r))
+	for _, path := range r {
+		roots = append(roots, mcpproto.Root{URI: rootURI(path), Name: filepath.Base(path)})
+	}
+	return &mcpproto.ListRootsResult{Roots: roots}, nil
+}
+
+func rootURI(path string) string {
+	if strings.HasPrefix(path, "file://") {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func sseOptions(s config.MCPServer, responses *responseRecorder) []transport.ClientOption {
+	opts := []transport.ClientOption{}
+	if responses != nil {
+		opts = append(opts, transport.WithHTTPClient(responses.httpClient()))
+	}
+	headers := map[string]string{}
+	for k, v := range s.Headers {
+		headers[k] = v
+	}
+	if len(headers) > 0 {
+		opts = append(opts, transport.WithHeaders(headers))
+	}
+	opts = append(opts, transport.WithHeaderFunc(traceHeaders))
+	return opts
+}
+
+func streamableHTTPOptions(s config.MCPServer, responses *responseRecorder) []transport.StreamableHTTPCOption {
+	opts := []transport.StreamableHTTPCOption{}
+	if responses != nil {
+		opts = append(opts, transport.WithHTTPBasicClient(responses.httpClient()))
+	}
+	headers := map[string]string{}
+	for k, v := range s.Headers {
+		headers[k] = v
+	}
+	if o := s.HTTPOptions; o != nil {
+		if o.Accept != "" {
+			headers["Accept"] = o.Accept
+		}
+		if o.Host != "" {
+			opts = append(opts, transport.WithStreamableHTTPHost(o.Host))
+		}
+		if o.ContinuousListening {
+			opts = append(opts, transport.WithContinuousListening())
 		}
 	}
-	return config.MCPServer{}, false
+	if len(headers) > 0 {
+		opts = append(opts, transport.WithHTTPHeaders(headers))
+	}
+	opts = append(opts, transport.WithHTTPHeaderFunc(traceHeaders))
+	return opts
+}
+
+func findServer(cfg *config.Config, nameOrAlias string) (config.MCPServer, bool) {
+	return config.FindServer(cfg, nameOrAlias)
+}
+
+// a configured server that is not disabled, for anything that would talk to it
+func activeServer(cfg *config.Config, nameOrAlias string) (config.MCPServer, error) {
+	s, ok := findServer(cfg, nameOrAlias)
+	if !ok {
+		return s, fmt.Errorf("unknown server %q", nameOrAlias)
+	}
+	if s.Disabled {
+		return s, fmt.Errorf("server %q is disabled; enable it with mcpshim set enabled --server %s --value true", s.Name, s.Name)
+	}
+	return s, nil
 }
@@ -2,11 +2,35 @@ package mcp
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 	"github.com/prbarcelon/mcpshim/internal/config"
 	"github.com/prbarcelon/mcpshim/internal/protocol"
+	"github.com/prbarcelon/mcpshim/internal/store"
 )
 
 func TestParseSchema(t *testing.T) {
@@ -131,7 +155,7 @@ func TestLoginRejectsUnknownServer(t *testing.T) {
 
 func TestNewClientRejectsEmptyCommand(t *testing.T) {
 	s := config.MCPServer{Name: "empty", Transport: "stdio", Command: []string{}}
-	_, _, err := newClient(s)
+	_, _, err := newClient(s, nil)
 	if err == nil {
 		t.Fatal("expected error for empty command, got nil")
 	}
@@ -140,9 +164,32 @@ func TestNewClientRejectsEmptyCommand(t *testing.T) {
 	}
 }
 
+func TestResolveCommandHeaders(t *testing.T) {
+	s := config.MCPServer{
+		Name:                "vault",
+		Headers:             map[string]string{"Authorization": "cmd:echo Bearer $((40 + 2))", "X-Team": "core"},
+		AllowCommandHeaders: true,
+	}
+	resolved, err := withResolvedHeaders(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Headers["Authorization"] != "Bearer 42" || resolved.Headers["X-Team"] != "core" {
+		t.Errorf("unexpected resolved headers: %v", resolved.Headers)
+	}
+	if s.Headers["Authorization"] != "cmd:echo Bearer $((40 + 2))" {
+		t.Errorf("expected configured header to stay a command, got %q", s.Headers["Authorization"])
+	}
+
+	s.Headers = map[string]string{"Authorization": "cmd:exit 3"}
+	if _, err := withResolvedHeaders(context.Background(), s); err == nil {
+		t.Error("expected failing command to return an error")
+	}
+}
+
 func TestNewClientRejectsNilCommand(t *testing.T) {
 	s := config.MCPServer{Name: "nilcmd", Transport: "stdio"}
-	_, _, err := newClient(s)
+	_, _, err := newClient(s, nil)
 	if err == nil {
 		t.Fatal("expected error for nil command, got nil")
 	}
@@ -196,3 +243,1425 @@ func TestToolDetailProtocol(t *testing.T) {
 		t.Error("expected first property to be required")
 	}
 }
+
+func TestCachedToolsRequiresWarmCache(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.MCPServer{
+			{Name: "alpha", Alias: "a", Transport: "stdio", Command: []string{"echo"}},
+			{Name: "beta", Transport: "stdio", Command: []string{"echo"}},
+		},
+	}
+	reg := NewRegistry(cfg, nil)
+	if _, ok := reg.CachedTools(""); ok {
+		t.Fatal("expected cold cache to report not ok")
+	}
+
+	reg.toolCache = map[string][]protocol.ToolInfo{
+		"beta":  {{Server: "beta", Name: "two"}},
+		"alpha": {{Server: "alpha", Name: "one"}, {Server: "alpha", Name: "three"}},
+	}
+	reg.cacheStamp = time.Now()
+
+	all, ok := reg.CachedTools("")
+	if !ok || len(all) != 3 {
+		t.Fatalf("expected 3 cached tools, got %v (ok=%v)", all, ok)
+	}
+	if all[0].Server != "alpha" || all[2].Server != "beta" {
+		t.Errorf("expected tools sorted by server, got %v", all)
+	}
+
+	byAlias, ok := reg.CachedTools("a")
+	if !ok || len(byAlias) != 2 {
+		t.Errorf("expected 2 tools for alias a, got %v (ok=%v)", byAlias, ok)
+	}
+}
+
+func TestToolServersFromCache(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.MCPServer{
+			{Name: "alpha", Transport: "stdio", Command: []string{"echo"}},
+			{Name: "beta", Transport: "stdio", Command: []string{"echo"}},
+			{Name: "gamma", Transport: "stdio", Command: []string{"echo"}},
+		},
+	}
+	reg := NewRegistry(cfg, nil)
+	reg.toolCache = map[string][]protocol.ToolInfo{
+		"beta":  {{Server: "beta", Name: "search"}},
+		"alpha": {{Server: "alpha", Name: "search"}, {Server: "alpha", Name: "fetch"}},
+		"gamma": {{Server: "gamma", Name: "fetch"}},
+	}
+	reg.cacheStamp = time.Now()
+
+	servers, err := reg.ToolServers(context.Background(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(servers, ",") != "alpha,beta" {
+		t.Errorf("expected alpha,beta to expose search, got %v", servers)
+	}
+}
+
+func TestSearchToolsMatchesNameAndDescription(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.MCPServer{
+			{Name: "alpha", Transport: "stdio", Command: []string{"echo"}},
+			{Name: "beta", Transport: "stdio", Command: []string{"echo"}},
+		},
+	}
+	reg := NewRegistry(cfg, nil)
+	if _, _, err := reg.SearchTools("issue", false); err == nil {
+		t.Fatal("expected a cold cache to be reported")
+	}
+	reg.toolCache = map[string][]protocol.ToolInfo{
+		"beta":  {{Server: "beta", Name: "create_issue"}, {Server: "beta", Name: "list_repos"}},
+		"alpha": {{Server: "alpha", Name: "search", Description: "Find ISSUES and pages"}},
+	}
+	reg.cacheStamp = time.Now()
+
+	found, _, err := reg.SearchTools("Issue", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Name != "search" || found[1].Name != "create_issue" {
+		t.Errorf("expected alpha/search and beta/create_issue, got %v", found)
+	}
+	found, _, err = reg.SearchTools("^list_", true)
+	if err != nil || len(found) != 1 || found[0].Name != "list_repos" {
+		t.Errorf("expected regex to match list_repos only, got %v (%v)", found, err)
+	}
+	if _, _, err := reg.SearchTools("(", true); err == nil {
+		t.Error("expected an invalid regex to fail")
+	}
+}
+
+func TestInvalidateServer(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.MCPServer{
+			{Name: "alpha", Alias: "a", Transport: "stdio", Command: []string{"echo"}},
+			{Name: "beta", Transport: "stdio", Command: []string{"echo"}},
+		},
+	}
+	reg := NewRegistry(cfg, nil)
+	reg.toolCache = map[string][]protocol.ToolInfo{
+		"alpha": {{Server: "alpha", Name: "one"}},
+		"beta":  {{Server: "beta", Name: "two"}},
+	}
+	reg.cacheStamp = time.Now()
+
+	name, err := reg.Invalidate("a")
+	if err != nil || name != "alpha" {
+		t.Fatalf("expected alpha to be invalidated, got %q (%v)", name, err)
+	}
+	if _, ok := reg.CachedTools("alpha"); ok {
+		t.Error("expected alpha to miss the cache")
+	}
+	if items, ok := reg.CachedTools("beta"); !ok || len(items) != 1 {
+		t.Errorf("expected beta to stay cached, got %v (ok=%v)", items, ok)
+	}
+	if _, ok := reg.CachedTools(""); ok {
+		t.Error("expected combined listing to miss a partially invalidated cache")
+	}
+	if _, err := reg.Invalidate("gamma"); err == nil {
+		t.Error("expected unknown server to fail")
+	}
+}
+
+func TestMergeDefaultArgs(t *testing.T) {
+	defaults := map[string]interface{}{"limit": 10, "archived": false}
+	args := map[string]interface{}{"limit": 5, "query": "roadmap"}
+
+	merged := mergeDefaultArgs(defaults, args)
+
+	if merged["limit"] != 5 {
+		t.Errorf("expected explicit limit to win, got %v", merged["limit"])
+	}
+	if merged["archived"] != false || merged["query"] != "roadmap" {
+		t.Errorf("expected defaults and args to be combined, got %v", merged)
+	}
+	if len(mergeDefaultArgs(nil, nil)) != 0 {
+		t.Error("expected empty map when no defaults or args")
+	}
+}
+
+func TestCoerceArgs(t *testing.T) {
+	schema := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"zip":     map[string]interface{}{"type": "string"},
+			"limit":   map[string]interface{}{"type": "integer"},
+			"ratio":   map[string]interface{}{"type": "number"},
+			"archive": map[string]interface{}{"type": "boolean"},
+			"filter":  map[string]interface{}{"type": "object"},
+			"cursor":  map[string]interface{}{"type": "string"},
+		},
+	}
+	args := map[string]interface{}{
+		"zip":     float64(1234),
+		"limit":   "10",
+		"ratio":   "0.5",
+		"archive": "true",
+		"filter":  map[string]interface{}{"a": 1},
+		"extra":   "kept",
+		"cursor":  nil,
+	}
+
+	got, err := coerceArgs(schema, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["zip"] != "1234" {
+		t.Errorf("expected zip=\"1234\", got %#v", got["zip"])
+	}
+	if got["limit"] != int64(10) {
+		t.Errorf("expected limit=10, got %#v", got["limit"])
+	}
+	if got["ratio"] != 0.5 {
+		t.Errorf("expected ratio=0.5, got %#v", got["ratio"])
+	}
+	if got["archive"] != true {
+		t.Errorf("expected archive=true, got %#v", got["archive"])
+	}
+	if got["extra"] != "kept" {
+		t.Errorf("expected unknown args to pass through, got %#v", got["extra"])
+	}
+	if value, ok := got["cursor"]; !ok || value != nil {
+		t.Errorf("expected an explicit null to pass through, got %#v (present=%v)", value, ok)
+	}
+}
+
+func TestCoerceArgsMismatch(t *testing.T) {
+	schema := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"limit":   map[string]interface{}{"type": "integer"},
+			"archive": map[string]interface{}{"type": "boolean"},
+		},
+	}
+	cases := []map[string]interface{}{
+		{"limit": "ten"},
+		{"limit": 1.5},
+		{"limit": 1e20},
+		{"limit": -1e19},
+		{"limit": true},
+		{"archive": "maybe"},
+		{"archive": float64(1)},
+	}
+	for _, args := range cases {
+		if _, err := coerceArgs(schema, args); err == nil {
+			t.Errorf("expected coercion error for %v", args)
+		}
+	}
+
+	_, err := coerceArgs(schema, map[string]interface{}{"limit": "ten"})
+	if err == nil || !strings.Contains(err.Error(), `argument "limit"`) {
+		t.Errorf("expected error to name the argument, got %v", err)
+	}
+	_, err = coerceArgs(schema, map[string]interface{}{"limit": 1e20})
+	if err == nil || !strings.Contains(err.Error(), "cannot use 1e+20 (float64) as integer") {
+		t.Errorf("expected an out-of-range integer to be rejected, got %v", err)
+	}
+}
+
+func TestCoerceArgsWithoutSchema(t *testing.T) {
+	args := map[string]interface{}{"limit": "10"}
+	got, err := coerceArgs(nil, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["limit"] != "10" {
+		t.Errorf("expected args unchanged without schema, got %#v", got["limit"])
+	}
+}
+
+func TestCheckLoginRejectsStdioServer(t *testing.T) {
+	cfg := &config.Config{
+		Servers: []config.MCPServer{
+			{Name: "local", Transport: "stdio", Command: []string{"echo"}},
+		},
+	}
+	reg := NewRegistry(cfg, nil)
+	err := reg.CheckLogin(context.Background(), "local")
+	if err == nil || !strings.Contains(err.Error(), "stdio") {
+		t.Errorf("expected stdio error, got %v", err)
+	}
+}
+
+func TestStaticRootsListRoots(t *testing.T) {
+	roots := staticRoots{"/srv/data", "file:///already/uri"}
+	res, err := roots.ListRoots(context.Background(), mcpproto.ListRootsRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(res.Roots))
+	}
+	if res.Roots[0].URI != "file:///srv/data" || res.Roots[0].Name != "data" {
+		t.Errorf("unexpected first root %+v", res.Roots[0])
+	}
+	if res.Roots[1].URI != "file:///already/uri" {
+		t.Errorf("expected file URI to pass through, got %s", res.Roots[1].URI)
+	}
+}
+
+func TestDiffToolSnapshots(t *testing.T) {
+	previous := map[string]string{"search": `{"a":1}`, "delete": `{}`, "list": `{}`}
+	current := map[string]string{"search": `{"a":2}`, "list": `{}`, "create": `{}`}
+
+	diff := diffToolSnapshots(previous, current)
+
+	if len(diff.Added) != 1 || diff.Added[0] != "create" {
+		t.Errorf("expected added=[create], got %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "delete" {
+		t.Errorf("expected removed=[delete], got %v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0] != "search" {
+		t.Errorf("expected changed=[search], got %v", diff.Changed)
+	}
+
+	if first := diffToolSnapshots(nil, current); len(first.Added)+len(first.Removed)+len(first.Changed) != 0 {
+		t.Errorf("expected no diff without a previous snapshot, got %+v", first)
+	}
+}
+
+func TestLineLimitReader(t *testing.T) {
+	ok := &lineLimitReader{r: strings.NewReader("abcd\nefgh\n"), max: 4, server: "local"}
+	data, err := io.ReadAll(ok)
+	if err != nil || string(data) != "abcd\nefgh\n" {
+		t.Fatalf("expected lines within the limit to pass, got %q, %v", data, err)
+	}
+
+	long := &lineLimitReader{r: strings.NewReader("abc\nabcdef\n"), max: 4, server: "local"}
+	data, err = io.ReadAll(long)
+	if err == nil || !strings.Contains(err.Error(), "stdio_max_line_bytes") {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+	if string(data) != "abc\nabcd" {
+		t.Fatalf("expected output up to the limit, got %q", data)
+	}
+}
+
+func TestOAuthCallbackRoutesByState(t *testing.T) {
+	first, err := startOAuthCallbackServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := startOAuthCallbackServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.close()
+	defer second.close()
+	if first != second {
+		t.Fatal("expected concurrent logins to share one callback listener")
+	}
+
+	a, doneA := first.expect("state-a")
+	defer doneA()
+	b, doneB := second.expect("state-b")
+	defer doneB()
+
+	for _, query := range []string{"state=state-b&code=two", "state=state-a&code=one"} {
+		resp, err := http.Get(first.redirectURI + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	if got := (<-a)["code"]; got != "one" {
+		t.Errorf("expected state-a to get code one, got %q", got)
+	}
+	if got := (<-b)["code"]; got != "two" {
+		t.Errorf("expected state-b to get code two, got %q", got)
+	}
+
+	resp, err := http.Get(first.redirectURI + "?state=unknown&code=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected unknown state to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestOfferedProtocolVersion(t *testing.T) {
+	mismatch := errors.New(`request failed: unsupported protocol version "2025-11-25"; supported: ["2024-11-05"]`)
+	if got, ok := offeredProtocolVersion(mismatch, "2025-11-25"); !ok || got != "2024-11-05" {
+		t.Errorf("expected fallback to 2024-11-05, got %q (ok=%v)", got, ok)
+	}
+	if _, ok := offeredProtocolVersion(errors.New("connection refused 2024-11-05"), "2025-11-25"); ok {
+		t.Error("expected unrelated errors not to trigger a retry")
+	}
+	if _, ok := offeredProtocolVersion(errors.New(`unsupported protocol version "2024-11-05"`), "2024-11-05"); ok {
+		t.Error("expected no retry when the error only names the requested version")
+	}
+}
+
+func TestMetaMap(t *testing.T) {
+	if got := metaMap(nil); got != nil {
+		t.Errorf("expected nil for missing _meta, got %v", got)
+	}
+	var tool mcpproto.Tool
+	raw := `{"name":"search","inputSchema":{"type":"object"},"_meta":{"vendor/route":"eu-1","progressToken":"p1"}}`
+	if err := json.Unmarshal([]byte(raw), &tool); err != nil {
+		t.Fatalf("unmarshal tool: %v", err)
+	}
+	got := metaMap(tool.Meta)
+	if got["vendor/route"] != "eu-1" || got["progressToken"] != "p1" {
+		t.Errorf("unexpected meta: %v", got)
+	}
+
+	// result-level _meta must survive the round trip to the cli
+	result := mcpproto.NewToolResultText("ok")
+	result.Meta = mcpproto.NewMetaFromMap(map[string]any{"vendor/version": "2"})
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("marshal result: %v", err)
+	}
+	if !strings.Contains(string(data), `"_meta":{"vendor/version":"2"}`) {
+		t.Errorf("expected _meta in call result, got %s", data)
+	}
+}
+
+// an sse upstream whose first stream is cut, as an idle proxy would, when a
+// request for method arrives; calls counts how often the echo tool ran
+func droppingSSEServer(t *testing.T, method string, calls *int) (*httptest.Server, func() int) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	sseServer := server.NewSSEServer(mcpServer)
+
+	var mu sync.Mutex
+	var streams []context.CancelFunc
+	dropped := false
+	dropStream := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !dropped {
+			dropped = true
+			streams[len(streams)-1]()
+		}
+	}
+	mcpServer.AddTool(mcpproto.NewTool("echo"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		mu.Lock()
+		*calls++
+		mu.Unlock()
+		if method == "tools/call" {
+			// the call was accepted and ran; only its result is lost
+			dropStream()
+			time.Sleep(50 * time.Millisecond)
+		}
+		return mcpproto.NewToolResultText("ok"), nil
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			ctx, cancel := context.WithCancel(r.Context())
+			mu.Lock()
+			streams = append(streams, cancel)
+			mu.Unlock()
+			sseServer.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		mu.Lock()
+		drop := !dropped && method != "tools/call" && strings.Contains(string(body), `"`+method+`"`)
+		mu.Unlock()
+		if drop {
+			dropStream()
+			time.Sleep(50 * time.Millisecond)
+			http.Error(w, "upstream went away", http.StatusBadGateway)
+			return
+		}
+		sseServer.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(streams)
+	}
+}
+
+func TestSSEReconnectsAfterDroppedStream(t *testing.T) {
+	backoff := sseReconnectBackoff
+	sseReconnectBackoff = []time.Duration{10 * time.Millisecond}
+	defer func() { sseReconnectBackoff = backoff }()
+
+	calls := 0
+	ts, streams := droppingSSEServer(t, "tools/list", &calls)
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "flaky", Alias: "flaky", Transport: "sse", URL: ts.URL + "/sse"}}}
+	r := NewRegistry(cfg, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tools, _, err := r.ListTools(ctx, "flaky")
+	if err != nil {
+		t.Fatalf("expected the listing to recover, got %v", err)
+	}
+	if len(tools) != 1 || tools[0].Name != "echo" {
+		t.Fatalf("unexpected tools: %+v", tools)
+	}
+	if n := streams(); n != 2 {
+		t.Fatalf("expected a second stream after the drop, got %d", n)
+	}
+}
+
+func TestSSEDoesNotReplayCallAfterDroppedStream(t *testing.T) {
+	backoff := sseReconnectBackoff
+	sseReconnectBackoff = []time.Duration{10 * time.Millisecond}
+	defer func() { sseReconnectBackoff = backoff }()
+
+	calls := 0
+	ts, streams := droppingSSEServer(t, "tools/call", &calls)
+	r := NewRegistry(&config.Config{}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, _, err := r.CallServer(ctx, config.MCPServer{Name: "flaky", Transport: "sse", URL: ts.URL + "/sse"}, "echo", nil)
+	if !errors.Is(err, errSSEConnectionLost) || !errors.Is(err, errNotReplayed) {
+		t.Fatalf("expected the lost connection to be returned, got %v", err)
+	}
+	if calls != 1 || streams() != 1 {
+		t.Fatalf("expected the tool to run once on one stream, got %d calls on %d streams", calls, streams())
+	}
+}
+
+func TestListToolsFallsBackToPersistedCache(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer db.Close()
+
+	// nothing listens here, so every live fetch fails fast
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "notion", Alias: "notion", Transport: "http", URL: down.URL + "/mcp"}}}
+	r := NewRegistry(cfg, db)
+	ctx := context.Background()
+
+	if _, _, err := r.ListTools(ctx, "notion"); err == nil {
+		t.Fatal("expected an error without a cached list")
+	}
+
+	r.saveToolCache(ctx, "notion", []mcpproto.Tool{mcpproto.NewTool("search", mcpproto.WithString("query", mcpproto.Required()))})
+	items, cachedAt, err := r.ListTools(ctx, "notion")
+	if err != nil {
+		t.Fatalf("expected the cached list, got %v", err)
+	}
+	if cachedAt.IsZero() || len(items) != 1 || items[0].Name != "search" {
+		t.Fatalf("unexpected cached tools: %+v (cached at %v)", items, cachedAt)
+	}
+	detail, _, err := r.InspectTool(ctx, "notion", "search")
+	if err != nil || len(detail.Properties) != 1 || !detail.Properties[0].Required {
+		t.Fatalf("unexpected cached detail: %+v, %v", detail, err)
+	}
+}
+
+func TestCircuitOpensAfterRepeatedFailures(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "flaky", Transport: "http", URL: down.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	ctx := context.Background()
+
+	for i := 0; i < breakerThreshold; i++ {
+		if _, _, err := r.ListTools(ctx, "flaky"); err == nil || strings.Contains(err.Error(), "circuit open") {
+			t.Fatalf("attempt %d: expected a live failure, got %v", i+1, err)
+		}
+	}
+	if _, _, err := r.ListTools(ctx, "flaky"); err == nil || !strings.Contains(err.Error(), "circuit open after 3 consecutive failures") {
+		t.Fatalf("expected the open circuit to fail fast, got %v", err)
+	}
+	if info := r.Servers()[0]; info.Circuit != breakerOpen || info.ConsecutiveFailures != breakerThreshold {
+		t.Fatalf("unexpected breaker state in servers: %+v", info)
+	}
+	if open := r.OpenCircuits(); len(open) != 1 || open[0] != "flaky" {
+		t.Fatalf("unexpected open circuits: %v", open)
+	}
+
+	// past the cooldown one probe goes through, and its failure reopens the circuit
+	b := r.breakerFor("flaky")
+	b.openedAt = time.Now().Add(-breakerCooldown)
+	if _, _, err := r.ListTools(ctx, "flaky"); err == nil || strings.Contains(err.Error(), "circuit open") {
+		t.Fatalf("expected a live probe, got %v", err)
+	}
+	if state, _ := b.snapshot(time.Now()); state != breakerOpen {
+		t.Fatalf("expected a failed probe to reopen the circuit, got %s", state)
+	}
+	b.record(nil, time.Now())
+	if open := r.OpenCircuits(); len(open) != 0 {
+		t.Fatalf("expected a success to close the circuit, got %v", open)
+	}
+}
+
+type funcMiddleware struct {
+	name   string
+	before func(call *MiddlewareCall) (interface{}, error)
+	after  func(result interface{}) interface{}
+}
+
+func (m funcMiddleware) Name() string { return m.name }
+
+func (m funcMiddleware) Before(ctx context.Context, call *MiddlewareCall) (interface{}, error) {
+	if m.before == nil {
+		return nil, nil
+	}
+	return m.before(call)
+}
+
+func (m funcMiddleware) After(ctx context.Context, call MiddlewareCall, result interface{}, callErr error) (interface{}, error) {
+	if m.after == nil {
+		return result, nil
+	}
+	return m.after(result), nil
+}
+
+func (m funcMiddleware) Close() error { return nil }
+
+func TestCallMiddlewareChain(t *testing.T) {
+	r := NewRegistry(&config.Config{}, nil)
+	// cat echoes each message back, which reads as "keep args and result"
+	echo := newProcessMiddleware(config.Middleware{Name: "echo", Command: []string{"cat"}})
+	r.SetMiddleware([]Middleware{
+		funcMiddleware{name: "scrub", before: func(call *MiddlewareCall) (interface{}, error) {
+			call.Args["email"] = "<redacted>"
+			return nil, nil
+		}, after: func(result interface{}) interface{} {
+			return fmt.Sprintf("wrapped(%v)", result)
+		}},
+		echo,
+		funcMiddleware{name: "stub", before: func(call *MiddlewareCall) (interface{}, error) {
+			return call.Args["email"], nil
+		}},
+	})
+	defer r.Close()
+
+	// the stub answers before anything is sent, so the server is never dialed
+	target := config.MCPServer{Name: "crm", Transport: "http", URL: "http://127.0.0.1:1/mcp"}
+	result, _, err := r.CallServer(context.Background(), target, "lookup", map[string]interface{}{"email": "a@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != "wrapped(<redacted>)" {
+		t.Fatalf("unexpected result: %v", result)
+	}
+
+	r.SetMiddleware([]Middleware{funcMiddleware{name: "deny", before: func(call *MiddlewareCall) (interface{}, error) {
+		return nil, errors.New("tool is blocked")
+	}}})
+	if _, _, err := r.CallServer(context.Background(), target, "lookup", nil); err == nil || !strings.Contains(err.Error(), "middleware deny: tool is blocked") {
+		t.Fatalf("expected the middleware to reject the call, got %v", err)
+	}
+}
+
+func TestServerOAuthConfigAddsRegistrationMetadata(t *testing.T) {
+	var bodies []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	s := config.MCPServer{Name: "notion", OAuth: &config.OAuthOptions{Scopes: []string{"read", "write"}, SoftwareID: "com.example.mcpshim"}}
+	cfg, err := serverOAuthConfig(context.Background(), s, nil, defaultOAuthRedirectURI)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(cfg.Scopes, " ") != "read write" {
+		t.Fatalf("unexpected scopes: %v", cfg.Scopes)
+	}
+	for _, payload := range []string{
+		`{"client_name":"mcpshim","redirect_uris":["http://127.0.0.1/cb"]}`,
+		`{"jsonrpc":"2.0","method":"ping"}`,
+	} {
+		resp, err := cfg.HTTPClient.Post(ts.URL, "application/json", strings.NewReader(payload))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if bodies[0]["software_id"] != "com.example.mcpshim" {
+		t.Errorf("expected software_id in the registration, got %v", bodies[0])
+	}
+	if _, ok := bodies[1]["software_id"]; ok {
+		t.Errorf("other requests must be left alone, got %v", bodies[1])
+	}
+}
+
+func TestServerOAuthConfigResolvesClientSecret(t *testing.T) {
+	t.Setenv("MCPSHIM_TEST_SECRET", "s3cret")
+	s := config.MCPServer{Name: "corp", OAuth: &config.OAuthOptions{ClientID: "abc", ClientSecret: "${MCPSHIM_TEST_SECRET}"}}
+	cfg, err := serverOAuthConfig(context.Background(), s, nil, defaultOAuthRedirectURI)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ClientID != "abc" || cfg.ClientSecret != "s3cret" {
+		t.Fatalf("unexpected client credentials: %q %q", cfg.ClientID, cfg.ClientSecret)
+	}
+	if s.OAuth.ClientSecret != "${MCPSHIM_TEST_SECRET}" {
+		t.Fatal("the config must keep the reference, not the secret")
+	}
+
+	s.OAuth.ClientSecret = "${MCPSHIM_TEST_UNSET}"
+	if _, err := serverOAuthConfig(context.Background(), s, nil, defaultOAuthRedirectURI); err == nil {
+		t.Fatal("expected an error for an empty secret reference")
+	}
+}
+
+func TestRunOperationReportsUpstreamHTTPStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = io.WriteString(w, `{"error":"token expired"}`)
+	}))
+	defer srv.Close()
+
+	s := config.MCPServer{Name: "gateway", URL: srv.URL, Transport: "http", Headers: map[string]string{"Authorization": "Bearer stale"}}
+	_, err := runOperation(context.Background(), s, noopOperation)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected an upstream 401, got %v", err)
+	}
+	if !shouldTryOAuthFallback(config.MCPServer{Name: "gateway", Transport: "http"}, err) {
+		t.Error("expected the annotated error to still read as unauthorized")
+	}
+	disabled := false
+	if shouldTryOAuthFallback(config.MCPServer{Name: "gateway", Transport: "http", OAuthFallback: &disabled}, err) {
+		t.Error("expected oauth_fallback: false to surface the 401")
+	}
+	for _, want := range []string{"401 Unauthorized", `www-authenticate: Bearer error="invalid_token"`, `body: {"error":"token expired"}`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %q", want, err.Error())
+		}
+	}
+}
+
+func TestCallRecordsExchange(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("echo", mcpproto.WithString("text")), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText(req.GetString("text", "")), nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "verbose", Alias: "verbose", Transport: "http", URL: ts.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	ctx, exchange := WithExchange(context.Background())
+	if _, _, err := r.Call(ctx, "verbose", "echo", map[string]interface{}{"text": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	steps := exchange.Steps()
+	if len(steps) != 2 || steps[0].Method != "initialize" || steps[1].Method != "tools/call" {
+		t.Fatalf("expected initialize then tools/call, got %+v", steps)
+	}
+	if result, ok := steps[1].Response.(*mcpproto.CallToolResult); !ok || len(result.Content) != 1 {
+		t.Errorf("expected the raw tool result, got %#v", steps[1].Response)
+	}
+	if stats := r.ConnStats(); len(stats) != 1 || stats[0].Opened != 1 || stats[0].Active != 0 || stats[0].Failed != 0 {
+		t.Errorf("expected one finished connection, got %+v", stats)
+	}
+	if stats := NewRegistry(cfg, nil).ConnStats(); stats[0].Opened != 0 {
+		t.Errorf("expected another registry to keep its own counters, got %+v", stats)
+	}
+}
+
+func TestListAndReadResources(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithResourceCapabilities(false, false))
+	mcpServer.AddResource(mcpproto.NewResource("file:///notes/b.md", "b", mcpproto.WithMIMEType("text/markdown")), func(ctx context.Context, req mcpproto.ReadResourceRequest) ([]mcpproto.ResourceContents, error) {
+		return []mcpproto.ResourceContents{mcpproto.TextResourceContents{URI: req.Params.URI, MIMEType: "text/markdown", Text: "# b"}}, nil
+	})
+	mcpServer.AddResource(mcpproto.NewResource("file:///notes/a.md", "a"), func(ctx context.Context, req mcpproto.ReadResourceRequest) ([]mcpproto.ResourceContents, error) {
+		return nil, nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "notes", Alias: "notes", Transport: "http", URL: ts.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	items, err := r.ListResources(context.Background(), "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].URI != "file:///notes/a.md" || items[1].MIMEType != "text/markdown" || items[1].Server != "notes" {
+		t.Fatalf("unexpected resources: %+v", items)
+	}
+	result, err := r.ReadResource(context.Background(), "notes", "file:///notes/b.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Contents) != 1 {
+		t.Fatalf("unexpected contents: %+v", result.Contents)
+	}
+	if text, ok := result.Contents[0].(mcpproto.TextResourceContents); !ok || text.Text != "# b" {
+		t.Errorf("unexpected content: %#v", result.Contents[0])
+	}
+}
+
+func TestListAndGetPrompts(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithPromptCapabilities(false))
+	mcpServer.AddPrompt(mcpproto.NewPrompt("greeting", mcpproto.WithPromptDescription("say hello"), mcpproto.WithArgument("name", mcpproto.RequiredArgument())), func(ctx context.Context, req mcpproto.GetPromptRequest) (*mcpproto.GetPromptResult, error) {
+		return mcpproto.NewGetPromptResult("a greeting", []mcpproto.PromptMessage{
+			mcpproto.NewPromptMessage(mcpproto.RoleUser, mcpproto.NewTextContent("Say hello to "+req.Params.Arguments["name"])),
+		}), nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "prompts", Alias: "prompts", Transport: "http", URL: ts.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	items, err := r.ListPrompts(context.Background(), "prompts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != "greeting" || len(items[0].Arguments) != 1 || !items[0].Arguments[0].Required {
+		t.Fatalf("unexpected prompts: %+v", items)
+	}
+	result, err := r.GetPrompt(context.Background(), "prompts", "greeting", map[string]string{"name": "Bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Description != "a greeting" || len(result.Messages) != 1 || result.Messages[0].Role != "user" {
+		t.Fatalf("unexpected prompt result: %+v", result)
+	}
+	if text, ok := result.Messages[0].Content.(mcpproto.TextContent); !ok || text.Text != "Say hello to Bob" {
+		t.Errorf("unexpected content: %#v", result.Messages[0].Content)
+	}
+}
+
+func TestRefreshRejectedToken(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer db.Close()
+
+	var authServer *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 authServer.URL,
+			"authorization_endpoint": authServer.URL + "/authorize",
+			"token_endpoint":         authServer.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh-1" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"access-2","token_type":"Bearer","refresh_token":"refresh-2","expires_in":3600}`)
+	})
+	authServer = httptest.NewServer(mux)
+	defer authServer.Close()
+
+	ctx := context.Background()
+	s := config.MCPServer{Name: "notion", Transport: "http", URL: authServer.URL + "/mcp"}
+	// no expires_in on the stored token, so only a 401 reveals it is stale
+	if err := db.SaveToken(ctx, s.Name, &mcpclient.Token{AccessToken: "access-1", TokenType: "Bearer", RefreshToken: "refresh-1"}); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	handler := transport.NewOAuthHandler(transport.OAuthConfig{
+		ClientID:              "mcpshim",
+		TokenStore:            newSQLiteTokenStore(db, s.Name),
+		AuthServerMetadataURL: authServer.URL + "/.well-known/oauth-authorization-server",
+	})
+	authErr := fmt.Errorf("initialize: %w", &transport.OAuthAuthorizationRequiredError{Handler: handler})
+
+	if !refreshRejectedToken(ctx, s, db, authErr) {
+		t.Fatal("expected the stored refresh token to be used")
+	}
+	token, err := db.GetToken(ctx, s.Name)
+	if err != nil || token == nil || token.AccessToken != "access-2" || token.RefreshToken != "refresh-2" {
+		t.Fatalf("refreshed token was not stored: %+v, %v", token, err)
+	}
+	if refreshRejectedToken(ctx, s, db, errors.New("connection refused")) {
+		t.Error("expected no refresh without an authorization error")
+	}
+}
+
+func TestConcurrentCallsRefreshExpiredTokenOnce(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer db.Close()
+
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("echo"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText("ok"), nil
+	})
+	mcpHandler := server.NewStreamableHTTPServer(mcpServer)
+
+	var refreshes sync.Map
+	var refreshCount int
+	var countMu sync.Mutex
+	var authServer *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 authServer.URL,
+			"authorization_endpoint": authServer.URL + "/authorize",
+			"token_endpoint":         authServer.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		countMu.Lock()
+		refreshCount++
+		countMu.Unlock()
+		refreshes.Store(r.FormValue("refresh_token"), true)
+		// slow enough that every caller is waiting by the time it answers
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"access-2","token_type":"Bearer","refresh_token":"refresh-2","expires_in":3600}`)
+	})
+	mux.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mcpHandler.ServeHTTP(w, r)
+	})
+	authServer = httptest.NewServer(mux)
+	defer authServer.Close()
+
+	ctx := context.Background()
+	expired := &mcpclient.Token{AccessToken: "access-1", TokenType: "Bearer", RefreshToken: "refresh-1", ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := db.SaveToken(ctx, "notion", expired); err != nil {
+		t.Fatalf("save token: %v", err)
+	}
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "notion", Transport: "http", URL: authServer.URL + "/mcp"}}}
+	r := NewRegistry(cfg, db)
+
+	const callers = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := r.Call(ctx, "notion", "echo", nil)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("call failed: %v", err)
+		}
+	}
+	countMu.Lock()
+	defer countMu.Unlock()
+	if refreshCount != 1 {
+		t.Errorf("expected the token endpoint to be called once, got %d", refreshCount)
+	}
+	if _, ok := refreshes.Load("refresh-1"); !ok {
+		t.Error("expected the stored refresh token to be spent")
+	}
+}
+
+func TestEachServerBoundsConcurrency(t *testing.T) {
+	servers := make([]config.MCPServer, 10)
+	for i := range servers {
+		servers[i].Name = fmt.Sprintf("s%d", i)
+	}
+	var mu sync.Mutex
+	running, peak := 0, 0
+	seen := map[string]bool{}
+	eachServer(servers, 3, func(s config.MCPServer) {
+		mu.Lock()
+		running++
+		peak = max(peak, running)
+		seen[s.Name] = true
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if len(seen) != len(servers) {
+		t.Fatalf("expected every server to be visited, got %d", len(seen))
+	}
+	if peak != 3 {
+		t.Fatalf("expected 3 fetches in flight at most, got %d", peak)
+	}
+}
+
+func TestCallReportsProgress(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("export"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		if req.Params.Meta == nil || req.Params.Meta.ProgressToken == nil {
+			return mcpproto.NewToolResultError("no progress token"), nil
+		}
+		for i := 1; i <= 2; i++ {
+			_ = server.ServerFromContext(ctx).SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+				"progressToken": req.Params.Meta.ProgressToken,
+				"progress":      i,
+				"total":         2,
+				"message":       fmt.Sprintf("step %d", i),
+			})
+		}
+		return mcpproto.NewToolResultText("done"), nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "warehouse", Alias: "warehouse", Transport: "http", URL: ts.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	var mu sync.Mutex
+	var updates []protocol.Progress
+	ctx := WithProgress(context.Background(), func(p protocol.Progress) {
+		mu.Lock()
+		updates = append(updates, p)
+		mu.Unlock()
+	})
+	result, _, err := r.Call(ctx, "warehouse", "export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, ok := result.(*mcpproto.CallToolResult); !ok || res.IsError {
+		t.Fatalf("unexpected result: %#v", result)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(updates) != 2 || updates[1].Progress != 2 || updates[1].Total != 2 || updates[1].Message != "step 2" || updates[0].Server != "warehouse" {
+		t.Errorf("unexpected progress updates: %+v", updates)
+	}
+}
+
+func TestDisabledServerIsSkipped(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("search"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText("ok"), nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{
+		{Name: "live", Alias: "live", Transport: "http", URL: ts.URL + "/mcp"},
+		{Name: "parked", Alias: "parked", Transport: "http", URL: ts.URL + "/mcp", Disabled: true},
+	}}
+	r := NewRegistry(cfg, nil)
+	tools, _, err := r.ListTools(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != 1 || tools[0].Server != "live" {
+		t.Errorf("expected only the live server's tools, got %+v", tools)
+	}
+	if _, _, err := r.Call(context.Background(), "parked", "search", nil); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("expected disabled error from call, got %v", err)
+	}
+	if _, _, err := r.InspectTool(context.Background(), "parked", "search"); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("expected disabled error from inspect, got %v", err)
+	}
+	servers := r.Servers()
+	if len(servers) != 2 || servers[0].Disabled || !servers[1].Disabled {
+		t.Errorf("expected servers to mark only parked as disabled, got %+v", servers)
+	}
+}
+
+func TestCallReusesCachedResult(t *testing.T) {
+	var calls sync.Map
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	for _, name := range []string{"list_projects", "create_project"} {
+		mcpServer.AddTool(mcpproto.NewTool(name), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+			count, _ := calls.LoadOrStore(req.Params.Name, new(int))
+			*count.(*int)++
+			return mcpproto.NewToolResultText(fmt.Sprintf("call %d", *count.(*int))), nil
+		})
+	}
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{
+		Name: "projects", Alias: "projects", Transport: "http", URL: ts.URL + "/mcp",
+		Cacheable: map[string]time.Duration{"list_projects": 0}, CacheTTL: time.Minute,
+	}}}
+	r := NewRegistry(cfg, nil)
+	text := func(ctx context.Context, tool string, args map[string]interface{}) (string, bool) {
+		t.Helper()
+		result, timing, err := r.Call(ctx, "projects", tool, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.(*mcpproto.CallToolResult).Content[0].(mcpproto.TextContent).Text, timing.Cached
+	}
+	ctx := context.Background()
+	steps := []struct {
+		ctx    context.Context
+		tool   string
+		args   map[string]interface{}
+		want   string
+		cached bool
+	}{
+		{ctx, "list_projects", nil, "call 1", false},
+		{ctx, "list_projects", nil, "call 1", true},
+		{ctx, "list_projects", map[string]interface{}{"team": "core"}, "call 2", false},
+		{WithoutCache(ctx), "list_projects", nil, "call 3", false},
+		{ctx, "list_projects", nil, "call 3", true},
+		{ctx, "create_project", nil, "call 1", false},
+		{ctx, "create_project", nil, "call 2", false},
+	}
+	for i, step := range steps {
+		if got, cached := text(step.ctx, step.tool, step.args); got != step.want || cached != step.cached {
+			t.Errorf("step %d: got %q cached=%v, want %q cached=%v", i, got, cached, step.want, step.cached)
+		}
+	}
+	r.UpdateConfig(cfg)
+	if got, cached := text(ctx, "list_projects", nil); got != "call 4" || cached {
+		t.Errorf("expected UpdateConfig to drop cached results, got %q cached=%v", got, cached)
+	}
+}
+
+func TestInspectToolIncludesOutputSchema(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	handler := func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText("ok"), nil
+	}
+	mcpServer.AddTool(mcpproto.NewTool("get_weather",
+		mcpproto.WithString("city", mcpproto.Required()),
+		mcpproto.WithRawOutputSchema(json.RawMessage(`{"type":"object","properties":{"temp":{"type":"number","description":"degrees celsius"},"conditions":{"type":"string"}},"required":["temp"]}`)),
+	), handler)
+	mcpServer.AddTool(mcpproto.NewTool("ping"), handler)
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "weather", Alias: "weather", Transport: "http", URL: ts.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	detail, _, err := r.InspectTool(context.Background(), "weather", "get_weather")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []protocol.PropertyDetail{
+		{Name: "conditions", Type: "string", Enum: []string{}},
+		{Name: "temp", Type: "number", Enum: []string{}, Description: "degrees celsius", Required: true},
+	}
+	if !reflect.DeepEqual(detail.OutputProperties, want) {
+		t.Errorf("unexpected output properties: %+v", detail.OutputProperties)
+	}
+	detail, _, err = r.InspectTool(context.Background(), "weather", "ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.OutputProperties) != 0 {
+		t.Errorf("expected no output properties without an outputSchema, got %+v", detail.OutputProperties)
+	}
+}
+
+func TestParseSchemaDetailNested(t *testing.T) {
+	var schema map[string]interface{}
+	raw := `{"properties":{
+		"filter":{"type":"object","required":["status"],"properties":{
+			"status":{"type":"string","enum":["open","closed"]},
+			"owner":{"type":"object","properties":{"team":{"type":"object","properties":{"name":{"type":"string"}}}}}
+		}},
+		"sort":{"type":"array","items":{"type":"object","properties":{"field":{"type":"string"}}}},
+		"tags":{"type":["array","null"],"items":{"type":"string"}},
+		"pair":{"type":"array","items":[{"type":"string"},{"type":"number"}]}
+	}}`
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		t.Fatal(err)
+	}
+	details := parseSchemaDetail(schema, nil)
+	byName := map[string]protocol.PropertyDetail{}
+	for _, d := range details {
+		byName[d.Name] = d
+	}
+	if len(byName) != 4 {
+		t.Fatalf("expected 4 properties, got %+v", details)
+	}
+
+	filter := byName["filter"].Properties
+	if len(filter) != 2 || filter[0].Name != "owner" || filter[1].Name != "status" || !filter[1].Required || len(filter[1].Enum) != 2 {
+		t.Fatalf("unexpected filter fields: %+v", filter)
+	}
+	// two levels below the argument, deeper fields are left out
+	team := filter[0].Properties
+	if len(team) != 1 || team[0].Name != "team" || team[0].Properties != nil {
+		t.Errorf("expected owner.team without its fields, got %+v", team)
+	}
+
+	if sort := byName["sort"]; sort.Items != "object" || len(sort.Properties) != 1 || sort.Properties[0].Name != "field" {
+		t.Errorf("expected sort to describe its element fields, got %+v", sort)
+	}
+	if tags := byName["tags"]; tags.Type != "array|null" || tags.Items != "string" {
+		t.Errorf("unexpected tags detail: %+v", tags)
+	}
+	if pair := byName["pair"]; pair.Type != "array" || pair.Items != "" {
+		t.Errorf("expected a tuple to keep only its type, got %+v", pair)
+	}
+}
+
+func TestClientCertificateReachesMutualTLSServer(t *testing.T) {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "mcpshim"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM := func(name, kind string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	certFile := writePEM("client.pem", "CERTIFICATE", clientDER)
+	keyFile := writePEM("client-key.pem", "EC PRIVATE KEY", keyDER)
+
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("whoami"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText("ok"), nil
+	})
+	ts := httptest.NewUnstartedServer(server.NewStreamableHTTPServer(mcpServer))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+	// the test server's own certificate stands in for the private ca
+	serverCA := writePEM("server-ca.pem", "CERTIFICATE", ts.Certificate().Raw)
+
+	withCert := config.MCPServer{Name: "secure", Alias: "secure", Transport: "http", URL: ts.URL + "/mcp", TLSClientCert: certFile, TLSClientKey: keyFile, TLSCACert: serverCA}
+	withoutCert := withCert
+	withoutCert.Name, withoutCert.Alias, withoutCert.TLSClientCert, withoutCert.TLSClientKey = "anonymous", "anonymous", "", ""
+	r := NewRegistry(&config.Config{Servers: []config.MCPServer{withCert, withoutCert}}, nil)
+
+	if _, _, err := r.Call(context.Background(), "secure", "whoami", nil); err != nil {
+		t.Fatalf("expected the client certificate to be accepted, got %v", err)
+	}
+	if _, _, err := r.Call(context.Background(), "anonymous", "whoami", nil); err == nil {
+		t.Fatal("expected the handshake to fail without a client certificate")
+	}
+}
+
+func TestInitializeSendsConfiguredClientInfo(t *testing.T) {
+	var mu sync.Mutex
+	var seen []mcpproto.Implementation
+	hooks := &server.Hooks{}
+	hooks.AddBeforeInitialize(func(ctx context.Context, id any, req *mcpproto.InitializeRequest) {
+		mu.Lock()
+		seen = append(seen, req.Params.ClientInfo)
+		mu.Unlock()
+	})
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false), server.WithHooks(hooks))
+	mcpServer.AddTool(mcpproto.NewTool("ping"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText("pong"), nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{ClientName: "acme-agent"},
+		Servers: []config.MCPServer{
+			{Name: "plain", Alias: "plain", Transport: "http", URL: ts.URL + "/mcp"},
+			{Name: "pinned", Alias: "pinned", Transport: "http", URL: ts.URL + "/mcp", ClientVersion: "2.1.0"},
+		},
+	}
+	for i := range cfg.Servers {
+		cfg.Server.ApplyDefaults(&cfg.Servers[i])
+	}
+	r := NewRegistry(cfg, nil)
+	for _, name := range []string{"plain", "pinned"} {
+		if _, _, err := r.Call(context.Background(), name, "ping", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []mcpproto.Implementation{{Name: "acme-agent", Version: "dev"}, {Name: "acme-agent", Version: "2.1.0"}}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("unexpected client info: %+v", seen)
+	}
+}
+
+func TestHealthCheckReportsEachServer(t *testing.T) {
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{
+		{Name: "up", Alias: "up", Transport: "http", URL: ts.URL + "/mcp"},
+		{Name: "down", Alias: "down", Transport: "http", URL: down.URL + "/mcp"},
+		{Name: "parked", Alias: "parked", Transport: "http", URL: ts.URL + "/mcp", Disabled: true},
+	}}
+	r := NewRegistry(cfg, nil)
+	results, err := r.HealthCheck(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Server != "down" || results[1].Server != "up" {
+		t.Fatalf("expected down and up, got %+v", results)
+	}
+	if results[0].OK || results[0].Error == "" || !results[1].OK || results[1].Error != "" {
+		t.Errorf("unexpected health: %+v", results)
+	}
+
+	results, err = r.HealthCheck(context.Background(), "up")
+	if err != nil || len(results) != 1 || !results[0].OK {
+		t.Errorf("expected up to be healthy on its own, got %+v, %v", results, err)
+	}
+	if _, err := r.HealthCheck(context.Background(), "parked"); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("expected a disabled error, got %v", err)
+	}
+}
+
+func TestCallServerAppliesBaseArgsAndCallHeaders(t *testing.T) {
+	var got map[string]interface{}
+	mcpServer := server.NewMCPServer("test", "1.0.0", server.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("search"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		got = req.GetArguments()
+		return mcpproto.NewToolResultText("ok"), nil
+	})
+	mcpHandler := server.NewStreamableHTTPServer(mcpServer)
+
+	var mu sync.Mutex
+	tenants := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		var msg struct {
+			Method string `json:"method"`
+		}
+		_ = json.Unmarshal(body, &msg)
+		mu.Lock()
+		if msg.Method != "" {
+			tenants[msg.Method] = r.Header.Get("X-Tenant")
+		}
+		mu.Unlock()
+		mcpHandler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{
+		Name:        "notion",
+		Alias:       "notion",
+		Transport:   "http",
+		URL:         ts.URL + "/mcp",
+		BaseArgs:    map[string]interface{}{"workspace": "acme", "limit": 5},
+		CallHeaders: map[string]string{"X-Tenant": "acme"},
+	}}}
+	r := NewRegistry(cfg, nil)
+	ctx := context.Background()
+	if _, _, err := r.ListTools(ctx, "notion"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Call(ctx, "notion", "search", map[string]interface{}{"limit": 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got["workspace"] != "acme" {
+		t.Errorf("expected base_args to fill workspace, got %v", got)
+	}
+	if limit, ok := got["limit"].(float64); !ok || limit != 10 {
+		t.Errorf("expected the explicit limit to win over base_args, got %v", got["limit"])
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if tenants["tools/call"] != "acme" {
+		t.Errorf("expected call_headers on tools/call, got %q", tenants["tools/call"])
+	}
+	if _, ok := tenants["tools/list"]; !ok {
+		t.Fatal("expected a tools/list request")
+	}
+	for method, tenant := range tenants {
+		if method != "tools/call" && tenant != "" {
+			t.Errorf("expected no call_headers on %s, got %q", method, tenant)
+		}
+	}
+}
+
+func TestLockOAuthWaiterGivesUpWithItsContext(t *testing.T) {
+	unlock, err := lockOAuth(context.Background(), "slow-login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := lockOAuth(ctx, "slow-login"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the waiter to stop at its deadline, got %v", err)
+	}
+	if other, err := lockOAuth(context.Background(), "other-server"); err != nil {
+		t.Fatalf("expected other servers to stay free, got %v", err)
+	} else {
+		other()
+	}
+	unlock()
+	again, err := lockOAuth(context.Background(), "slow-login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again()
+}
+
+func TestServerImplsStayOnTheirRegistry(t *testing.T) {
+	mcpServer := server.NewMCPServer("notes-server", "2.1.0", server.WithToolCapabilities(false), server.WithInstructions("use search first"))
+	mcpServer.AddTool(mcpproto.NewTool("search"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText("ok"), nil
+	})
+	ts := server.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "notes", Alias: "notes", Transport: "http", URL: ts.URL + "/mcp"}}}
+	r := NewRegistry(cfg, nil)
+	if _, _, err := r.ListTools(context.Background(), "notes"); err != nil {
+		t.Fatal(err)
+	}
+	if servers := r.Servers(); servers[0].ImplName != "notes-server" || servers[0].ImplVersion != "2.1.0" || servers[0].Instructions != "use search first" {
+		t.Fatalf("expected the handshake on the server entry, got %+v", servers[0])
+	}
+	if servers := NewRegistry(cfg, nil).Servers(); servers[0].ImplName != "" {
+		t.Errorf("expected another registry to know nothing yet, got %+v", servers[0])
+	}
+
+	adHoc := config.MCPServer{Name: ts.URL + "/mcp", Transport: "http", URL: ts.URL + "/mcp"}
+	if _, _, err := r.CallServer(context.Background(), adHoc, "search", nil); err != nil {
+		t.Fatal(err)
+	}
+	r.mu.RLock()
+	_, kept := r.impls[adHoc.Name]
+	r.mu.RUnlock()
+	if kept {
+		t.Error("expected the ad-hoc target not to be remembered")
+	}
+
+	r.UpdateConfig(&config.Config{Servers: []config.MCPServer{{Name: "notes", Alias: "notes", Transport: "http", URL: "https://other.example/mcp"}}})
+	if servers := r.Servers(); servers[0].ImplName != "" || servers[0].Instructions != "" {
+		t.Errorf("expected a config change to drop the old handshake, got %+v", servers[0])
+	}
+}
@@ -0,0 +1,264 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/prbarcelon/mcpshim/internal/config"
+)
+
+const middlewareTimeout = 10 * time.Second
+
+type MiddlewareCall struct {
+	Server string                 `json:"server"`
+	Tool   string                 `json:"tool"`
+	Args   map[string]interface{} `json:"args,omitempty"`
+}
+
+// a middleware sees every tool call before it is sent, and its result after.
+// Before may rewrite call.Args, or return a result to answer the call itself.
+type Middleware interface {
+	Name() string
+	Before(ctx context.Context, call *MiddlewareCall) (interface{}, error)
+	After(ctx context.Context, call MiddlewareCall, result interface{}, callErr error) (interface{}, error)
+	Close() error
+}
+
+func (r *Registry) SetMiddleware(middleware []Middleware) {
+	r.mu.Lock()
+	previous := r.middleware
+	r.middleware = middleware
+	r.mu.Unlock()
+	closeMiddleware(previous)
+}
+
+func (r *Registry) Close() {
+	r.SetMiddleware(nil)
+}
+
+func closeMiddleware(middleware []Middleware) {
+	for _, m := range middleware {
+		_ = m.Close()
+	}
+}
+
+func buildMiddleware(items []config.Middleware) []Middleware {
+	out := make([]Middleware, 0, len(items))
+	for _, item := range items {
+		out = append(out, newProcessMiddleware(item))
+	}
+	return out
+}
+
+func (r *Registry) callWithMiddleware(ctx context.Context, s config.MCPServer, tool string, args map[string]interface{}, next func(map[string]interface{}) (interface{}, error)) (interface{}, error) {
+	r.mu.RLock()
+	chain := r.middleware
+	r.mu.RUnlock()
+	if len(chain) == 0 {
+		return next(args)
+	}
+
+	call := MiddlewareCall{Server: s.Name, Tool: tool, Args: args}
+	var result interface{}
+	var err error
+	entered := 0
+	for _, m := range chain {
+		entered++
+		result, err = m.Before(ctx, &call)
+		if err != nil {
+			err = fmt.Errorf("middleware %s: %w", m.Name(), err)
+			break
+		}
+		if result != nil {
+			break
+		}
+	}
+	if err == nil && result == nil {
+		result, err = next(call.Args)
+	}
+	// results unwind through the middleware that saw the request, innermost first
+	for i := entered - 1; i >= 0; i-- {
+		m := chain[i]
+		updated, afterErr := m.After(ctx, call, result, err)
+		if afterErr != nil {
+			return nil, fmt.Errorf("middleware %s: %w", m.Name(), afterErr)
+		}
+		result = updated
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type middlewareMessage struct {
+	Phase  string                 `json:"phase"`
+	Server string                 `json:"server"`
+	Tool   string                 `json:"tool"`
+	Args   map[string]interface{} `json:"args,omitempty"`
+	Result interface{}            `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+type middlewareReply struct {
+	Args   map[string]interface{} `json:"args,omitempty"`
+	Result json.RawMessage        `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// processMiddleware talks to a long-running command, one JSON object per
+// line each way; calls are serialized and a command that misbehaves is
+// restarted on the next call
+type processMiddleware struct {
+	cfg config.Middleware
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newProcessMiddleware(cfg config.Middleware) *processMiddleware {
+	return &processMiddleware{cfg: cfg}
+}
+
+func (m *processMiddleware) Name() string {
+	return m.cfg.Name
+}
+
+func (m *processMiddleware) Before(ctx context.Context, call *MiddlewareCall) (interface{}, error) {
+	reply, err := m.exchange(ctx, middlewareMessage{Phase: "request", Server: call.Server, Tool: call.Tool, Args: call.Args})
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	if reply.Args != nil {
+		call.Args = reply.Args
+	}
+	if len(reply.Result) > 0 {
+		return decodeReplyResult(reply.Result)
+	}
+	return nil, nil
+}
+
+func (m *processMiddleware) After(ctx context.Context, call MiddlewareCall, result interface{}, callErr error) (interface{}, error) {
+	msg := middlewareMessage{Phase: "result", Server: call.Server, Tool: call.Tool, Args: call.Args, Result: result}
+	if callErr != nil {
+		msg.Error = callErr.Error()
+	}
+	reply, err := m.exchange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	if len(reply.Result) > 0 {
+		return decodeReplyResult(reply.Result)
+	}
+	return result, nil
+}
+
+func decodeReplyResult(raw json.RawMessage) (interface{}, error) {
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+	return result, nil
+}
+
+func (m *processMiddleware) exchange(ctx context.Context, msg middlewareMessage) (middlewareReply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cmd == nil {
+		if err := m.start(); err != nil {
+			return middlewareReply{}, err
+		}
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return middlewareReply{}, fmt.Errorf("encode %s message: %w", msg.Phase, err)
+	}
+
+	type lineResult struct {
+		line []byte
+		err  error
+	}
+	done := make(chan lineResult, 1)
+	go func() {
+		if _, err := m.stdin.Write(append(data, '\n')); err != nil {
+			done <- lineResult{err: err}
+			return
+		}
+		line, err := m.stdout.ReadBytes('\n')
+		done <- lineResult{line: line, err: err}
+	}()
+
+	timer := time.NewTimer(middlewareTimeout)
+	defer timer.Stop()
+	var res lineResult
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res.err = ctx.Err()
+	case <-timer.C:
+		res.err = fmt.Errorf("no reply after %s", middlewareTimeout)
+	}
+	if res.err != nil {
+		// the reader may still be blocked on the old pipes, so start fresh
+		m.stop()
+		return middlewareReply{}, res.err
+	}
+	var reply middlewareReply
+	if err := json.Unmarshal(res.line, &reply); err != nil {
+		m.stop()
+		return middlewareReply{}, fmt.Errorf("decode reply: %w", err)
+	}
+	return reply, nil
+}
+
+func (m *processMiddleware) start() error {
+	cmd := exec.Command(m.cfg.Command[0], m.cfg.Command[1:]...)
+	cmd.Env = append(os.Environ(), m.cfg.Env...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	m.cmd, m.stdin, m.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+func (m *processMiddleware) stop() {
+	if m.cmd == nil {
+		return
+	}
+	_ = m.stdin.Close()
+	_ = m.cmd.Process.Kill()
+	_ = m.cmd.Wait()
+	m.cmd, m.stdin, m.stdout = nil, nil, nil
+}
+
+func (m *processMiddleware) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stop()
+	return nil
+}
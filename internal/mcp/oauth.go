@@ -2,7 +2,9 @@ package mcp
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -11,8 +13,11 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	mcpclient "github.com/mark3labs/mcp-go/client"
@@ -22,7 +27,27 @@ import (
 	"github.com/prbarcelon/mcpshim/internal/store"
 )
 
-const oauthCallbackTimeout = 5 * time.Minute
+const (
+	oauthCallbackTimeout    = 5 * time.Minute
+	defaultOAuthRedirectURI = "http://127.0.0.1:53685/oauth/callback"
+)
+
+var oauthLocks sync.Map
+
+func lockOAuth(ctx context.Context, server string) (func(), error) {
+	// one refresh or login per server at a time: concurrent calls that all
+	// see an expired token wait, then reuse the token the first one stored
+	// instead of each spending the refresh token. a login can hold this for
+	// minutes, so waiters give up when their own context ends
+	value, _ := oauthLocks.LoadOrStore(server, make(chan struct{}, 1))
+	sem := value.(chan struct{})
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("server %q: waiting for another oauth refresh or login: %w", server, ctx.Err())
+	}
+}
 
 func runWithOAuthFallback[T any](ctx context.Context, s config.MCPServer, dbStore *store.Store, interactive bool, operation func(compatibleClient) (T, error)) (T, error) {
 	result, err := runOperation(ctx, s, operation)
@@ -30,59 +55,79 @@ func runWithOAuthFallback[T any](ctx context.Context, s config.MCPServer, dbStor
 		return result, err
 	}
 
+	responses := &responseRecorder{}
+	oauthClient, closeFn, err := authorizeOAuthClient(ctx, s, dbStore, interactive, responses)
+	if err != nil {
+		var zero T
+		return zero, responses.annotate(err)
+	}
+	defer closeFn()
+	defer trackConnection(ctx, s.Name)()
+
+	result, err = operation(oauthClient)
+	return result, responses.annotate(err)
+}
+
+func authorizeOAuthClient(ctx context.Context, s config.MCPServer, dbStore *store.Store, interactive bool, responses *responseRecorder) (compatibleClient, func(), error) {
+	unlock, err := lockOAuth(ctx, s.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
 	callback := (*oauthCallbackServer)(nil)
-	redirectURI := "http://127.0.0.1:53685/oauth/callback"
+	redirectURI := defaultOAuthRedirectURI
 	if interactive {
 		callback, err = startOAuthCallbackServer()
 		if err != nil {
-			var zero T
-			return zero, err
+			return nil, nil, err
 		}
 		defer callback.close()
 		redirectURI = callback.redirectURI
 	}
 
-	oauthClient, closeFn, err := newOAuthClient(s, mcpclient.OAuthConfig{
-		RedirectURI: redirectURI,
-		TokenStore:  newSQLiteTokenStore(dbStore, s.Name),
-		PKCEEnabled: true,
-	})
+	oauthConfig, err := serverOAuthConfig(ctx, s, dbStore, redirectURI)
 	if err != nil {
-		var zero T
-		return zero, err
+		return nil, nil, err
 	}
-	defer closeFn()
-
-	result, err = runOperationWithClient(ctx, oauthClient, operation)
-	if err == nil {
-		return result, nil
+	oauthClient, closeFn, err := newOAuthClient(s, oauthConfig, responses)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if !mcpclient.IsOAuthAuthorizationRequiredError(err) {
-		return result, err
+	err = initializeClient(ctx, s, oauthClient)
+	if err != nil && refreshRejectedToken(ctx, s, dbStore, err) {
+		err = initializeClient(ctx, s, oauthClient)
 	}
-	if !interactive {
-		var zero T
-		return zero, fmt.Errorf("server %q requires oauth authorization; run a direct command like mcpshim tools --server %s to complete login", s.Name, s.Name)
-	}
-	if callback == nil {
-		var zero T
-		return zero, errors.New("oauth callback server is not available")
+	if err != nil && mcpclient.IsOAuthAuthorizationRequiredError(err) {
+		switch {
+		case !interactive:
+			err = fmt.Errorf("server %q requires oauth authorization; run a direct command like mcpshim tools --server %s to complete login", s.Name, s.Name)
+		case callback == nil:
+			err = errors.New("oauth callback server is not available")
+		default:
+			if err = completeOAuthFlow(ctx, s, err, callback, false); err == nil {
+				err = initializeClient(ctx, s, oauthClient)
+			}
+		}
 	}
-
-	if err := completeOAuthFlow(ctx, err, callback, false); err != nil {
-		var zero T
-		return zero, err
+	if err != nil {
+		closeFn()
+		return nil, nil, err
 	}
-
-	return runOperationWithClient(ctx, oauthClient, operation)
+	return oauthClient, closeFn, nil
 }
 
 func runOAuthLogin(ctx context.Context, s config.MCPServer, dbStore *store.Store, manual bool) error {
+	unlock, err := lockOAuth(ctx, s.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	callback := (*oauthCallbackServer)(nil)
-	redirectURI := "http://127.0.0.1:53685/oauth/callback"
+	redirectURI := defaultOAuthRedirectURI
 	if !manual {
-		var err error
 		callback, err = startOAuthCallbackServer()
 		if err != nil {
 			return err
@@ -91,19 +136,17 @@ func runOAuthLogin(ctx context.Context, s config.MCPServer, dbStore *store.Store
 		redirectURI = callback.redirectURI
 	}
 
-	oauthClient, closeFn, err := newOAuthClient(s, mcpclient.OAuthConfig{
-		RedirectURI: redirectURI,
-		TokenStore:  newSQLiteTokenStore(dbStore, s.Name),
-		PKCEEnabled: true,
-	})
+	oauthConfig, err := serverOAuthConfig(ctx, s, dbStore, redirectURI)
+	if err != nil {
+		return err
+	}
+	oauthClient, closeFn, err := newOAuthClient(s, oauthConfig, nil)
 	if err != nil {
 		return err
 	}
 	defer closeFn()
 
-	_, err = runOperationWithClient(ctx, oauthClient, func(cli compatibleClient) (struct{}, error) {
-		return struct{}{}, nil
-	})
+	err = trySilentAuth(ctx, s, dbStore, oauthClient)
 	if err == nil {
 		return nil
 	}
@@ -111,29 +154,107 @@ func runOAuthLogin(ctx context.Context, s config.MCPServer, dbStore *store.Store
 		return err
 	}
 
-	return completeOAuthFlow(ctx, err, callback, manual)
+	return completeOAuthFlow(ctx, s, err, callback, manual)
 }
 
-func runOperation[T any](ctx context.Context, s config.MCPServer, operation func(compatibleClient) (T, error)) (T, error) {
-	client, closeFn, err := newClient(s)
+func runOAuthCheck(ctx context.Context, s config.MCPServer, dbStore *store.Store) error {
+	_, err := runOperation(ctx, s, noopOperation)
+	if err == nil || !shouldTryOAuthFallback(s, err) {
+		return err
+	}
+	unlock, err := lockOAuth(ctx, s.Name)
 	if err != nil {
-		var zero T
-		return zero, err
+		return err
+	}
+	defer unlock()
+
+	oauthConfig, err := serverOAuthConfig(ctx, s, dbStore, defaultOAuthRedirectURI)
+	if err != nil {
+		return err
+	}
+	oauthClient, closeFn, err := newOAuthClient(s, oauthConfig, nil)
+	if err != nil {
+		return err
 	}
 	defer closeFn()
 
-	return runOperationWithClient(ctx, client, operation)
+	err = trySilentAuth(ctx, s, dbStore, oauthClient)
+	if mcpclient.IsOAuthAuthorizationRequiredError(err) {
+		return fmt.Errorf("server %q is not authenticated; run mcpshim login --server %s", s.Name, s.Name)
+	}
+	return err
 }
 
-func runOperationWithClient[T any](ctx context.Context, client compatibleClient, operation func(compatibleClient) (T, error)) (T, error) {
-	if err := client.Start(ctx); err != nil {
+func trySilentAuth(ctx context.Context, s config.MCPServer, dbStore *store.Store, client compatibleClient) error {
+	_, err := runOperationWithClient(ctx, s, client, noopOperation)
+	if err != nil && refreshRejectedToken(ctx, s, dbStore, err) {
+		_, err = runOperationWithClient(ctx, s, client, noopOperation)
+	}
+	return err
+}
+
+// mcp-go only refreshes a token whose expiry has passed; one the server
+// rejects earlier (revoked, or issued without expires_in) would go straight
+// to the browser, so spend the stored refresh token first
+func refreshRejectedToken(ctx context.Context, s config.MCPServer, dbStore *store.Store, authErr error) bool {
+	handler := mcpclient.GetOAuthHandler(authErr)
+	if handler == nil || dbStore == nil {
+		return false
+	}
+	token, err := dbStore.GetToken(ctx, s.Name)
+	if err != nil || token == nil || token.RefreshToken == "" {
+		return false
+	}
+	if !token.ExpiresAt.IsZero() && time.Until(token.ExpiresAt) <= tokenExpirySkew {
+		// already expired, so mcp-go tried this refresh token and it failed
+		return false
+	}
+	// the handler saves the new token through the sqlite token store
+	_, err = handler.RefreshToken(ctx, token.RefreshToken)
+	return err == nil
+}
+
+func noopOperation(cli compatibleClient) (struct{}, error) {
+	return struct{}{}, nil
+}
+
+func runOperation[T any](ctx context.Context, s config.MCPServer, operation func(compatibleClient) (T, error)) (T, error) {
+	if s.Transport == "sse" {
+		return runWithSSEReconnect(ctx, s, func() (T, error) {
+			return runOperationOnce(ctx, s, operation)
+		})
+	}
+	return runOperationOnce(ctx, s, operation)
+}
+
+func runOperationOnce[T any](ctx context.Context, s config.MCPServer, operation func(compatibleClient) (T, error)) (T, error) {
+	_, span := tracer.Start(ctx, "mcp.connect")
+	responses := &responseRecorder{}
+	client, closeFn, err := newClient(s, responses)
+	endSpan(span, err)
+	if err != nil {
 		var zero T
 		return zero, err
 	}
-	initReq := mcpproto.InitializeRequest{}
-	initReq.Params.ProtocolVersion = mcpproto.LATEST_PROTOCOL_VERSION
-	initReq.Params.ClientInfo = mcpproto.Implementation{Name: "mcpshimd", Version: "dev"}
-	if _, err := client.Initialize(ctx, initReq); err != nil {
+	defer closeFn()
+	defer trackConnection(ctx, s.Name)()
+
+	sent := false
+	result, err := runOperationWithClient(ctx, s, client, func(cli compatibleClient) (T, error) {
+		sent = true
+		return operation(cli)
+	})
+	if err != nil && !errors.Is(err, errSSEConnectionLost) && wasConnectionLost(client) {
+		err = fmt.Errorf("%w: %w", errSSEConnectionLost, err)
+	}
+	if sent && errors.Is(err, errSSEConnectionLost) && ctx.Value(noReplayKey{}) != nil {
+		err = fmt.Errorf("%w; %w", err, errNotReplayed)
+	}
+	return result, responses.annotate(err)
+}
+
+func runOperationWithClient[T any](ctx context.Context, s config.MCPServer, client compatibleClient, operation func(compatibleClient) (T, error)) (T, error) {
+	if err := initializeClient(ctx, s, client); err != nil {
 		var zero T
 		return zero, err
 	}
@@ -141,11 +262,73 @@ func runOperationWithClient[T any](ctx context.Context, client compatibleClient,
 	return operation(client)
 }
 
+func initializeClient(ctx context.Context, s config.MCPServer, client compatibleClient) (err error) {
+	ctx, span := tracer.Start(ctx, "mcp.initialize")
+	defer func() { endSpan(span, err) }()
+	if counters := connCountersFor(ctx, s.Name); counters != nil {
+		counters.opened.Add(1)
+		defer func() {
+			if err != nil {
+				counters.failed.Add(1)
+			}
+		}()
+	}
+	if err := client.Start(ctx); err != nil {
+		return err
+	}
+	version := s.ProtocolVersion
+	if version == "" {
+		version = s.DefaultProtocolVersion
+	}
+	if version == "" {
+		version = mcpproto.LATEST_PROTOCOL_VERSION
+	}
+	name, clientVersion := s.ClientIdentity()
+	info := mcpproto.Implementation{Name: name, Version: clientVersion}
+	result, err := initializeWithVersion(ctx, client, version, info)
+	if offered, ok := offeredProtocolVersion(err, version); ok {
+		// a lagging server named a version it does speak; retry once with it
+		result, err = initializeWithVersion(ctx, client, offered, info)
+	}
+	if r, ok := ctx.Value(registryKey{}).(*Registry); ok && err == nil {
+		r.rememberImpl(s.Name, result)
+	}
+	return err
+}
+
+func initializeWithVersion(ctx context.Context, client compatibleClient, version string, info mcpproto.Implementation) (*mcpproto.InitializeResult, error) {
+	initReq := mcpproto.InitializeRequest{}
+	initReq.Params.ProtocolVersion = version
+	initReq.Params.ClientInfo = info
+	started := time.Now()
+	result, err := client.Initialize(ctx, initReq)
+	recordExchange(ctx, "initialize", initReq.Params, result, err, started)
+	return result, err
+}
+
+var protocolVersionPattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}`)
+
+func offeredProtocolVersion(err error, requested string) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	msg := err.Error()
+	if !errors.Is(err, mcpproto.UnsupportedProtocolVersionError{}) && !strings.Contains(strings.ToLower(msg), "protocol version") {
+		return "", false
+	}
+	for _, candidate := range protocolVersionPattern.FindAllString(msg, -1) {
+		if candidate != requested && slices.Contains(mcpproto.ValidProtocolVersions, candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
 func shouldTryOAuthFallback(s config.MCPServer, err error) bool {
 	if err == nil {
 		return false
 	}
-	if s.Transport == "stdio" {
+	if s.Transport == "stdio" || !s.OAuthFallbackEnabled() {
 		return false
 	}
 	if hasAuthorizationHeader(s.Headers) {
@@ -167,16 +350,37 @@ type oauthCallbackServer struct {
 	redirectURI string
 	server      *http.Server
 	listener    net.Listener
-	params      chan map[string]string
+
+	mu      sync.Mutex
+	waiters map[string]chan map[string]string
+	refs    int
 }
 
+var (
+	sharedCallbackMu sync.Mutex
+	sharedCallback   *oauthCallbackServer
+)
+
 func startOAuthCallbackServer() (*oauthCallbackServer, error) {
+	// parallel logins share one listener; each flow waits on its own state
+	sharedCallbackMu.Lock()
+	defer sharedCallbackMu.Unlock()
+	if sharedCallback != nil {
+		sharedCallback.refs++
+		return sharedCallback, nil
+	}
+
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return nil, err
 	}
 
-	params := make(chan map[string]string, 1)
+	callback := &oauthCallbackServer{
+		redirectURI: fmt.Sprintf("http://%s/oauth/callback", listener.Addr().String()),
+		listener:    listener,
+		waiters:     map[string]chan map[string]string{},
+		refs:        1,
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/oauth/callback", func(w http.ResponseWriter, r *http.Request) {
 		values := map[string]string{}
@@ -185,8 +389,15 @@ func startOAuthCallbackServer() (*oauthCallbackServer, error) {
 				values[key] = all[0]
 			}
 		}
+		callback.mu.Lock()
+		waiter := callback.waiters[values["state"]]
+		callback.mu.Unlock()
+		if waiter == nil {
+			http.Error(w, "unknown or expired oauth state; start the login again", http.StatusBadRequest)
+			return
+		}
 		select {
-		case params <- values:
+		case waiter <- values:
 		default:
 		}
 
@@ -194,30 +405,49 @@ func startOAuthCallbackServer() (*oauthCallbackServer, error) {
 		_, _ = io.WriteString(w, "<html><body><h1>Authorization complete</h1><p>You can close this window.</p><script>window.close();</script></body></html>")
 	})
 
-	server := &http.Server{Handler: mux}
+	callback.server = &http.Server{Handler: mux}
 	go func() {
-		_ = server.Serve(listener)
+		_ = callback.server.Serve(listener)
 	}()
 
-	return &oauthCallbackServer{
-		redirectURI: fmt.Sprintf("http://%s/oauth/callback", listener.Addr().String()),
-		server:      server,
-		listener:    listener,
-		params:      params,
-	}, nil
+	sharedCallback = callback
+	return callback, nil
 }
 
 func (s *oauthCallbackServer) close() {
 	if s == nil {
 		return
 	}
+	sharedCallbackMu.Lock()
+	s.refs--
+	if s.refs > 0 {
+		sharedCallbackMu.Unlock()
+		return
+	}
+	if sharedCallback == s {
+		sharedCallback = nil
+	}
+	sharedCallbackMu.Unlock()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	_ = s.server.Shutdown(ctx)
 	_ = s.listener.Close()
 }
 
-func completeOAuthFlow(ctx context.Context, authErr error, callback *oauthCallbackServer, manual bool) error {
+func (s *oauthCallbackServer) expect(state string) (<-chan map[string]string, func()) {
+	params := make(chan map[string]string, 1)
+	s.mu.Lock()
+	s.waiters[state] = params
+	s.mu.Unlock()
+	return params, func() {
+		s.mu.Lock()
+		delete(s.waiters, state)
+		s.mu.Unlock()
+	}
+}
+
+func completeOAuthFlow(ctx context.Context, s config.MCPServer, authErr error, callback *oauthCallbackServer, manual bool) error {
 	oauthHandler := mcpclient.GetOAuthHandler(authErr)
 	if oauthHandler == nil {
 		return authErr
@@ -234,7 +464,11 @@ func completeOAuthFlow(ctx context.Context, authErr error, callback *oauthCallba
 	codeChallenge := mcpclient.GenerateCodeChallenge(codeVerifier)
 
 	if oauthHandler.GetClientID() == "" {
-		if err := oauthHandler.RegisterClient(ctx, "mcpshim"); err != nil {
+		clientName := "mcpshim"
+		if s.OAuth != nil && s.OAuth.ClientName != "" {
+			clientName = s.OAuth.ClientName
+		}
+		if err := oauthHandler.RegisterClient(ctx, clientName); err != nil {
 			return err
 		}
 	}
@@ -244,7 +478,15 @@ func completeOAuthFlow(ctx context.Context, authErr error, callback *oauthCallba
 		return err
 	}
 
-	fmt.Printf("oauth login required; authorize here: %s\n", authURL)
+	var params <-chan map[string]string
+	if !manual && callback != nil {
+		// register before the browser opens so a fast redirect is not dropped
+		var done func()
+		params, done = callback.expect(state)
+		defer done()
+	}
+
+	fmt.Printf("oauth login required for %s; authorize here: %s\n", s.Name, authURL)
 	if err := openBrowser(authURL); err != nil {
 		fmt.Printf("failed to open browser automatically: %v\n", err)
 	}
@@ -265,22 +507,24 @@ func completeOAuthFlow(ctx context.Context, authErr error, callback *oauthCallba
 	if callback == nil {
 		return errors.New("oauth callback server is not available")
 	}
-	fmt.Println("waiting for oauth callback...")
+	fmt.Printf("waiting for oauth callback for %s...\n", s.Name)
 
 	waitCtx, cancel := context.WithTimeout(ctx, oauthCallbackTimeout)
 	defer cancel()
 
-	params, err := callback.wait(waitCtx)
-	if err != nil {
-		return err
+	var result map[string]string
+	select {
+	case <-waitCtx.Done():
+		return waitCtx.Err()
+	case result = <-params:
 	}
-	if params["state"] != state {
+	if result["state"] != state {
 		return fmt.Errorf("oauth state mismatch")
 	}
-	if code := params["code"]; code != "" {
+	if code := result["code"]; code != "" {
 		return oauthHandler.ProcessAuthorizationResponse(ctx, code, state, codeVerifier)
 	}
-	if oauthError := params["error"]; oauthError != "" {
+	if oauthError := result["error"]; oauthError != "" {
 		return fmt.Errorf("oauth authorization failed: %s", oauthError)
 	}
 	return errors.New("oauth authorization did not return a code")
@@ -318,36 +562,100 @@ func readManualOAuthInput(expectedState string) (map[string]string, error) {
 	return map[string]string{"code": line}, nil
 }
 
-func (s *oauthCallbackServer) wait(ctx context.Context) (map[string]string, error) {
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case params := <-s.params:
-		return params, nil
+func serverOAuthConfig(ctx context.Context, s config.MCPServer, dbStore *store.Store, redirectURI string) (mcpclient.OAuthConfig, error) {
+	cfg := mcpclient.OAuthConfig{
+		RedirectURI: redirectURI,
+		TokenStore:  newSQLiteTokenStore(dbStore, s.Name),
+		PKCEEnabled: true,
+	}
+	// discovery starts at the server itself, so it needs the same tls setup
+	base, err := serverTransport(s)
+	if err != nil {
+		return cfg, err
+	}
+	if base != nil {
+		cfg.HTTPClient = &http.Client{Timeout: 30 * time.Second, Transport: base}
+	}
+	if s.OAuth == nil {
+		return cfg, nil
+	}
+	cfg.Scopes = s.OAuth.Scopes
+	cfg.ClientID = s.OAuth.ClientID
+	if ref := strings.TrimSpace(s.OAuth.ClientSecret); ref != "" {
+		secret, err := resolveSecret(ctx, s, ref)
+		if err != nil {
+			return cfg, fmt.Errorf("server %q oauth.client_secret: %w", s.Name, err)
+		}
+		cfg.ClientSecret = secret
+	}
+	if s.OAuth.SoftwareID != "" {
+		// mcp-go has no field for software_id, so add it to the registration body
+		cfg.HTTPClient = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: registrationFields{fields: map[string]string{"software_id": s.OAuth.SoftwareID}, base: base},
+		}
 	}
+	return cfg, nil
 }
 
-func newOAuthClient(s config.MCPServer, oauthConfig mcpclient.OAuthConfig) (compatibleClient, func(), error) {
-	if s.Transport == "sse" {
-		opts := []transport.ClientOption{}
-		if len(s.Headers) > 0 {
-			opts = append(opts, transport.WithHeaders(s.Headers))
+type registrationFields struct {
+	fields map[string]string
+	// nil means http.DefaultTransport
+	base http.RoundTripper
+}
+
+func (f registrationFields) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := f.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Method != http.MethodPost || req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		return base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	// only a dynamic client registration carries redirect_uris
+	if json.Unmarshal(body, &fields) == nil && fields["redirect_uris"] != nil {
+		for k, v := range f.fields {
+			fields[k] = v
 		}
-		cli, err := mcpclient.NewOAuthSSEClient(s.URL, oauthConfig, opts...)
-		if err != nil {
-			return nil, nil, err
+		if updated, err := json.Marshal(fields); err == nil {
+			body = updated
 		}
-		return cli, func() { _ = cli.Close() }, nil
 	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return base.RoundTrip(req)
+}
 
-	opts := []transport.StreamableHTTPCOption{}
-	if len(s.Headers) > 0 {
-		opts = append(opts, transport.WithHTTPHeaders(s.Headers))
-	}
-	cli, err := mcpclient.NewOAuthStreamableHttpClient(s.URL, oauthConfig, opts...)
+func newOAuthClient(s config.MCPServer, oauthConfig mcpclient.OAuthConfig, responses *responseRecorder) (compatibleClient, func(), error) {
+	s, err := withResolvedHeaders(context.Background(), s)
 	if err != nil {
 		return nil, nil, err
 	}
+	if responses, err = withServerTransport(s, responses); err != nil {
+		return nil, nil, err
+	}
+	var trans transport.Interface
+	if s.Transport == "sse" {
+		t, err := transport.NewSSE(s.URL, append(sseOptions(s, responses), transport.WithOAuth(oauthConfig))...)
+		if err != nil {
+			return nil, nil, err
+		}
+		trans = t
+	} else {
+		t, err := transport.NewStreamableHTTP(s.URL, append(streamableHTTPOptions(s, responses), transport.WithHTTPOAuth(oauthConfig))...)
+		if err != nil {
+			return nil, nil, err
+		}
+		trans = t
+	}
+	cli := mcpclient.NewClient(trans, clientOptions(s)...)
 	return cli, func() { _ = cli.Close() }, nil
 }
 
@@ -0,0 +1,52 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+type progressKey struct{}
+
+// mcp-go names no constant for it
+const methodNotificationProgress = "notifications/progress"
+
+// tokens only have to be unique among the calls on one session
+var progressTokens atomic.Int64
+
+// calls made with the returned context ask the server for progress
+// notifications and pass each one to report
+func WithProgress(ctx context.Context, report func(protocol.Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// asks for progress on req when the caller wants it, and forwards the
+// matching notifications from cli
+func watchProgress(ctx context.Context, s string, cli compatibleClient, req *mcpproto.CallToolRequest) {
+	report, ok := ctx.Value(progressKey{}).(func(protocol.Progress))
+	if !ok {
+		return
+	}
+	token := fmt.Sprintf("mcpshim-%d", progressTokens.Add(1))
+	if req.Params.Meta == nil {
+		req.Params.Meta = &mcpproto.Meta{}
+	}
+	req.Params.Meta.ProgressToken = token
+	cli.OnNotification(func(notification mcpproto.JSONRPCNotification) {
+		if notification.Method != methodNotificationProgress {
+			return
+		}
+		fields := notification.Params.AdditionalFields
+		if fmt.Sprint(fields["progressToken"]) != token {
+			return
+		}
+		update := protocol.Progress{Server: s}
+		update.Progress, _ = fields["progress"].(float64)
+		update.Total, _ = fields["total"].(float64)
+		update.Message, _ = fields["message"].(string)
+		report(update)
+	})
+}
@@ -0,0 +1,79 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+func (r *Registry) ListPrompts(ctx context.Context, server string) ([]protocol.PromptInfo, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RequestTimeout(DefaultListTimeout))
+	defer cancel()
+
+	prompts, err := runWithOAuthFallback(r.scoped(ctx), s, r.store, true, func(cli compatibleClient) ([]mcpproto.Prompt, error) {
+		list, err := cli.ListPrompts(ctx, mcpproto.ListPromptsRequest{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Prompts, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]protocol.PromptInfo, 0, len(prompts))
+	for _, p := range prompts {
+		info := protocol.PromptInfo{Server: s.Name, Name: p.Name, Description: p.Description}
+		for _, arg := range p.Arguments {
+			info.Arguments = append(info.Arguments, protocol.PromptArgument{
+				Name:        arg.Name,
+				Description: arg.Description,
+				Required:    arg.Required,
+			})
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (r *Registry) GetPrompt(ctx context.Context, server string, name string, args map[string]string) (*protocol.PromptResult, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("prompt name is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RequestTimeout(DefaultCallTimeout))
+	defer cancel()
+
+	result, err := runWithOAuthFallback(r.scoped(ctx), s, r.store, true, func(cli compatibleClient) (*mcpproto.GetPromptResult, error) {
+		req := mcpproto.GetPromptRequest{}
+		req.Params.Name = name
+		req.Params.Arguments = args
+		return cli.GetPrompt(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &protocol.PromptResult{Server: s.Name, Name: name, Description: result.Description}
+	for _, msg := range result.Messages {
+		out.Messages = append(out.Messages, protocol.PromptMessage{Role: string(msg.Role), Content: msg.Content})
+	}
+	return out, nil
+}
@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/prbarcelon/mcpshim/internal/config"
+)
+
+// proxies in front of sse servers cut idle streams; a dropped stream is
+// retried on a fresh connection after each of these delays in turn
+var sseReconnectBackoff = []time.Duration{250 * time.Millisecond, time.Second, 4 * time.Second}
+
+// a connection that stayed up this long starts the backoff over
+const sseHealthyAfter = time.Minute
+
+var errSSEConnectionLost = errors.New("sse connection lost")
+
+// over sse a request is accepted before its result arrives on the stream,
+// so a drop after a tool call went out may come after the tool already ran
+var errNotReplayed = errors.New("not retried, since the tool may already have run")
+
+type noReplayKey struct{}
+
+// marks operations that must not be sent twice; a drop while connecting is
+// still retried, one after the operation started is returned as-is
+func withoutReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noReplayKey{}, true)
+}
+
+var debugLogging atomic.Bool
+
+// turns on the daemon's --debug logging for reconnects
+func SetDebug(on bool) {
+	debugLogging.Store(on)
+}
+
+type sseClient struct {
+	compatibleClient
+	lost chan struct{}
+	once sync.Once
+}
+
+type connectionLostNotifier interface {
+	OnConnectionLost(handler func(error))
+}
+
+func watchSSE(cli compatibleClient, trans *transport.SSE) *sseClient {
+	w := &sseClient{compatibleClient: cli, lost: make(chan struct{})}
+	if notifier, ok := cli.(connectionLostNotifier); ok {
+		notifier.OnConnectionLost(func(error) {
+			w.once.Do(func() {
+				close(w.lost)
+				// without the stream no response can arrive, so fail
+				// pending requests now instead of at their timeout
+				_ = trans.Close()
+			})
+		})
+	}
+	return w
+}
+
+func connectionLost(cli compatibleClient) <-chan struct{} {
+	if w, ok := cli.(*sseClient); ok {
+		return w.lost
+	}
+	return nil
+}
+
+func wasConnectionLost(cli compatibleClient) bool {
+	select {
+	case <-connectionLost(cli):
+		return true
+	default:
+		return false
+	}
+}
+
+func runWithSSEReconnect[T any](ctx context.Context, s config.MCPServer, attempt func() (T, error)) (T, error) {
+	failures := 0
+	for {
+		started := time.Now()
+		result, err := attempt()
+		if !errors.Is(err, errSSEConnectionLost) || ctx.Err() != nil {
+			return result, err
+		}
+		if errors.Is(err, errNotReplayed) {
+			return result, fmt.Errorf("server %q: %w", s.Name, err)
+		}
+		if time.Since(started) > sseHealthyAfter {
+			failures = 0
+		}
+		if failures == len(sseReconnectBackoff) {
+			return result, fmt.Errorf("server %q: %w", s.Name, err)
+		}
+		delay := sseReconnectBackoff[failures]
+		failures++
+		if debugLogging.Load() {
+			log.Printf("server %q: sse stream dropped, reconnecting in %s (attempt %d/%d)", s.Name, delay, failures, len(sseReconnectBackoff))
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+func (r *Registry) ListResources(ctx context.Context, server string) ([]protocol.ResourceInfo, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RequestTimeout(DefaultListTimeout))
+	defer cancel()
+
+	// mcp-go follows nextCursor, so this is every page
+	resources, err := runWithOAuthFallback(r.scoped(ctx), s, r.store, true, func(cli compatibleClient) ([]mcpproto.Resource, error) {
+		list, err := cli.ListResources(ctx, mcpproto.ListResourcesRequest{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Resources, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]protocol.ResourceInfo, 0, len(resources))
+	for _, res := range resources {
+		out = append(out, protocol.ResourceInfo{
+			Server:      s.Name,
+			URI:         res.URI,
+			Name:        res.Name,
+			Description: res.Description,
+			MIMEType:    res.MIMEType,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URI < out[j].URI })
+	return out, nil
+}
+
+func (r *Registry) ReadResource(ctx context.Context, server string, uri string) (*mcpproto.ReadResourceResult, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	s, err := activeServer(cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	if uri == "" {
+		return nil, fmt.Errorf("uri is required")
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RequestTimeout(DefaultCallTimeout))
+	defer cancel()
+
+	return runWithOAuthFallback(r.scoped(ctx), s, r.store, true, func(cli compatibleClient) (*mcpproto.ReadResourceResult, error) {
+		req := mcpproto.ReadResourceRequest{}
+		req.Params.URI = uri
+		return cli.ReadResource(ctx, req)
+	})
+}
@@ -0,0 +1,89 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+)
+
+type noCacheKey struct{}
+
+// calls made with the returned context always reach the server, though a
+// fresh result still replaces the cached one
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+type cachedResult struct {
+	result  interface{}
+	expires time.Time
+}
+
+// results of tools marked cacheable, keyed by server, tool and a hash of the
+// final arguments
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{entries: map[string]cachedResult{}}
+}
+
+func resultKey(server, tool string, args map[string]interface{}) (string, bool) {
+	// maps marshal with sorted keys, so equal arguments hash the same
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(raw)
+	return server + "\x00" + tool + "\x00" + hex.EncodeToString(sum[:]), true
+}
+
+func (c *resultCache) get(key string, now time.Time) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.result, true
+}
+
+func (c *resultCache) put(key string, result interface{}, ttl time.Duration, now time.Time) {
+	// a tool that reported failure may well succeed on the next try
+	if res, ok := result.(*mcpproto.CallToolResult); ok && res.IsError {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResult{result: result, expires: now.Add(ttl)}
+}
+
+func (c *resultCache) dropServer(server string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := server + "\x00"
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *resultCache) clear() {
+	c.mu.Lock()
+	c.entries = map[string]cachedResult{}
+	c.mu.Unlock()
+}
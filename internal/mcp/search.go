@@ -0,0 +1,60 @@
+package mcp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+// tools from the cache whose name or description matches query, ignoring
+// case; servers are never contacted, so a server missing from the cache
+// contributes nothing until its next refresh
+func (r *Registry) SearchTools(query string, useRegex bool) ([]protocol.ToolInfo, time.Time, error) {
+	match, err := toolMatcher(query, useRegex)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cacheStamp.IsZero() {
+		return nil, time.Time{}, errors.New("tool cache is empty; it fills when the daemon refreshes, every 2 minutes or on mcpshim reload")
+	}
+	found := []protocol.ToolInfo{}
+	for _, items := range r.toolCache {
+		for _, item := range items {
+			if match(item.Name) || match(item.Description) {
+				found = append(found, item)
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Server == found[j].Server {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].Server < found[j].Server
+	})
+	return found, r.cacheStamp, nil
+}
+
+func toolMatcher(query string, useRegex bool) (func(string) bool, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, errors.New("query is required")
+	}
+	if useRegex {
+		pattern, err := regexp.Compile("(?i)" + query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		return pattern.MatchString, nil
+	}
+	needle := strings.ToLower(query)
+	return func(text string) bool {
+		return strings.Contains(strings.ToLower(text), needle)
+	}, nil
+}
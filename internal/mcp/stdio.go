@@ -0,0 +1,85 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/prbarcelon/mcpshim/internal/config"
+)
+
+func startStdio(s config.MCPServer) (transport.Interface, error) {
+	if s.StdioMaxLineBytes <= 0 {
+		stdio := transport.NewStdio(s.Command[0], s.Env, s.Command[1:]...)
+		if err := stdio.Start(context.Background()); err != nil {
+			return nil, err
+		}
+		return stdio, nil
+	}
+
+	// mcp-go reads whole lines without a bound, so to enforce a limit we
+	// spawn the process ourselves and hand the transport a guarded stdout
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Env = append(os.Environ(), s.Env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	limited := &lineLimitReader{r: stdout, max: s.StdioMaxLineBytes, server: s.Name}
+	stdio := &managedStdio{Stdio: transport.NewIO(limited, stdin, stderr), cmd: cmd}
+	if err := stdio.Start(context.Background()); err != nil {
+		_ = stdio.Close()
+		return nil, err
+	}
+	return stdio, nil
+}
+
+type managedStdio struct {
+	*transport.Stdio
+	cmd *exec.Cmd
+}
+
+func (m *managedStdio) Close() error {
+	err := m.Stdio.Close()
+	if waitErr := m.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+type lineLimitReader struct {
+	r       io.Reader
+	max     int
+	current int
+	server  string
+}
+
+func (l *lineLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			l.current = 0
+			continue
+		}
+		l.current++
+		if l.current > l.max {
+			return i, fmt.Errorf("stdio server %q sent a message longer than stdio_max_line_bytes (%d)", l.server, l.max)
+		}
+	}
+	return n, err
+}
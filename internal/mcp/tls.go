@@ -0,0 +1,75 @@
+package mcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/prbarcelon/mcpshim/internal/config"
+)
+
+// transports for servers with tls files, shared so connections are pooled
+// as with the default transport; the key includes the files' modification
+// times, so a rotated certificate gets a fresh transport
+var tlsTransports sync.Map
+
+// the round tripper for a server's http and sse traffic, or nil when the
+// server has no tls files and the default transport applies
+func serverTransport(s config.MCPServer) (http.RoundTripper, error) {
+	if s.TLSClientCert == "" && s.TLSCACert == "" {
+		return nil, nil
+	}
+	key, err := tlsFilesKey(s.TLSClientCert, s.TLSClientKey, s.TLSCACert)
+	if err != nil {
+		return nil, fmt.Errorf("server %q tls: %w", s.Name, err)
+	}
+	if cached, ok := tlsTransports.Load(key); ok {
+		return cached.(*http.Transport), nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.TLSClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(s.TLSClientCert, s.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("server %q tls_client_cert: %w", s.Name, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	if s.TLSCACert != "" {
+		data, err := os.ReadFile(s.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("server %q tls_ca_cert: %w", s.Name, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("server %q tls_ca_cert: no pem certificates in %s", s.Name, s.TLSCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	actual, _ := tlsTransports.LoadOrStore(key, t)
+	return actual.(*http.Transport), nil
+}
+
+func tlsFilesKey(paths ...string) (string, error) {
+	parts := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			parts = append(parts, "")
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%s@%d", path, info.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, "\x00"), nil
+}
@@ -3,12 +3,17 @@ package mcp
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/prbarcelon/mcpshim/internal/store"
 )
 
+// how long before its recorded expiry a token is treated as expired, so it
+// is refreshed instead of running out between the check and the request
+const tokenExpirySkew = 30 * time.Second
+
 type sqliteTokenStore struct {
 	store      *store.Store
 	serverName string
@@ -25,13 +30,19 @@ func (s *sqliteTokenStore) GetToken(ctx context.Context) (*client.Token, error)
 	if s.store == nil {
 		return nil, transport.ErrNoToken
 	}
-	token, err := s.store.GetToken(s.serverName)
+	token, err := s.store.GetToken(ctx, s.serverName)
 	if err != nil {
 		return nil, err
 	}
 	if token == nil {
 		return nil, transport.ErrNoToken
 	}
+	if !token.ExpiresAt.IsZero() {
+		// mcp-go refreshes on its own once a token reports itself expired
+		early := *token
+		early.ExpiresAt = token.ExpiresAt.Add(-tokenExpirySkew)
+		return &early, nil
+	}
 	return token, nil
 }
 
@@ -42,7 +53,7 @@ func (s *sqliteTokenStore) SaveToken(ctx context.Context, token *client.Token) e
 	if s.store == nil {
 		return fmt.Errorf("sqlite store is not available")
 	}
-	return s.store.SaveToken(s.serverName, token)
+	return s.store.SaveToken(ctx, s.serverName, token)
 }
 
 func (s *sqliteTokenStore) String() string {
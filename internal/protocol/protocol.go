@@ -4,17 +4,42 @@ import "time"
 
 type Request struct {
 	Action    string                 `json:"action"`
+	ID        string                 `json:"id,omitempty"`
 	Name      string                 `json:"name,omitempty"`
 	Server    string                 `json:"server,omitempty"`
 	Tool      string                 `json:"tool,omitempty"`
 	Limit     int                    `json:"limit,omitempty"`
+	Count     bool                   `json:"count,omitempty"`
 	Alias     string                 `json:"alias,omitempty"`
 	URL       string                 `json:"url,omitempty"`
 	Transport string                 `json:"transport,omitempty"`
 	Headers   map[string]string      `json:"headers,omitempty"`
+	Bearer    string                 `json:"bearer,omitempty"`
 	Command   []string               `json:"command,omitempty"`
 	Env       []string               `json:"env,omitempty"`
+	Roots     []string               `json:"roots,omitempty"`
 	Args      map[string]interface{} `json:"args,omitempty"`
+	Method    string                 `json:"method,omitempty"`
+	Params    interface{}            `json:"params,omitempty"`
+	URI       string                 `json:"uri,omitempty"`
+	BeforeID  int64                  `json:"before_id,omitempty"`
+	AfterID   int64                  `json:"after_id,omitempty"`
+	Offset    int                    `json:"offset,omitempty"`
+	WithTotal bool                   `json:"with_total,omitempty"`
+	Heartbeat bool                   `json:"heartbeat,omitempty"`
+	Detail    bool                   `json:"detail,omitempty"`
+	Verbose   bool                   `json:"verbose,omitempty"`
+	Enabled   *bool                  `json:"enabled,omitempty"`
+	Stream    bool                   `json:"stream,omitempty"`
+	NoCache   bool                   `json:"no_cache,omitempty"`
+	Query     string                 `json:"query,omitempty"`
+	Regex     bool                   `json:"regex,omitempty"`
+
+	Purge        bool `json:"purge,omitempty"`
+	PurgeHistory bool `json:"purge_history,omitempty"`
+
+	All     bool     `json:"all,omitempty"`
+	Servers []string `json:"servers,omitempty"`
 }
 
 type ServerInfo struct {
@@ -25,6 +50,25 @@ type ServerInfo struct {
 	HasAuth   bool     `json:"has_auth"`
 	Command   []string `json:"command,omitempty"`
 	Env       []string `json:"env,omitempty"`
+	Disabled  bool     `json:"disabled,omitempty"`
+
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+
+	ImplName        string `json:"impl_name,omitempty"`
+	ImplVersion     string `json:"impl_version,omitempty"`
+	ProtocolVersion string `json:"protocol_version,omitempty"`
+	Instructions    string `json:"instructions,omitempty"`
+
+	Circuit             string `json:"circuit,omitempty"`
+	ConsecutiveFailures int    `json:"consecutive_failures,omitempty"`
+}
+
+type ServerResult struct {
+	OK         bool        `json:"ok"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+	Cached     bool        `json:"cached,omitempty"`
 }
 
 type ToolInfo struct {
@@ -35,6 +79,41 @@ type ToolInfo struct {
 	Properties  []string `json:"properties,omitempty"`
 }
 
+type ResourceInfo struct {
+	Server      string `json:"server"`
+	URI         string `json:"uri"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	MIMEType    string `json:"mime_type,omitempty"`
+}
+
+type PromptInfo struct {
+	Server      string           `json:"server"`
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+}
+
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+type PromptResult struct {
+	Server      string          `json:"server"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Messages    []PromptMessage `json:"messages"`
+}
+
+// content is the mcp content block as sent: text, image, audio or an
+// embedded resource
+type PromptMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
 type PropertyDetail struct {
 	Name        string   `json:"name"`
 	Type        string   `json:"type,omitempty"`
@@ -42,6 +121,10 @@ type PropertyDetail struct {
 	Const       string   `json:"const,omitempty"`
 	Description string   `json:"description,omitempty"`
 	Required    bool     `json:"required"`
+	// element type of an array, such as "string" or "object"
+	Items string `json:"items,omitempty"`
+	// fields of an object, or of each element of an array of objects
+	Properties []PropertyDetail `json:"properties,omitempty"`
 }
 
 type ToolDetail struct {
@@ -49,6 +132,18 @@ type ToolDetail struct {
 	Name        string           `json:"name"`
 	Description string           `json:"description,omitempty"`
 	Properties  []PropertyDetail `json:"properties,omitempty"`
+	// fields of the structured result, from the tool's outputSchema
+	OutputProperties []PropertyDetail `json:"output_properties,omitempty"`
+	// vendor extension data from the tool's _meta, passed through as-is
+	Meta map[string]interface{} `json:"_meta,omitempty"`
+}
+
+type ToolDiff struct {
+	Server    string    `json:"server"`
+	ChangedAt time.Time `json:"changed_at"`
+	Added     []string  `json:"added,omitempty"`
+	Removed   []string  `json:"removed,omitempty"`
+	Changed   []string  `json:"changed,omitempty"`
 }
 
 type Status struct {
@@ -56,26 +151,83 @@ type Status struct {
 	UptimeSec   int64     `json:"uptime_sec"`
 	ServerCount int       `json:"server_count"`
 	ToolCount   int       `json:"tool_count"`
+	LastRefresh time.Time `json:"last_refresh"`
+	// servers with a cached tool list, out of ServerCount
+	CachedServerCount int `json:"cached_server_count"`
+
+	OpenCircuits []string `json:"open_circuits,omitempty"`
 }
 
 type HistoryItem struct {
-	At         time.Time              `json:"at"`
-	Server     string                 `json:"server"`
-	Tool       string                 `json:"tool"`
-	Args       map[string]interface{} `json:"args,omitempty"`
-	Success    bool                   `json:"success"`
-	Error      string                 `json:"error,omitempty"`
-	DurationMs int64                  `json:"duration_ms"`
+	ID            int64                  `json:"id"`
+	At            time.Time              `json:"at"`
+	Server        string                 `json:"server"`
+	Tool          string                 `json:"tool"`
+	Args          map[string]interface{} `json:"args,omitempty"`
+	ArgsTruncated bool                   `json:"args_truncated,omitempty"`
+	Success       bool                   `json:"success"`
+	Error         string                 `json:"error,omitempty"`
+	DurationMs    int64                  `json:"duration_ms"`
+	ConnectMs     int64                  `json:"connect_ms"`
+	CallMs        int64                  `json:"call_ms"`
+}
+
+type ConnStats struct {
+	Server string `json:"server"`
+	Active int64  `json:"active"`
+	Opened int64  `json:"opened"`
+	Failed int64  `json:"failed"`
+}
+
+type ServerHealth struct {
+	Server    string `json:"server"`
+	OK        bool   `json:"ok"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ExchangeStep struct {
+	Method     string      `json:"method"`
+	Request    interface{} `json:"request,omitempty"`
+	Response   interface{} `json:"response,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// a notifications/progress update from a tool call still running
+type Progress struct {
+	Server   string  `json:"server,omitempty"`
+	Progress float64 `json:"progress"`
+	Total    float64 `json:"total,omitempty"`
+	Message  string  `json:"message,omitempty"`
 }
 
 type Response struct {
-	OK         bool          `json:"ok"`
-	Error      string        `json:"error,omitempty"`
-	Status     *Status       `json:"status,omitempty"`
-	Servers    []ServerInfo  `json:"servers,omitempty"`
-	Tools      []ToolInfo    `json:"tools,omitempty"`
-	History    []HistoryItem `json:"history,omitempty"`
-	ToolDetail *ToolDetail   `json:"tool_detail,omitempty"`
-	Result     interface{}   `json:"result,omitempty"`
-	Text       string        `json:"text,omitempty"`
+	OK          bool           `json:"ok"`
+	Heartbeat   bool           `json:"heartbeat,omitempty"`
+	Progress    *Progress      `json:"progress,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Code        string         `json:"code,omitempty"`
+	HTTPStatus  int            `json:"http_status,omitempty"`
+	CallID      string         `json:"call_id,omitempty"`
+	Change      string         `json:"change,omitempty"`
+	Status      *Status        `json:"status,omitempty"`
+	Servers     []ServerInfo   `json:"servers,omitempty"`
+	Tools       []ToolInfo     `json:"tools,omitempty"`
+	Resources   []ResourceInfo `json:"resources,omitempty"`
+	Prompts     []PromptInfo   `json:"prompts,omitempty"`
+	Prompt      *PromptResult  `json:"prompt,omitempty"`
+	History     []HistoryItem  `json:"history,omitempty"`
+	Total       *int           `json:"total,omitempty"`
+	ToolDetail  *ToolDetail    `json:"tool_detail,omitempty"`
+	ToolDetails []ToolDetail   `json:"tool_details,omitempty"`
+	ToolDiff    *ToolDiff      `json:"tool_diff,omitempty"`
+	Stale       bool           `json:"stale,omitempty"`
+	Cached      bool           `json:"cached,omitempty"`
+	RefreshedAt *time.Time     `json:"refreshed_at,omitempty"`
+	Result      interface{}    `json:"result,omitempty"`
+	Exchange    []ExchangeStep `json:"exchange,omitempty"`
+	Connections []ConnStats    `json:"connections,omitempty"`
+	Health      []ServerHealth `json:"health,omitempty"`
+	Text        string         `json:"text,omitempty"`
 }
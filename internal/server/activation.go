@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// systemd hands over inherited sockets starting at this descriptor
+const listenFDsStart = 3
+
+// returns the socket systemd passed in, or nil when the daemon was not
+// socket-activated and has to bind its own
+func inheritedListener() (net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	// stdio servers are started as children; they must not see these
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	if count > 1 {
+		return nil, fmt.Errorf("LISTEN_FDS=%d: mcpshimd listens on one socket", count)
+	}
+	syscall.CloseOnExec(listenFDsStart)
+	file := os.NewFile(listenFDsStart, "systemd-socket")
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket: %w", err)
+	}
+	return ln, nil
+}
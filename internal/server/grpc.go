@@ -0,0 +1,407 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	mcpshimv1 "github.com/prbarcelon/mcpshim/api/mcpshim/v1"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+const grpcServiceName = "mcpshim.v1.Control"
+
+// a grpc method maps its typed messages onto a socket protocol action,
+// see api/mcpshim/v1/control.proto
+type grpcMethod struct {
+	newRequest func() proto.Message
+	request    func(in proto.Message) protocol.Request
+	response   func(resp protocol.Response) (proto.Message, error)
+}
+
+var grpcMethods = map[string]grpcMethod{
+	"Status": {
+		newRequest: func() proto.Message { return &mcpshimv1.StatusRequest{} },
+		request: func(proto.Message) protocol.Request {
+			return protocol.Request{Action: "status"}
+		},
+		response: grpcStatusResponse,
+	},
+	"Servers": {
+		newRequest: func() proto.Message { return &mcpshimv1.ServersRequest{} },
+		request: func(proto.Message) protocol.Request {
+			return protocol.Request{Action: "servers"}
+		},
+		response: grpcServersResponse,
+	},
+	"Tools": {
+		newRequest: func() proto.Message { return &mcpshimv1.ToolsRequest{} },
+		request: func(in proto.Message) protocol.Request {
+			return protocol.Request{Action: "tools", Server: in.(*mcpshimv1.ToolsRequest).GetServer()}
+		},
+		response: grpcToolsResponse,
+	},
+	"Inspect": {
+		newRequest: func() proto.Message { return &mcpshimv1.InspectRequest{} },
+		request: func(in proto.Message) protocol.Request {
+			req := in.(*mcpshimv1.InspectRequest)
+			return protocol.Request{Action: "inspect", Server: req.GetServer(), Tool: req.GetTool()}
+		},
+		response: grpcInspectResponse,
+	},
+	"Call": {
+		newRequest: func() proto.Message { return &mcpshimv1.CallRequest{} },
+		request: func(in proto.Message) protocol.Request {
+			req := in.(*mcpshimv1.CallRequest)
+			return protocol.Request{
+				Action:  "call",
+				ID:      req.GetId(),
+				Server:  req.GetServer(),
+				Tool:    req.GetTool(),
+				Args:    req.GetArgs().AsMap(),
+				NoCache: req.GetNoCache(),
+			}
+		},
+		response: grpcCallResponse,
+	},
+	"History": {
+		newRequest: func() proto.Message { return &mcpshimv1.HistoryRequest{} },
+		request: func(in proto.Message) protocol.Request {
+			req := in.(*mcpshimv1.HistoryRequest)
+			return protocol.Request{
+				Action:    "history",
+				Server:    req.GetServer(),
+				Tool:      req.GetTool(),
+				Limit:     int(req.GetLimit()),
+				BeforeID:  req.GetBeforeId(),
+				AfterID:   req.GetAfterId(),
+				Offset:    int(req.GetOffset()),
+				WithTotal: req.GetWithTotal(),
+			}
+		},
+		response: grpcHistoryResponse,
+	},
+}
+
+type grpcControl interface {
+	handle(ctx context.Context, req protocol.Request) protocol.Response
+}
+
+func (s *Server) serveGRPC(ctx context.Context, addr string) error {
+	token, err := loadGRPCToken(s.cfg.Server.GRPCTokenPath())
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen grpc: %w", err)
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(grpcTokenAuth(token)))
+	desc := grpcServiceDesc()
+	srv.RegisterService(&desc, s)
+	go func() {
+		<-ctx.Done()
+		srv.GracefulStop()
+	}()
+	return srv.Serve(ln)
+}
+
+// any local user can reach a loopback port, so every call has to show the
+// token that only the daemon's user can read
+func grpcTokenAuth(token string) grpc.UnaryServerInterceptor {
+	want := []byte("Bearer " + token)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, value := range md.Get("authorization") {
+			if subtle.ConstantTimeCompare([]byte(value), want) == 1 {
+				return handler(ctx, req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid grpc token")
+	}
+}
+
+// reads the token, creating it on first start; a file other users can
+// read is refused rather than fixed, since the token may already be out
+func loadGRPCToken(path string) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return createGRPCToken(path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("grpc token: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return "", fmt.Errorf("grpc token file %s has mode %04o; it must not be accessible to other users (chmod 600)", path, perm)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("grpc token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("grpc token file %s is empty", path)
+	}
+	return token, nil
+}
+
+func createGRPCToken(path string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate grpc token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("grpc token: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("grpc token: %w", err)
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		return "", fmt.Errorf("grpc token: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("grpc token: %w", err)
+	}
+	return token, nil
+}
+
+func grpcServiceDesc() grpc.ServiceDesc {
+	desc := grpc.ServiceDesc{
+		ServiceName: grpcServiceName,
+		HandlerType: (*grpcControl)(nil),
+		Metadata:    "api/mcpshim/v1/control.proto",
+	}
+	for name, method := range grpcMethods {
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler:    grpcHandler(name, method),
+		})
+	}
+	return desc
+}
+
+func grpcHandler(name string, method grpcMethod) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := method.newRequest()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return grpcDispatch(ctx, srv.(grpcControl), method, req.(proto.Message))
+		}
+		if interceptor == nil {
+			return handler(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcServiceName + "/" + name}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+func grpcDispatch(ctx context.Context, control grpcControl, method grpcMethod, in proto.Message) (proto.Message, error) {
+	req := method.request(in)
+	// ad-hoc servers spawn arbitrary commands or reach arbitrary urls; that
+	// stays on the unix socket, whose file mode limits it to the daemon's user
+	if req.URL != "" || len(req.Command) > 0 {
+		return nil, status.Error(codes.PermissionDenied, "ad-hoc servers are not available over grpc")
+	}
+	resp := control.handle(ctx, req)
+	if !resp.OK {
+		code := codes.Unknown
+		if resp.Code == "args_too_large" {
+			code = codes.InvalidArgument
+		}
+		return nil, status.Error(code, resp.Error)
+	}
+	out, err := method.response(resp)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+func grpcStatusResponse(resp protocol.Response) (proto.Message, error) {
+	st := resp.Status
+	if st == nil {
+		return &mcpshimv1.StatusResponse{}, nil
+	}
+	return &mcpshimv1.StatusResponse{
+		StartedAt:         grpcTime(st.StartedAt),
+		UptimeSec:         st.UptimeSec,
+		ServerCount:       int32(st.ServerCount),
+		ToolCount:         int32(st.ToolCount),
+		LastRefresh:       grpcTime(st.LastRefresh),
+		CachedServerCount: int32(st.CachedServerCount),
+		OpenCircuits:      st.OpenCircuits,
+	}, nil
+}
+
+func grpcServersResponse(resp protocol.Response) (proto.Message, error) {
+	out := &mcpshimv1.ServersResponse{}
+	for _, item := range resp.Servers {
+		info := &mcpshimv1.ServerInfo{
+			Name:                item.Name,
+			Alias:               item.Alias,
+			Url:                 item.URL,
+			Transport:           item.Transport,
+			HasAuth:             item.HasAuth,
+			Command:             item.Command,
+			Disabled:            item.Disabled,
+			ImplName:            item.ImplName,
+			ImplVersion:         item.ImplVersion,
+			ProtocolVersion:     item.ProtocolVersion,
+			Instructions:        item.Instructions,
+			Circuit:             item.Circuit,
+			ConsecutiveFailures: int32(item.ConsecutiveFailures),
+		}
+		if item.LastRefresh != nil {
+			info.LastRefresh = grpcTime(*item.LastRefresh)
+		}
+		out.Servers = append(out.Servers, info)
+	}
+	return out, nil
+}
+
+func grpcToolsResponse(resp protocol.Response) (proto.Message, error) {
+	out := &mcpshimv1.ToolsResponse{Stale: resp.Stale}
+	if resp.RefreshedAt != nil {
+		out.RefreshedAt = grpcTime(*resp.RefreshedAt)
+	}
+	for _, item := range resp.Tools {
+		out.Tools = append(out.Tools, &mcpshimv1.ToolInfo{
+			Server:      item.Server,
+			Name:        item.Name,
+			Description: item.Description,
+			Required:    item.Required,
+			Properties:  item.Properties,
+		})
+	}
+	return out, nil
+}
+
+func grpcInspectResponse(resp protocol.Response) (proto.Message, error) {
+	out := &mcpshimv1.InspectResponse{Stale: resp.Stale}
+	if resp.RefreshedAt != nil {
+		out.RefreshedAt = grpcTime(*resp.RefreshedAt)
+	}
+	if detail := resp.ToolDetail; detail != nil {
+		out.Tool = &mcpshimv1.ToolDetail{
+			Server:           detail.Server,
+			Name:             detail.Name,
+			Description:      detail.Description,
+			Properties:       grpcProperties(detail.Properties),
+			OutputProperties: grpcProperties(detail.OutputProperties),
+		}
+		if len(detail.Meta) > 0 {
+			meta, err := grpcStruct(detail.Meta)
+			if err != nil {
+				return nil, fmt.Errorf("tool _meta: %w", err)
+			}
+			out.Tool.Meta = meta
+		}
+	}
+	return out, nil
+}
+
+func grpcProperties(props []protocol.PropertyDetail) []*mcpshimv1.PropertyDetail {
+	var out []*mcpshimv1.PropertyDetail
+	for _, prop := range props {
+		out = append(out, &mcpshimv1.PropertyDetail{
+			Name:        prop.Name,
+			Type:        prop.Type,
+			Enum:        prop.Enum,
+			Const:       prop.Const,
+			Description: prop.Description,
+			Required:    prop.Required,
+			Items:       prop.Items,
+			Properties:  grpcProperties(prop.Properties),
+		})
+	}
+	return out
+}
+
+func grpcCallResponse(resp protocol.Response) (proto.Message, error) {
+	out := &mcpshimv1.CallResponse{CallId: resp.CallID, Cached: resp.Cached}
+	if resp.Result != nil {
+		// the result is an mcp-go struct, so go through its json form
+		data, err := json.Marshal(resp.Result)
+		if err != nil {
+			return nil, fmt.Errorf("call result: %w", err)
+		}
+		out.Result = &structpb.Value{}
+		if err := out.Result.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("call result: %w", err)
+		}
+	}
+	return out, nil
+}
+
+func grpcHistoryResponse(resp protocol.Response) (proto.Message, error) {
+	out := &mcpshimv1.HistoryResponse{}
+	if resp.Total != nil {
+		out.Total = int64(*resp.Total)
+	}
+	for _, item := range resp.History {
+		entry := &mcpshimv1.HistoryItem{
+			Id:            item.ID,
+			At:            grpcTime(item.At),
+			Server:        item.Server,
+			Tool:          item.Tool,
+			ArgsTruncated: item.ArgsTruncated,
+			Success:       item.Success,
+			Error:         item.Error,
+			DurationMs:    item.DurationMs,
+			ConnectMs:     item.ConnectMs,
+			CallMs:        item.CallMs,
+		}
+		if len(item.Args) > 0 {
+			args, err := grpcStruct(item.Args)
+			if err != nil {
+				return nil, fmt.Errorf("history %d args: %w", item.ID, err)
+			}
+			entry.Args = args
+		}
+		out.History = append(out.History, entry)
+	}
+	return out, nil
+}
+
+// values decoded from json can hold types structpb.NewStruct rejects, such
+// as json.Number, so go through the json form
+func grpcStruct(fields map[string]interface{}) (*structpb.Struct, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := out.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// a zero time means never, which is an unset field rather than year 1
+func grpcTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prbarcelon/mcpshim/internal/config"
+	"github.com/prbarcelon/mcpshim/internal/store"
+)
+
+// history grows slowly, so checking the retention limits hourly is plenty
+const retentionInterval = time.Hour
+
+func (s *Server) runRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		s.applyRetention(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// the store and limits may both change on reload; the read lock is held
+// throughout so reload cannot close the store under a prune
+func (s *Server) applyRetention(ctx context.Context) {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+	s.enforceRetention(ctx, s.store, s.cfg.History.Retention)
+}
+
+func (s *Server) enforceRetention(ctx context.Context, dbStore *store.Store, retention config.HistoryRetention) {
+	if retention.Days > 0 {
+		cutoff := time.Now().UTC().AddDate(0, 0, -retention.Days)
+		n, err := dbStore.PruneHistory(ctx, cutoff)
+		if err != nil {
+			log.Printf("history retention: %v", err)
+		} else if n > 0 && s.debug {
+			log.Printf("history retention: pruned %d entries older than %d days", n, retention.Days)
+		}
+	}
+	if retention.MaxRows > 0 {
+		n, err := dbStore.TrimHistory(ctx, retention.MaxRows)
+		if err != nil {
+			log.Printf("history retention: %v", err)
+		} else if n > 0 && s.debug {
+			log.Printf("history retention: trimmed %d entries beyond %d", n, retention.MaxRows)
+		}
+	}
+}
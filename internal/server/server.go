@@ -3,24 +3,31 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
 	"github.com/prbarcelon/mcpshim/internal/config"
 	"github.com/prbarcelon/mcpshim/internal/mcp"
 	"github.com/prbarcelon/mcpshim/internal/protocol"
 	"github.com/prbarcelon/mcpshim/internal/store"
 )
 
+const heartbeatInterval = 10 * time.Second
+
 type Server struct {
 	configPath string
 	cfg        *config.Config
@@ -28,6 +35,14 @@ type Server struct {
 	store      *store.Store
 	startedAt  time.Time
 	debug      bool
+	readOnly   bool
+
+	// reload swaps cfg and store; the retention goroutine works outside any
+	// request, so it holds this while it uses them
+	stateMu sync.RWMutex
+
+	callsMu sync.Mutex
+	calls   map[string]context.CancelFunc
 }
 
 func New(configPath string, cfg *config.Config) *Server {
@@ -36,11 +51,17 @@ func New(configPath string, cfg *config.Config) *Server {
 		cfg:        cfg,
 		registry:   mcp.NewRegistry(cfg, nil),
 		startedAt:  time.Now().UTC(),
+		calls:      map[string]context.CancelFunc{},
 	}
 }
 
 func (s *Server) SetDebug(debug bool) {
 	s.debug = debug
+	mcp.SetDebug(debug)
+}
+
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
 }
 
 func (s *Server) Run() error {
@@ -52,25 +73,28 @@ func (s *Server) Run() error {
 		s.store = dbStore
 		s.registry = mcp.NewRegistry(s.cfg, s.store)
 	}
+	s.store.SetHistoryMaxArgsBytes(s.cfg.Server.HistoryMaxArgsBytes)
 
 	defer func() {
+		s.registry.Close()
 		if s.store != nil {
 			_ = s.store.Close()
 		}
 	}()
 
-	if err := os.MkdirAll(filepath.Dir(s.cfg.Server.SocketPath), 0o755); err != nil {
-		return err
-	}
-	_ = os.Remove(s.cfg.Server.SocketPath)
-	ln, err := net.Listen("unix", s.cfg.Server.SocketPath)
+	ln, err := inheritedListener()
 	if err != nil {
 		return err
 	}
-	defer ln.Close()
-	if err := os.Chmod(s.cfg.Server.SocketPath, 0o600); err != nil {
-		return err
+	if ln == nil {
+		ln, err = s.listen()
+		if err != nil {
+			return err
+		}
+	} else if s.debug {
+		log.Printf("using socket-activated listener %s", ln.Addr())
 	}
+	defer ln.Close()
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
@@ -89,11 +113,33 @@ func (s *Server) Run() error {
 		}
 	}()
 
+	go s.runRetention(ctx)
+
 	go func() {
 		<-ctx.Done()
 		_ = ln.Close()
 	}()
 
+	if endpoint := s.cfg.Server.OTelEndpoint; endpoint != "" {
+		shutdown, err := setupTracing(ctx, endpoint)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = shutdown(flushCtx)
+		}()
+	}
+
+	if addr := s.cfg.Server.GRPCAddr; addr != "" {
+		go func() {
+			if err := s.serveGRPC(ctx, addr); err != nil {
+				log.Printf("grpc server stopped: %v", err)
+			}
+		}()
+	}
+
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -109,6 +155,22 @@ func (s *Server) Run() error {
 	}
 }
 
+func (s *Server) listen() (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Server.SocketPath), 0o755); err != nil {
+		return nil, err
+	}
+	_ = os.Remove(s.cfg.Server.SocketPath)
+	ln, err := net.Listen("unix", s.cfg.Server.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(s.cfg.Server.SocketPath, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
 	r := bufio.NewReader(conn)
@@ -116,18 +178,181 @@ func (s *Server) handleConn(conn net.Conn) {
 	dec := json.NewDecoder(r)
 	enc := json.NewEncoder(w)
 
-	var req protocol.Request
-	if err := dec.Decode(&req); err != nil {
-		_ = enc.Encode(protocol.Response{OK: false, Error: err.Error()})
-		_ = w.Flush()
+	// a client may send any number of requests, one after the other, and
+	// gets one reply per request; closing after the first reply is fine too
+	for {
+		var req protocol.Request
+		if err := dec.Decode(&req); err != nil {
+			if !errors.Is(err, io.EOF) {
+				_ = enc.Encode(protocol.Response{OK: false, Error: err.Error()})
+				_ = w.Flush()
+			}
+			return
+		}
+		// these two keep the connection for themselves until they finish
+		if req.Action == "subscribe" {
+			s.handleSubscribe(r, w, enc, req)
+			return
+		}
+		if req.Action == "history_export" {
+			s.handleHistoryExport(w, enc, req)
+			return
+		}
+		if !s.serveRequest(w, enc, req) {
+			return
+		}
+	}
+}
+
+// answers one request, reporting false when the client can no longer be
+// written to
+func (s *Server) serveRequest(w *bufio.Writer, enc *json.Encoder, req protocol.Request) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	send := func(resp protocol.Response) bool {
+		if err := enc.Encode(resp); err != nil {
+			cancel()
+			return false
+		}
+		if err := w.Flush(); err != nil {
+			cancel()
+			return false
+		}
+		return true
+	}
+	if !req.Heartbeat && !req.Stream {
+		return send(s.handle(ctx, req))
+	}
+
+	// a call's id goes out in a heartbeat frame as soon as it is known, so a
+	// client that let the daemon pick it can still cancel the call
+	var callStarted chan string
+	if req.Action == "call" {
+		callStarted = make(chan string, 1)
+		ctx = withCallStarted(ctx, func(id string) {
+			select {
+			case callStarted <- id:
+			default:
+			}
+		})
+	}
+
+	// a streaming call gets a progress frame for each update its tool
+	// reports; a slow reader misses some rather than stalling the call
+	var progress chan protocol.Progress
+	if req.Stream {
+		progress = make(chan protocol.Progress, 16)
+		ctx = mcp.WithProgress(ctx, func(update protocol.Progress) {
+			select {
+			case progress <- update:
+			default:
+			}
+		})
+	}
+	// clients that ask for heartbeats get a frame every heartbeatInterval
+	// while the action runs, so a slow tool is not mistaken for a dead daemon;
+	// a frame that cannot be written means the client is gone
+	var heartbeats <-chan time.Time
+	if req.Heartbeat {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+	done := make(chan protocol.Response, 1)
+	go func() { done <- s.handle(ctx, req) }()
+	for {
+		select {
+		case resp := <-done:
+			if len(callStarted) > 0 {
+				if !send(protocol.Response{OK: true, Heartbeat: true, CallID: <-callStarted}) {
+					return false
+				}
+			}
+			for len(progress) > 0 {
+				update := <-progress
+				if !send(protocol.Response{OK: true, Progress: &update}) {
+					return false
+				}
+			}
+			return send(resp)
+		case update := <-progress:
+			if !send(protocol.Response{OK: true, Progress: &update}) {
+				return false
+			}
+		case id := <-callStarted:
+			if !send(protocol.Response{OK: true, Heartbeat: true, CallID: id}) {
+				return false
+			}
+		case <-heartbeats:
+			if !send(protocol.Response{OK: true, Heartbeat: true}) {
+				return false
+			}
+		}
+	}
+}
+
+type callStartedKey struct{}
+
+func withCallStarted(ctx context.Context, started func(id string)) context.Context {
+	return context.WithValue(ctx, callStartedKey{}, started)
+}
+
+func (s *Server) handleSubscribe(r *bufio.Reader, w *bufio.Writer, enc *json.Encoder, req protocol.Request) {
+	var writeMu sync.Mutex
+	send := func(resp protocol.Response) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	if req.Server == "" || req.URI == "" {
+		_ = send(protocol.Response{OK: false, Error: "server and uri are required"})
 		return
 	}
-	resp := s.handle(req)
-	_ = enc.Encode(resp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the subscriber never writes after its request, so any read result
+	// means it hung up
+	go func() {
+		_, _ = r.ReadByte()
+		cancel()
+	}()
+
+	ready := func() {
+		if err := send(protocol.Response{OK: true, Text: fmt.Sprintf("subscribed to %s on %s", req.URI, req.Server)}); err != nil {
+			cancel()
+		}
+	}
+	err := s.registry.Subscribe(ctx, req.Server, req.URI, ready, func(notification mcpproto.JSONRPCNotification) {
+		if err := send(protocol.Response{OK: true, Result: notification}); err != nil {
+			cancel()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		_ = send(protocol.Response{OK: false, Error: err.Error()})
+	}
+}
+
+func (s *Server) handleHistoryExport(w *bufio.Writer, enc *json.Encoder, req protocol.Request) {
+	// one frame per entry, then a closing text frame; a client that hangs up
+	// shows up as a write error and stops the export
+	count := 0
+	err := s.store.StreamHistory(context.Background(), store.HistoryQuery{Server: req.Server, Tool: req.Tool}, func(item protocol.HistoryItem) error {
+		count++
+		return enc.Encode(protocol.Response{OK: true, Result: item})
+	})
+	if err != nil {
+		_ = enc.Encode(protocol.Response{OK: false, Error: err.Error()})
+	} else {
+		_ = enc.Encode(protocol.Response{OK: true, Text: fmt.Sprintf("exported %d history entries", count)})
+	}
 	_ = w.Flush()
 }
 
-func (s *Server) handle(req protocol.Request) protocol.Response {
+func (s *Server) handle(ctx context.Context, req protocol.Request) protocol.Response {
 	switch req.Action {
 	case "status":
 		return protocol.Response{OK: true, Status: &protocol.Status{
@@ -135,46 +360,224 @@ func (s *Server) handle(req protocol.Request) protocol.Response {
 			UptimeSec:   int64(time.Since(s.startedAt).Seconds()),
 			ServerCount: len(s.cfg.Servers),
 			ToolCount:   s.registry.ToolCount(),
+			LastRefresh: s.registry.CacheStamp(),
+
+			CachedServerCount: s.registry.CachedServerCount(),
+
+			OpenCircuits: s.registry.OpenCircuits(),
 		}}
+	case "connections":
+		return protocol.Response{OK: true, Connections: s.registry.ConnStats()}
+	case "health":
+		results, err := s.registry.HealthCheck(ctx, req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		failed := 0
+		for _, result := range results {
+			if !result.OK {
+				failed++
+			}
+		}
+		// a readiness probe wants to fail as soon as any server is down
+		if failed > 0 {
+			return protocol.Response{OK: false, Health: results, Error: fmt.Sprintf("%d of %d server(s) failed the health check", failed, len(results))}
+		}
+		return protocol.Response{OK: true, Health: results}
 	case "servers":
 		return protocol.Response{OK: true, Servers: s.registry.Servers()}
 	case "tools":
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		if req.Count {
+			if items, ok := s.registry.CachedTools(req.Server); ok {
+				stamp := s.registry.CacheStamp()
+				return protocol.Response{OK: true, Tools: items, Stale: true, RefreshedAt: &stamp}
+			}
+		}
+		if req.Detail {
+			if req.Server == "" {
+				return protocol.Response{OK: false, Error: "server is required for tool details"}
+			}
+			details, cachedAt, err := s.registry.InspectTools(ctx, req.Server)
+			if err != nil {
+				return protocol.Response{OK: false, Error: err.Error()}
+			}
+			return withCacheStamp(protocol.Response{OK: true, ToolDetails: details}, cachedAt)
+		}
+		items, cachedAt, err := s.registry.ListTools(ctx, req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		return withCacheStamp(protocol.Response{OK: true, Tools: items}, cachedAt)
+	case "search":
+		items, cachedAt, err := s.registry.SearchTools(req.Query, req.Regex)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		return protocol.Response{OK: true, Tools: items, RefreshedAt: &cachedAt}
+	case "clear_history":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 		defer cancel()
-		items, err := s.registry.ListTools(ctx, req.Server)
+		n, err := s.store.ClearHistory(ctx, req.Server, req.Tool)
 		if err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
-		return protocol.Response{OK: true, Tools: items}
+		return protocol.Response{OK: true, Text: fmt.Sprintf("removed %d history entries", n)}
+	case "tool_diff":
+		if req.Server == "" {
+			return protocol.Response{OK: false, Error: "server is required"}
+		}
+		diff, err := s.registry.ToolDiff(ctx, req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		return protocol.Response{OK: true, ToolDiff: diff}
 	case "history":
 		limit := req.Limit
 		if limit <= 0 {
 			limit = 50
 		}
-		items, err := s.store.ListHistory(req.Server, req.Tool, limit)
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+		defer cancel()
+		q := store.HistoryQuery{
+			Server:   req.Server,
+			Tool:     req.Tool,
+			Limit:    limit,
+			BeforeID: req.BeforeID,
+			AfterID:  req.AfterID,
+			Offset:   req.Offset,
+		}
+		items, err := s.store.ListHistory(ctx, q)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		resp := protocol.Response{OK: true, History: items}
+		if req.WithTotal {
+			total, err := s.store.CountHistory(ctx, q)
+			if err != nil {
+				return protocol.Response{OK: false, Error: err.Error()}
+			}
+			resp.Total = &total
+		}
+		return resp
+	case "resources":
+		if req.Server == "" {
+			return protocol.Response{OK: false, Error: "server is required"}
+		}
+		items, err := s.registry.ListResources(ctx, req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		return protocol.Response{OK: true, Resources: items}
+	case "read_resource":
+		if req.Server == "" || req.URI == "" {
+			return protocol.Response{OK: false, Error: "server and uri are required"}
+		}
+		result, err := s.registry.ReadResource(ctx, req.Server, req.URI)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		return protocol.Response{OK: true, Result: result}
+	case "prompts":
+		if req.Server == "" {
+			return protocol.Response{OK: false, Error: "server is required"}
+		}
+		items, err := s.registry.ListPrompts(ctx, req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		return protocol.Response{OK: true, Prompts: items}
+	case "get_prompt":
+		if req.Server == "" || req.Name == "" {
+			return protocol.Response{OK: false, Error: "server and name are required"}
+		}
+		// prompt arguments are strings in mcp
+		args := make(map[string]string, len(req.Args))
+		for key, value := range req.Args {
+			if text, ok := value.(string); ok {
+				args[key] = text
+			} else {
+				args[key] = fmt.Sprint(value)
+			}
+		}
+		result, err := s.registry.GetPrompt(ctx, req.Server, req.Name, args)
 		if err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
-		return protocol.Response{OK: true, History: items}
+		return protocol.Response{OK: true, Prompt: result}
 	case "inspect":
 		if req.Server == "" || req.Tool == "" {
 			return protocol.Response{OK: false, Error: "server and tool are required"}
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-		detail, err := s.registry.InspectTool(ctx, req.Server, req.Tool)
+		detail, cachedAt, err := s.registry.InspectTool(ctx, req.Server, req.Tool)
 		if err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
-		return protocol.Response{OK: true, ToolDetail: detail}
+		return withCacheStamp(protocol.Response{OK: true, ToolDetail: detail}, cachedAt)
 	case "call":
-		if req.Server == "" || req.Tool == "" {
+		if err := checkArgsSize(req.Args, s.cfg.Server.MaxArgsKeys, s.cfg.Server.MaxArgsBytes); err != nil {
+			return protocol.Response{OK: false, Code: "args_too_large", Error: err.Error()}
+		}
+		if req.NoCache {
+			ctx = mcp.WithoutCache(ctx)
+		}
+		if req.All || len(req.Servers) > 0 {
+			return s.handleFanOut(ctx, req)
+		}
+		adHoc := req.Server == "" && (req.URL != "" || len(req.Command) > 0)
+		if (req.Server == "" && !adHoc) || req.Tool == "" {
 			return protocol.Response{OK: false, Error: "server and tool are required"}
 		}
+		var target config.MCPServer
+		if adHoc {
+			// an ad-hoc target spawns any command or reaches any url, which
+			// is more than add_server could do
+			if s.readOnly {
+				return readOnlyResponse("ad-hoc call")
+			}
+			var err error
+			target, err = adHocServer(req)
+			if err != nil {
+				return protocol.Response{OK: false, Error: err.Error()}
+			}
+			s.cfg.Server.ApplyDefaults(&target)
+			req.Server = target.Name
+		}
+		callID := req.ID
+		if callID == "" {
+			callID = newCallID()
+		}
 		started := time.Now().UTC()
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		// the deadline is the server's own timeout, applied by the registry
+		ctx, cancel := context.WithCancel(ctx)
 		defer cancel()
-		result, err := s.registry.Call(ctx, req.Server, req.Tool, req.Args)
+		if err := s.trackCall(callID, cancel); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		defer s.untrackCall(callID)
+		if started, ok := ctx.Value(callStartedKey{}).(func(string)); ok {
+			started(callID)
+		}
+		if s.debug {
+			log.Printf("call %s started: %s/%s", callID, req.Server, req.Tool)
+		}
+		var exchange *mcp.Exchange
+		if req.Verbose {
+			ctx, exchange = mcp.WithExchange(ctx)
+		}
+		var result interface{}
+		var timing mcp.CallTiming
+		var err error
+		if adHoc {
+			result, timing, err = s.registry.CallServer(ctx, target, req.Tool, req.Args)
+		} else {
+			result, timing, err = s.registry.Call(ctx, req.Server, req.Tool, req.Args)
+		}
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			err = fmt.Errorf("call %s was cancelled", callID)
+		}
 		historyItem := protocol.HistoryItem{
 			At:         started,
 			Server:     req.Server,
@@ -182,19 +585,58 @@ func (s *Server) handle(req protocol.Request) protocol.Response {
 			Args:       req.Args,
 			Success:    err == nil,
 			DurationMs: int64(time.Since(started) / time.Millisecond),
+			ConnectMs:  int64(timing.Connect / time.Millisecond),
+			CallMs:     int64(timing.Call / time.Millisecond),
 		}
 		if err != nil {
 			historyItem.Error = err.Error()
 		}
-		_ = s.store.InsertHistory(historyItem)
+		_ = s.store.InsertHistory(context.Background(), historyItem)
+		resp := protocol.Response{OK: err == nil, Result: result, CallID: callID, Cached: timing.Cached}
+		if exchange != nil {
+			resp.Exchange = exchange.Steps()
+		}
+		if err != nil {
+			resp.Error = err.Error()
+			var httpErr *mcp.HTTPError
+			if errors.As(err, &httpErr) {
+				resp.HTTPStatus = httpErr.StatusCode
+			}
+		}
+		return resp
+	case "rpc":
+		if !s.debug {
+			return protocol.Response{OK: false, Error: "rpc passthrough requires mcpshimd --debug"}
+		}
+		if req.Server == "" || req.Method == "" {
+			return protocol.Response{OK: false, Error: "server and method are required"}
+		}
+		ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
+		result, err := s.registry.RawCall(ctx, req.Server, req.Method, req.Params)
 		if err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
 		return protocol.Response{OK: true, Result: result}
+	case "cancel":
+		if req.ID == "" {
+			return protocol.Response{OK: false, Error: "id is required"}
+		}
+		if !s.cancelCall(req.ID) {
+			return protocol.Response{OK: false, Error: fmt.Sprintf("no running call with id %s", req.ID)}
+		}
+		return protocol.Response{OK: true, Text: fmt.Sprintf("cancelled call %s", req.ID)}
 	case "add_server":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
 		if req.Name == "" {
 			return protocol.Response{OK: false, Error: "name is required"}
 		}
+		headers, err := requestHeaders(req)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
 		transport := strings.ToLower(strings.TrimSpace(req.Transport))
 		if transport == "stdio" {
 			if len(req.Command) == 0 {
@@ -210,22 +652,82 @@ func (s *Server) handle(req protocol.Request) protocol.Response {
 			Alias:     req.Alias,
 			URL:       req.URL,
 			Transport: transport,
-			Headers:   req.Headers,
+			Headers:   headers,
 			Command:   req.Command,
 			Env:       req.Env,
+			Roots:     req.Roots,
+		}
+		if err := config.ValidateServer(item); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if err := config.CheckRoots(req.Roots); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		change := config.UpsertServer(s.cfg, item)
+		if change == config.ServerUnchanged {
+			// provisioning loops re-apply the same entry; skip the write and refresh
+			return protocol.Response{OK: true, Change: string(change), Text: fmt.Sprintf("server %s unchanged", req.Name)}
 		}
-		config.UpsertServer(s.cfg, item)
 		if err := config.Save(s.configPath, s.cfg); err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
 		s.registry.UpdateConfig(s.cfg)
 		_ = s.registry.Refresh(context.Background())
-		return protocol.Response{OK: true, Text: fmt.Sprintf("added server %s", req.Name)}
+		return protocol.Response{OK: true, Change: string(change), Text: fmt.Sprintf("%s server %s", change, req.Name)}
+	case "update_server":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
+		if req.Name == "" {
+			return protocol.Response{OK: false, Error: "name is required"}
+		}
+		headers, err := requestHeaders(req)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if err := rejectCommandHeaders(headers); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		item, err := config.MergeServer(s.cfg, config.MCPServer{
+			Name:      req.Name,
+			Alias:     req.Alias,
+			URL:       req.URL,
+			Transport: strings.ToLower(strings.TrimSpace(req.Transport)),
+			Headers:   headers,
+			Command:   req.Command,
+			Env:       req.Env,
+			Roots:     req.Roots,
+		})
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if err := config.ValidateServer(item); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if err := config.CheckRoots(req.Roots); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if config.UpsertServer(s.cfg, item) == config.ServerUnchanged {
+			return protocol.Response{OK: true, Change: string(config.ServerUnchanged), Text: fmt.Sprintf("server %s unchanged", req.Name)}
+		}
+		if err := config.Save(s.configPath, s.cfg); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		s.registry.UpdateConfig(s.cfg)
+		_ = s.registry.Refresh(context.Background())
+		return protocol.Response{OK: true, Change: string(config.ServerUpdated), Text: fmt.Sprintf("updated server %s", req.Name)}
 	case "remove_server":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
 		if req.Name == "" {
 			return protocol.Response{OK: false, Error: "name is required"}
 		}
-		if !config.RemoveServer(s.cfg, req.Name) {
+		if req.PurgeHistory && !req.Purge {
+			return protocol.Response{OK: false, Error: "purge_history requires purge"}
+		}
+		name, ok := config.RemoveServer(s.cfg, req.Name)
+		if !ok {
 			return protocol.Response{OK: false, Error: "server not found"}
 		}
 		if err := config.Save(s.configPath, s.cfg); err != nil {
@@ -233,18 +735,40 @@ func (s *Server) handle(req protocol.Request) protocol.Response {
 		}
 		s.registry.UpdateConfig(s.cfg)
 		_ = s.registry.Refresh(context.Background())
-		return protocol.Response{OK: true, Text: fmt.Sprintf("removed server %s", req.Name)}
+		if !req.Purge {
+			// tokens and history stay behind, so re-adding the server restores its login
+			return protocol.Response{OK: true, Text: fmt.Sprintf("removed server %s", name)}
+		}
+		purged, err := s.store.DeleteServerData(ctx, name, req.PurgeHistory)
+		if err != nil {
+			return protocol.Response{OK: false, Error: fmt.Sprintf("removed server %s but failed to purge its data: %v", name, err)}
+		}
+		text := fmt.Sprintf("removed server %s; purged %d oauth token(s), %d tool snapshot(s)", name, purged.Tokens, purged.Snapshots)
+		if req.PurgeHistory {
+			text += fmt.Sprintf(", %d history entries", purged.History)
+		}
+		return protocol.Response{OK: true, Text: text}
 	case "set_auth":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
 		if req.Name == "" {
 			return protocol.Response{OK: false, Error: "name is required"}
 		}
+		headers, err := requestHeaders(req)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if err := rejectCommandHeaders(headers); err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
 		updated := false
 		for i := range s.cfg.Servers {
-			if s.cfg.Servers[i].Name == req.Name {
+			if config.SameName(s.cfg.Servers[i].Name, req.Name) {
 				if s.cfg.Servers[i].Headers == nil {
 					s.cfg.Servers[i].Headers = map[string]string{}
 				}
-				for k, v := range req.Headers {
+				for k, v := range headers {
 					s.cfg.Servers[i].Headers[k] = v
 				}
 				updated = true
@@ -259,23 +783,76 @@ func (s *Server) handle(req protocol.Request) protocol.Response {
 		}
 		s.registry.UpdateConfig(s.cfg)
 		return protocol.Response{OK: true, Text: "updated authentication"}
+	case "set_enabled":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
+		if req.Name == "" || req.Enabled == nil {
+			return protocol.Response{OK: false, Error: "name and enabled are required"}
+		}
+		state := "enabled"
+		if !*req.Enabled {
+			state = "disabled"
+		}
+		var item *config.MCPServer
+		for i := range s.cfg.Servers {
+			if config.SameName(s.cfg.Servers[i].Name, req.Name) {
+				item = &s.cfg.Servers[i]
+				break
+			}
+		}
+		if item == nil {
+			return protocol.Response{OK: false, Error: "server not found"}
+		}
+		if item.Disabled == !*req.Enabled {
+			return protocol.Response{OK: true, Change: "unchanged", Text: fmt.Sprintf("server %s is already %s", item.Name, state)}
+		}
+		item.Disabled = !*req.Enabled
+		if err := config.Save(s.configPath, s.cfg); err != nil {
+			item.Disabled = *req.Enabled
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		s.registry.UpdateConfig(s.cfg)
+		_ = s.registry.Refresh(context.Background())
+		return protocol.Response{OK: true, Change: "updated", Text: fmt.Sprintf("%s server %s", state, item.Name)}
+	case "invalidate":
+		name, err := s.registry.Invalidate(req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if name == "" {
+			return protocol.Response{OK: true, Text: "invalidated tool cache for all servers"}
+		}
+		return protocol.Response{OK: true, Text: fmt.Sprintf("invalidated tool cache for %s", name)}
 	case "reload":
-		cfg, err := config.Load(s.configPath)
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
+		load := config.Load
+		if config.Ephemeral() {
+			load = func(string) (*config.Config, error) { return config.LoadEphemeral() }
+		}
+		cfg, err := load(s.configPath)
 		if err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
+		s.stateMu.Lock()
 		if strings.TrimSpace(cfg.Server.DBPath) != strings.TrimSpace(s.cfg.Server.DBPath) {
 			nextStore, openErr := store.Open(cfg.Server.DBPath)
 			if openErr != nil {
+				s.stateMu.Unlock()
 				return protocol.Response{OK: false, Error: openErr.Error()}
 			}
 			if s.store != nil {
 				_ = s.store.Close()
 			}
 			s.store = nextStore
+			s.registry.Close()
 			s.registry = mcp.NewRegistry(cfg, nextStore)
 		}
 		s.cfg = cfg
+		s.store.SetHistoryMaxArgsBytes(cfg.Server.HistoryMaxArgsBytes)
+		s.stateMu.Unlock()
 		s.registry.UpdateConfig(cfg)
 		_ = s.registry.Refresh(context.Background())
 		return protocol.Response{OK: true, Text: "reloaded config"}
@@ -283,13 +860,230 @@ func (s *Server) handle(req protocol.Request) protocol.Response {
 		if req.Server == "" {
 			return protocol.Response{OK: false, Error: "server is required"}
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 6*time.Minute)
+		ctx, cancel := context.WithTimeout(ctx, 6*time.Minute)
 		defer cancel()
 		if err := s.registry.Login(ctx, req.Server, false); err != nil {
 			return protocol.Response{OK: false, Error: err.Error()}
 		}
 		return protocol.Response{OK: true, Text: fmt.Sprintf("oauth login completed for %s", req.Server)}
+	case "logout":
+		if s.readOnly {
+			return readOnlyResponse(req.Action)
+		}
+		if req.Server == "" {
+			return protocol.Response{OK: false, Error: "server is required"}
+		}
+		name, removed, err := s.registry.Logout(ctx, req.Server)
+		if err != nil {
+			return protocol.Response{OK: false, Error: err.Error()}
+		}
+		if !removed {
+			return protocol.Response{OK: true, Text: fmt.Sprintf("no oauth token stored for %s; nothing to remove", name)}
+		}
+		return protocol.Response{OK: true, Text: fmt.Sprintf("removed the oauth token for %s; the next request will need a new login", name)}
 	default:
 		return protocol.Response{OK: false, Error: "unknown action"}
 	}
 }
+
+func adHocServer(req protocol.Request) (config.MCPServer, error) {
+	transport, err := config.NormalizeTransport(req.Transport)
+	if err != nil {
+		return config.MCPServer{}, err
+	}
+	name := req.URL
+	if transport == "stdio" {
+		name = strings.Join(req.Command, " ")
+	}
+	item := config.MCPServer{
+		Name:      name,
+		Alias:     name,
+		URL:       req.URL,
+		Transport: transport,
+		Headers:   req.Headers,
+		Command:   req.Command,
+		Env:       req.Env,
+	}
+	// a 401 comes back as-is; a login would open a browser on the daemon
+	// host for any url a client sends and keep its token under that url
+	noOAuth := false
+	item.OAuthFallback = &noOAuth
+	if err := config.ValidateServer(item); err != nil {
+		return config.MCPServer{}, err
+	}
+	return item, nil
+}
+
+const fanOutConcurrency = 4
+
+func (s *Server) handleFanOut(ctx context.Context, req protocol.Request) protocol.Response {
+	if req.Tool == "" {
+		return protocol.Response{OK: false, Error: "tool is required"}
+	}
+	if req.All && len(req.Servers) > 0 {
+		return protocol.Response{OK: false, Error: "use either all or servers, not both"}
+	}
+	callID := req.ID
+	if callID == "" {
+		callID = newCallID()
+	}
+	// each server's call runs under that server's timeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := s.trackCall(callID, cancel); err != nil {
+		return protocol.Response{OK: false, Error: err.Error()}
+	}
+	defer s.untrackCall(callID)
+
+	targets := req.Servers
+	if req.All {
+		var err error
+		if targets, err = s.registry.ToolServers(ctx, req.Tool); err != nil {
+			return protocol.Response{OK: false, Error: err.Error(), CallID: callID}
+		}
+		if len(targets) == 0 {
+			return protocol.Response{OK: false, Error: fmt.Sprintf("no server exposes tool %q", req.Tool), CallID: callID}
+		}
+	}
+
+	results := make(map[string]protocol.ServerResult, len(targets))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, fanOutConcurrency)
+	for _, server := range targets {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			started := time.Now().UTC()
+			result, timing, err := s.registry.Call(ctx, server, req.Tool, req.Args)
+			entry := protocol.ServerResult{OK: err == nil, Result: result, DurationMs: int64(time.Since(started) / time.Millisecond), Cached: timing.Cached}
+			historyItem := protocol.HistoryItem{
+				At:         started,
+				Server:     server,
+				Tool:       req.Tool,
+				Args:       req.Args,
+				Success:    err == nil,
+				DurationMs: entry.DurationMs,
+				ConnectMs:  int64(timing.Connect / time.Millisecond),
+				CallMs:     int64(timing.Call / time.Millisecond),
+			}
+			if err != nil {
+				entry.Error = err.Error()
+				historyItem.Error = entry.Error
+			}
+			_ = s.store.InsertHistory(context.Background(), historyItem)
+			mu.Lock()
+			results[server] = entry
+			mu.Unlock()
+		}(server)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, entry := range results {
+		if !entry.OK {
+			failed++
+		}
+	}
+	// partial failures are reported per server; only fail the request when nothing succeeded
+	if failed == len(results) {
+		return protocol.Response{OK: false, Error: fmt.Sprintf("%s failed on all %d server(s)", req.Tool, failed), Result: results, CallID: callID}
+	}
+	return protocol.Response{OK: true, Result: results, CallID: callID}
+}
+
+func withCacheStamp(resp protocol.Response, cachedAt time.Time) protocol.Response {
+	// a zero time means the data came live from the server
+	if !cachedAt.IsZero() {
+		resp.Stale = true
+		resp.RefreshedAt = &cachedAt
+	}
+	return resp
+}
+
+func checkArgsSize(args map[string]interface{}, maxKeys, maxBytes int) error {
+	if maxKeys > 0 && len(args) > maxKeys {
+		return fmt.Errorf("args has %d keys, limit is %d (server.max_args_keys)", len(args), maxKeys)
+	}
+	if maxBytes > 0 {
+		raw, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("encode args: %w", err)
+		}
+		if len(raw) > maxBytes {
+			return fmt.Errorf("args is %d bytes, limit is %d (server.max_args_bytes)", len(raw), maxBytes)
+		}
+	}
+	return nil
+}
+
+func readOnlyResponse(action string) protocol.Response {
+	return protocol.Response{OK: false, Code: "read_only", Error: fmt.Sprintf("%s is disabled: mcpshimd is running with --read-only", action)}
+}
+
+func requestHeaders(req protocol.Request) (map[string]string, error) {
+	token := strings.TrimSpace(req.Bearer)
+	if token == "" {
+		return req.Headers, nil
+	}
+	headers := make(map[string]string, len(req.Headers)+1)
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, "Authorization") {
+			return nil, errors.New("use either bearer or an Authorization header, not both")
+		}
+		headers[key] = value
+	}
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = "Bearer " + token
+	}
+	headers["Authorization"] = token
+	return headers, nil
+}
+
+func rejectCommandHeaders(headers map[string]string) error {
+	// a server allowed to run header commands must not have them swapped over the socket
+	for key, value := range headers {
+		if config.IsCommandHeader(value) {
+			return fmt.Errorf("header %s: command headers can only be set in the config file", key)
+		}
+	}
+	return nil
+}
+
+func (s *Server) trackCall(id string, cancel context.CancelFunc) error {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	if _, exists := s.calls[id]; exists {
+		return fmt.Errorf("call id %s is already in use", id)
+	}
+	s.calls[id] = cancel
+	return nil
+}
+
+func (s *Server) untrackCall(id string) {
+	s.callsMu.Lock()
+	defer s.callsMu.Unlock()
+	delete(s.calls, id)
+}
+
+func (s *Server) cancelCall(id string) bool {
+	s.callsMu.Lock()
+	cancel, ok := s.calls[id]
+	s.callsMu.Unlock()
+	if !ok {
+		return false
+	}
+	cancel()
+	return true
+}
+
+func newCallID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,488 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mcpproto "github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+	mcpshimv1 "github.com/prbarcelon/mcpshim/api/mcpshim/v1"
+	"github.com/prbarcelon/mcpshim/internal/config"
+	"github.com/prbarcelon/mcpshim/internal/mcp"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+	"github.com/prbarcelon/mcpshim/internal/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestCheckArgsSizeBoundaries(t *testing.T) {
+	args := map[string]interface{}{"a": "x", "b": "y"}
+	// {"a":"x","b":"y"} encodes to 17 bytes
+	cases := []struct {
+		name     string
+		maxKeys  int
+		maxBytes int
+		wantErr  string
+	}{
+		{name: "unlimited"},
+		{name: "keys at limit", maxKeys: 2},
+		{name: "keys over limit", maxKeys: 1, wantErr: "max_args_keys"},
+		{name: "bytes at limit", maxBytes: 17},
+		{name: "bytes over limit", maxBytes: 16, wantErr: "max_args_bytes"},
+	}
+	for _, tc := range cases {
+		err := checkArgsSize(args, tc.maxKeys, tc.maxBytes)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: expected error mentioning %s, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestCallRejectsOversizedArgs(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{MaxArgsKeys: 1}}
+	s := New("", cfg)
+	resp := s.handle(context.Background(), protocol.Request{
+		Action: "call",
+		Server: "notion",
+		Tool:   "search",
+		Args:   map[string]interface{}{"query": "x", "limit": 5},
+	})
+	if resp.OK || resp.Code != "args_too_large" {
+		t.Fatalf("expected args_too_large, got %+v", resp)
+	}
+}
+
+func TestRequestHeadersBearer(t *testing.T) {
+	headers, err := requestHeaders(protocol.Request{Bearer: "abc123", Headers: map[string]string{"X-Team": "core"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if headers["Authorization"] != "Bearer abc123" || headers["X-Team"] != "core" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+	if headers, _ := requestHeaders(protocol.Request{Bearer: "Bearer abc123"}); headers["Authorization"] != "Bearer abc123" {
+		t.Errorf("expected an existing scheme to be kept, got %q", headers["Authorization"])
+	}
+	if _, err := requestHeaders(protocol.Request{Bearer: "abc123", Headers: map[string]string{"authorization": "Basic x"}}); err == nil {
+		t.Error("expected bearer plus an Authorization header to be rejected")
+	}
+	if !config.MissingAuthScheme(map[string]string{"Authorization": "abc123"}) || config.MissingAuthScheme(headers) {
+		t.Error("expected only the bare token to miss a scheme")
+	}
+}
+
+func TestInheritedListenerIgnoresOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	ln, err := inheritedListener()
+	if err != nil || ln != nil {
+		t.Fatalf("expected no listener for another pid, got %v, %v", ln, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("expected LISTEN_FDS to be cleared so children do not inherit it")
+	}
+}
+
+func TestHandleConnServesSeveralRequests(t *testing.T) {
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "notion", Transport: "http", URL: "https://mcp.notion.com/mcp"}}}
+	s := New("", cfg)
+	client, conn := net.Pipe()
+	finished := make(chan struct{})
+	go func() {
+		s.handleConn(conn)
+		close(finished)
+	}()
+
+	enc := json.NewEncoder(client)
+	dec := json.NewDecoder(client)
+	for i, req := range []protocol.Request{{Action: "servers"}, {Action: "servers", Heartbeat: true}, {Action: "nope"}} {
+		if err := enc.Encode(req); err != nil {
+			t.Fatal(err)
+		}
+		var resp protocol.Response
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if wantOK := req.Action == "servers"; resp.OK != wantOK || (wantOK && len(resp.Servers) != 1) {
+			t.Fatalf("request %d: unexpected response %+v", i, resp)
+		}
+	}
+	_ = client.Close()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed after the client hung up")
+	}
+}
+
+func TestClearHistoryRefusedWhenReadOnly(t *testing.T) {
+	s := New("", &config.Config{})
+	s.SetReadOnly(true)
+	resp := s.handle(context.Background(), protocol.Request{Action: "clear_history", Server: "notion"})
+	if resp.OK || resp.Code != "read_only" {
+		t.Fatalf("expected clear_history to be refused, got %+v", resp)
+	}
+}
+
+func TestReadOnlyRefusesOnlyMutatingActions(t *testing.T) {
+	dbStore, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbStore.Close()
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "notion", Transport: "http", URL: "https://mcp.notion.com/mcp", Disabled: true}}}
+	s := New("", cfg)
+	s.store = dbStore
+	s.SetReadOnly(true)
+	enabled := true
+
+	for _, req := range []protocol.Request{
+		{Action: "add_server", Name: "linear", URL: "https://mcp.linear.app/mcp"},
+		{Action: "update_server", Name: "notion", URL: "https://example.com/mcp"},
+		{Action: "remove_server", Name: "notion"},
+		{Action: "set_auth", Name: "notion", Bearer: "token"},
+		{Action: "set_enabled", Server: "notion", Enabled: &enabled},
+		{Action: "reload"},
+		{Action: "clear_history"},
+		{Action: "logout", Server: "notion"},
+		{Action: "call", Tool: "search", URL: "https://mcp.linear.app/mcp"},
+		{Action: "call", Tool: "search", Command: []string{"sh", "-c", "id"}},
+	} {
+		resp := s.handle(context.Background(), req)
+		if resp.OK || resp.Code != "read_only" {
+			t.Errorf("%s: expected read_only, got %+v", req.Action, resp)
+		}
+	}
+	for _, req := range []protocol.Request{
+		{Action: "status"},
+		{Action: "servers"},
+		{Action: "tools"},
+		{Action: "history"},
+	} {
+		resp := s.handle(context.Background(), req)
+		if !resp.OK {
+			t.Errorf("%s: expected it to keep working, got %+v", req.Action, resp)
+		}
+	}
+}
+
+func TestTrackCallRejectsDuplicateID(t *testing.T) {
+	s := New("", &config.Config{})
+	if err := s.trackCall("nightly", func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.trackCall("nightly", func() {}); err == nil {
+		t.Fatal("expected a second call with the same id to be rejected")
+	}
+	s.untrackCall("nightly")
+	if err := s.trackCall("nightly", func() {}); err != nil {
+		t.Fatalf("expected the id to be free once the call ended, got %v", err)
+	}
+}
+
+func TestCancelAbortsRunningCall(t *testing.T) {
+	release := make(chan struct{})
+	mcpServer := mcpserver.NewMCPServer("test", "1.0.0", mcpserver.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("export"), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		select {
+		case <-ctx.Done():
+		case <-release:
+		}
+		return mcpproto.NewToolResultText("done"), nil
+	})
+	ts := mcpserver.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+	defer close(release)
+
+	dbStore, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbStore.Close()
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "warehouse", Transport: "http", URL: ts.URL + "/mcp"}}}
+	s := New("", cfg)
+	s.store = dbStore
+	s.registry = mcp.NewRegistry(cfg, dbStore)
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConn(conn)
+	if err := json.NewEncoder(client).Encode(protocol.Request{Action: "call", Server: "warehouse", Tool: "export", Heartbeat: true}); err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(client)
+	var first protocol.Response
+	if err := dec.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if !first.Heartbeat || first.CallID == "" {
+		t.Fatalf("expected the call id in the first frame, got %+v", first)
+	}
+
+	if resp := s.handle(context.Background(), protocol.Request{Action: "cancel", ID: first.CallID}); !resp.OK {
+		t.Fatalf("expected cancel to find the call, got %+v", resp)
+	}
+	for {
+		var resp protocol.Response
+		if err := dec.Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Heartbeat {
+			continue
+		}
+		if resp.OK || !strings.Contains(resp.Error, "cancelled") || resp.CallID != first.CallID {
+			t.Fatalf("expected the call to end cancelled, got %+v", resp)
+		}
+		break
+	}
+	if resp := s.handle(context.Background(), protocol.Request{Action: "cancel", ID: first.CallID}); resp.OK {
+		t.Error("expected a finished call to be unknown to cancel")
+	}
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	mcpServer := mcpserver.NewMCPServer("test", "1.0.0", mcpserver.WithToolCapabilities(false))
+	mcpServer.AddTool(mcpproto.NewTool("echo", mcpproto.WithString("text")), func(ctx context.Context, req mcpproto.CallToolRequest) (*mcpproto.CallToolResult, error) {
+		return mcpproto.NewToolResultText(req.GetString("text", "")), nil
+	})
+	ts := mcpserver.NewTestStreamableHTTPServer(mcpServer)
+	defer ts.Close()
+
+	dbStore, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbStore.Close()
+	cfg := &config.Config{Servers: []config.MCPServer{{Name: "warehouse", Transport: "http", URL: ts.URL + "/mcp"}}}
+	s := New("", cfg)
+	s.store = dbStore
+	s.registry = mcp.NewRegistry(cfg, dbStore)
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(grpcTokenAuth("secret")))
+	desc := grpcServiceDesc()
+	srv.RegisterService(&desc, s)
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	invoke := func(ctx context.Context, method string, in, out proto.Message) error {
+		return conn.Invoke(ctx, "/"+grpcServiceName+"/"+method, in, out)
+	}
+
+	ctx := context.Background()
+	for _, bad := range []context.Context{ctx, metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer wrong")} {
+		err := invoke(bad, "Status", &mcpshimv1.StatusRequest{}, &mcpshimv1.StatusResponse{})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected Unauthenticated without the token, got %v", err)
+		}
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	st := &mcpshimv1.StatusResponse{}
+	if err := invoke(ctx, "Status", &mcpshimv1.StatusRequest{}, st); err != nil {
+		t.Fatal(err)
+	}
+	if st.GetServerCount() != 1 || st.GetStartedAt() == nil {
+		t.Errorf("unexpected status: %v", st)
+	}
+	servers := &mcpshimv1.ServersResponse{}
+	if err := invoke(ctx, "Servers", &mcpshimv1.ServersRequest{}, servers); err != nil {
+		t.Fatal(err)
+	}
+	if len(servers.GetServers()) != 1 || servers.GetServers()[0].GetName() != "warehouse" {
+		t.Errorf("unexpected servers: %v", servers)
+	}
+
+	args, _ := structpb.NewStruct(map[string]interface{}{"text": "hi"})
+	call := &mcpshimv1.CallResponse{}
+	if err := invoke(ctx, "Call", &mcpshimv1.CallRequest{Server: "warehouse", Tool: "echo", Args: args}, call); err != nil {
+		t.Fatal(err)
+	}
+	content := call.GetResult().GetStructValue().GetFields()["content"].GetListValue().GetValues()
+	if len(content) != 1 || content[0].GetStructValue().GetFields()["text"].GetStringValue() != "hi" || call.GetCallId() == "" {
+		t.Errorf("unexpected call response: %v", call)
+	}
+	err = invoke(ctx, "Call", &mcpshimv1.CallRequest{Server: "warehouse"}, &mcpshimv1.CallResponse{})
+	if status.Code(err) != codes.Unknown || !strings.Contains(err.Error(), "server and tool are required") {
+		t.Errorf("expected the handler error as a status, got %v", err)
+	}
+
+	history := &mcpshimv1.HistoryResponse{}
+	if err := invoke(ctx, "History", &mcpshimv1.HistoryRequest{WithTotal: true}, history); err != nil {
+		t.Fatal(err)
+	}
+	if len(history.GetHistory()) != 1 || history.GetTotal() != 1 || history.GetHistory()[0].GetArgs().GetFields()["text"].GetStringValue() != "hi" {
+		t.Errorf("unexpected history: %v", history)
+	}
+}
+
+func TestGRPCDispatchRejectsAdHocServers(t *testing.T) {
+	s := New("", &config.Config{})
+	for _, req := range []protocol.Request{
+		{Action: "call", Tool: "search", URL: "https://evil.example/mcp"},
+		{Action: "call", Tool: "search", Command: []string{"sh", "-c", "id"}},
+	} {
+		method := grpcMethod{
+			request:  func(proto.Message) protocol.Request { return req },
+			response: grpcCallResponse,
+		}
+		_, err := grpcDispatch(context.Background(), s, method, &mcpshimv1.CallRequest{})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("expected PermissionDenied for %+v, got %v", req, err)
+		}
+	}
+}
+
+func TestLoadGRPCToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "grpc.token")
+	token, err := loadGRPCToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 || len(token) != 64 {
+		t.Fatalf("expected a 0600 file with a 64 character token, got mode %04o and %q", info.Mode().Perm(), token)
+	}
+	again, err := loadGRPCToken(path)
+	if err != nil || again != token {
+		t.Fatalf("expected the same token on the next start, got %q, %v", again, err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadGRPCToken(path); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Errorf("expected a world-readable token file to be refused, got %v", err)
+	}
+}
+
+func TestAdHocCallSkipsOAuthLogin(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer resource_metadata="`+"http://"+r.Host+`/.well-known/oauth-protected-resource"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	dbStore, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbStore.Close()
+	cfg := &config.Config{}
+	s := New("", cfg)
+	s.store = dbStore
+	s.registry = mcp.NewRegistry(cfg, dbStore)
+
+	resp := s.handle(context.Background(), protocol.Request{Action: "call", URL: ts.URL + "/mcp", Transport: "http", Tool: "search"})
+	if resp.OK || resp.HTTPStatus != http.StatusUnauthorized {
+		t.Fatalf("expected the upstream 401, got %+v", resp)
+	}
+	if token, err := dbStore.GetToken(context.Background(), ts.URL+"/mcp"); err != nil || token != nil {
+		t.Errorf("expected no token stored for the ad-hoc url, got %v, %v", token, err)
+	}
+}
+
+func TestApplyRetentionUsesConfiguredLimits(t *testing.T) {
+	dbStore, err := store.Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbStore.Close()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, at := range []time.Time{now.AddDate(0, 0, -30), now.Add(-2 * time.Hour), now.Add(-time.Hour)} {
+		if err := dbStore.InsertHistory(ctx, protocol.HistoryItem{At: at, Server: "notion", Tool: "search", Success: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{History: config.HistoryConfig{Retention: config.HistoryRetention{Days: 7}}}
+	s := New("", cfg)
+	s.store = dbStore
+
+	s.applyRetention(ctx)
+	if n, _ := dbStore.CountHistory(ctx, store.HistoryQuery{}); n != 2 {
+		t.Fatalf("expected days to drop the month-old entry, got %d left", n)
+	}
+	cfg.History.Retention.MaxRows = 1
+	s.applyRetention(ctx)
+	items, err := dbStore.ListHistory(ctx, store.HistoryQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || !items[0].At.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("expected max_rows to keep only the newest entry, got %+v", items)
+	}
+}
+
+func TestReloadWhileRetentionRuns(t *testing.T) {
+	dir := t.TempDir()
+	retention := config.HistoryConfig{Retention: config.HistoryRetention{Days: 7, MaxRows: 100}}
+	dbPath := func(i int) string { return filepath.Join(dir, fmt.Sprintf("mcpshim-%d.db", i)) }
+	first, err := store.Open(dbPath(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	s := New(configPath, &config.Config{Server: config.ServerConfig{DBPath: dbPath(0)}, History: retention})
+	s.store = first
+	defer func() { s.store.Close() }()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			s.applyRetention(ctx)
+		}
+	}()
+	for i := 1; i <= 5; i++ {
+		next := &config.Config{Server: config.ServerConfig{SocketPath: filepath.Join(dir, "mcpshim.sock"), DBPath: dbPath(i)}, History: retention}
+		if err := config.Save(configPath, next); err != nil {
+			t.Fatal(err)
+		}
+		if resp := s.handle(context.Background(), protocol.Request{Action: "reload"}); !resp.OK {
+			t.Fatalf("reload failed: %+v", resp)
+		}
+	}
+	cancel()
+	<-done
+	if strings.Contains(logs.String(), "database is closed") {
+		t.Fatalf("retention ran on a store that reload had closed:\n%s", logs.String())
+	}
+}
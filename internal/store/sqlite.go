@@ -1,12 +1,16 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	mcpclient "github.com/mark3labs/mcp-go/client"
 	"github.com/prbarcelon/mcpshim/internal/protocol"
@@ -14,8 +18,14 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const (
+	truncatedArgsBytesKey   = "_truncated_bytes"
+	truncatedArgsPreviewKey = "_preview"
+)
+
 type Store struct {
-	db *sql.DB
+	db           *sql.DB
+	maxArgsBytes int
 }
 
 func Open(path string) (*Store, error) {
@@ -38,6 +48,35 @@ func Open(path string) (*Store, error) {
 	return s, nil
 }
 
+// for reading a copied or archived db: nothing is created or migrated, and
+// sqlite refuses every write
+func OpenReadOnly(path string) (*Store, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return nil, fmt.Errorf("open sqlite db: %w", err)
+	}
+	dsn := (&url.URL{Scheme: "file", Path: abs, RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite db: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open sqlite db: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) SetHistoryMaxArgsBytes(limit int) {
+	if s == nil {
+		return
+	}
+	s.maxArgsBytes = limit
+}
+
 func (s *Store) Close() error {
 	if s == nil || s.db == nil {
 		return nil
@@ -55,7 +94,9 @@ CREATE TABLE IF NOT EXISTS call_history (
 	args_json TEXT,
 	success INTEGER NOT NULL,
 	error TEXT,
-	duration_ms INTEGER NOT NULL
+	duration_ms INTEGER NOT NULL,
+	connect_ms INTEGER NOT NULL DEFAULT 0,
+	call_ms INTEGER NOT NULL DEFAULT 0
 );
 
 CREATE INDEX IF NOT EXISTS idx_call_history_at ON call_history(at_utc, id);
@@ -67,14 +108,56 @@ CREATE TABLE IF NOT EXISTS oauth_tokens (
 	token_json TEXT NOT NULL,
 	updated_at_utc TEXT NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS tool_snapshots (
+	server TEXT PRIMARY KEY,
+	current_json TEXT NOT NULL,
+	previous_json TEXT,
+	changed_at_utc TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tool_cache (
+	server TEXT PRIMARY KEY,
+	tools_json TEXT NOT NULL,
+	fetched_at_utc TEXT NOT NULL
+);
 `)
 	if err != nil {
 		return fmt.Errorf("init sqlite schema: %w", err)
 	}
+	for _, column := range []string{"connect_ms", "call_ms"} {
+		if err := s.addColumnIfMissing("call_history", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) addColumnIfMissing(table string, column string, definition string) error {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("inspect %s schema: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("inspect %s schema: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect %s schema: %w", table, err)
+	}
+	if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("add %s.%s column: %w", table, column, err)
+	}
 	return nil
 }
 
-func (s *Store) InsertHistory(item protocol.HistoryItem) error {
+func (s *Store) InsertHistory(ctx context.Context, item protocol.HistoryItem) error {
 	var argsJSON string
 	if len(item.Args) > 0 {
 		data, err := json.Marshal(item.Args)
@@ -82,11 +165,17 @@ func (s *Store) InsertHistory(item protocol.HistoryItem) error {
 			return fmt.Errorf("marshal history args: %w", err)
 		}
 		argsJSON = string(data)
+		if s.maxArgsBytes > 0 && len(data) > s.maxArgsBytes {
+			argsJSON, err = truncateArgsJSON(data, s.maxArgsBytes)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	_, err := s.db.Exec(`
-INSERT INTO call_history (at_utc, server, tool, args_json, success, error, duration_ms)
-VALUES (?, ?, ?, ?, ?, ?, ?)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO call_history (at_utc, server, tool, args_json, success, error, duration_ms, connect_ms, call_ms)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 `,
 		item.At.UTC().Format(time.RFC3339Nano),
 		item.Server,
@@ -95,6 +184,8 @@ VALUES (?, ?, ?, ?, ?, ?, ?)
 		boolToInt(item.Success),
 		item.Error,
 		item.DurationMs,
+		item.ConnectMs,
+		item.CallMs,
 	)
 	if err != nil {
 		return fmt.Errorf("insert history: %w", err)
@@ -102,7 +193,32 @@ VALUES (?, ?, ?, ?, ?, ?, ?)
 	return nil
 }
 
-func (s *Store) ListHistory(serverFilter string, toolFilter string, limit int) ([]protocol.HistoryItem, error) {
+type HistoryQuery struct {
+	Server   string
+	Tool     string
+	Limit    int
+	BeforeID int64
+	AfterID  int64
+	Offset   int
+}
+
+// server and tool filters only; cursors and offsets pick a page, not the set
+func (q HistoryQuery) filters() ([]string, []any) {
+	conds := []string{}
+	args := []any{}
+	if q.Server != "" {
+		conds = append(conds, "server = ?")
+		args = append(args, q.Server)
+	}
+	if q.Tool != "" {
+		conds = append(conds, "tool = ?")
+		args = append(args, q.Tool)
+	}
+	return conds, args
+}
+
+func (s *Store) ListHistory(ctx context.Context, q HistoryQuery) ([]protocol.HistoryItem, error) {
+	limit := q.Limit
 	if limit <= 0 {
 		limit = 50
 	}
@@ -110,80 +226,205 @@ func (s *Store) ListHistory(serverFilter string, toolFilter string, limit int) (
 		limit = 500
 	}
 
-	query := `SELECT at_utc, server, tool, args_json, success, error, duration_ms FROM call_history`
-	args := make([]any, 0, 3)
-	where := ""
-	if serverFilter != "" {
-		where += " server = ?"
-		args = append(args, serverFilter)
+	query := `SELECT id, at_utc, server, tool, args_json, success, error, duration_ms, connect_ms, call_ms FROM call_history`
+	conds, args := q.filters()
+	if q.BeforeID > 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, q.BeforeID)
+	}
+	if q.AfterID > 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, q.AfterID)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	// after a cursor the page is the entries right after it, not the newest
+	order := "DESC"
+	if q.AfterID > 0 {
+		order = "ASC"
+	}
+	query += " ORDER BY id " + order + " LIMIT ? OFFSET ?"
+	args = append(args, limit, max(q.Offset, 0))
+
+	out, err := s.queryHistory(ctx, query, args...)
+	if err != nil {
+		return nil, err
 	}
-	if toolFilter != "" {
-		if where != "" {
-			where += " AND"
+
+	if order == "DESC" {
+		for left, right := 0, len(out)-1; left < right; left, right = left+1, right-1 {
+			out[left], out[right] = out[right], out[left]
 		}
-		where += " tool = ?"
-		args = append(args, toolFilter)
 	}
-	if where != "" {
-		query += " WHERE" + where
+
+	return out, nil
+}
+
+func (s *Store) CountHistory(ctx context.Context, q HistoryQuery) (int, error) {
+	query := `SELECT COUNT(*) FROM call_history`
+	conds, args := q.filters()
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	var total int
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("count history: %w", err)
+	}
+	return total, nil
+}
+
+// deletes the history matching the same server and tool filters as
+// ListHistory, all of it when both are empty, and returns how many rows went
+func (s *Store) ClearHistory(ctx context.Context, server string, tool string) (int, error) {
+	query := `DELETE FROM call_history`
+	conds, args := HistoryQuery{Server: server, Tool: tool}.filters()
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("clear history: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// deleting fewer rows than this leaves the freed pages for new history
+// instead of rewriting the whole file
+const historyVacuumRows = 10000
+
+// deletes history recorded before olderThan and returns how many rows went
+func (s *Store) PruneHistory(ctx context.Context, olderThan time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM call_history WHERE at_utc < ?`, olderThan.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, fmt.Errorf("prune history: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), s.vacuumAfter(ctx, n)
+}
+
+// keeps the newest maxRows history entries and returns how many rows went
+func (s *Store) TrimHistory(ctx context.Context, maxRows int) (int, error) {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM call_history
+WHERE id <= (SELECT id FROM call_history ORDER BY id DESC LIMIT 1 OFFSET ?)
+`, maxRows)
+	if err != nil {
+		return 0, fmt.Errorf("trim history: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), s.vacuumAfter(ctx, n)
+}
+
+func (s *Store) vacuumAfter(ctx context.Context, deleted int64) error {
+	if deleted < historyVacuumRows {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
+
+var historyStreamBatch = 500
+
+func (s *Store) StreamHistory(ctx context.Context, q HistoryQuery, fn func(protocol.HistoryItem) error) error {
+	// oldest first, in batches keyed on id so memory stays flat and rows
+	// inserted during the export are still picked up
+	var lastID int64
+	for {
+		query := `SELECT id, at_utc, server, tool, args_json, success, error, duration_ms, connect_ms, call_ms FROM call_history WHERE id > ?`
+		args := []any{lastID}
+		if q.Server != "" {
+			query += " AND server = ?"
+			args = append(args, q.Server)
+		}
+		if q.Tool != "" {
+			query += " AND tool = ?"
+			args = append(args, q.Tool)
+		}
+		query += " ORDER BY id ASC LIMIT ?"
+		args = append(args, historyStreamBatch)
+
+		batch, err := s.queryHistory(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for _, item := range batch {
+			if err := fn(item); err != nil {
+				return err
+			}
+			lastID = item.ID
+		}
+		if len(batch) < historyStreamBatch {
+			return nil
+		}
 	}
-	query += " ORDER BY id DESC LIMIT ?"
-	args = append(args, limit)
+}
 
-	rows, err := s.db.Query(query, args...)
+func (s *Store) queryHistory(ctx context.Context, query string, args ...any) ([]protocol.HistoryItem, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list history: %w", err)
 	}
 	defer rows.Close()
-
-	out := make([]protocol.HistoryItem, 0, limit)
+	out := []protocol.HistoryItem{}
 	for rows.Next() {
-		var atUTC string
-		var server string
-		var tool string
-		var argsJSON string
-		var success int
-		var errText sql.NullString
-		var durationMs int64
-		if err := rows.Scan(&atUTC, &server, &tool, &argsJSON, &success, &errText, &durationMs); err != nil {
-			return nil, fmt.Errorf("scan history: %w", err)
-		}
-		at, err := time.Parse(time.RFC3339Nano, atUTC)
+		item, err := scanHistoryRow(rows)
 		if err != nil {
-			at = time.Now().UTC()
-		}
-		item := protocol.HistoryItem{
-			At:         at,
-			Server:     server,
-			Tool:       tool,
-			Success:    success == 1,
-			DurationMs: durationMs,
-		}
-		if errText.Valid {
-			item.Error = errText.String
-		}
-		if argsJSON != "" {
-			argsMap := map[string]interface{}{}
-			if err := json.Unmarshal([]byte(argsJSON), &argsMap); err == nil {
-				item.Args = argsMap
-			}
+			return nil, err
 		}
 		out = append(out, item)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterate history: %w", err)
 	}
+	return out, nil
+}
 
-	for left, right := 0, len(out)-1; left < right; left, right = left+1, right-1 {
-		out[left], out[right] = out[right], out[left]
+func scanHistoryRow(rows *sql.Rows) (protocol.HistoryItem, error) {
+	var id int64
+	var atUTC string
+	var server string
+	var tool string
+	var argsJSON string
+	var success int
+	var errText sql.NullString
+	var durationMs, connectMs, callMs int64
+	if err := rows.Scan(&id, &atUTC, &server, &tool, &argsJSON, &success, &errText, &durationMs, &connectMs, &callMs); err != nil {
+		return protocol.HistoryItem{}, fmt.Errorf("scan history: %w", err)
 	}
-
-	return out, nil
+	at, err := time.Parse(time.RFC3339Nano, atUTC)
+	if err != nil {
+		at = time.Now().UTC()
+	}
+	item := protocol.HistoryItem{
+		ID:         id,
+		At:         at,
+		Server:     server,
+		Tool:       tool,
+		Success:    success == 1,
+		DurationMs: durationMs,
+		ConnectMs:  connectMs,
+		CallMs:     callMs,
+	}
+	if errText.Valid {
+		item.Error = errText.String
+	}
+	if argsJSON != "" {
+		argsMap := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(argsJSON), &argsMap); err == nil {
+			item.Args = argsMap
+			_, item.ArgsTruncated = argsMap[truncatedArgsBytesKey]
+		}
+	}
+	return item, nil
 }
 
-func (s *Store) GetToken(server string) (*mcpclient.Token, error) {
+func (s *Store) GetToken(ctx context.Context, server string) (*mcpclient.Token, error) {
 	var tokenJSON string
-	err := s.db.QueryRow(`SELECT token_json FROM oauth_tokens WHERE server = ?`, server).Scan(&tokenJSON)
+	err := s.db.QueryRowContext(ctx, `SELECT token_json FROM oauth_tokens WHERE server = ?`, server).Scan(&tokenJSON)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -200,7 +441,7 @@ func (s *Store) GetToken(server string) (*mcpclient.Token, error) {
 	return &token, nil
 }
 
-func (s *Store) SaveToken(server string, token *mcpclient.Token) error {
+func (s *Store) SaveToken(ctx context.Context, server string, token *mcpclient.Token) error {
 	if token == nil {
 		return fmt.Errorf("token is required")
 	}
@@ -208,7 +449,7 @@ func (s *Store) SaveToken(server string, token *mcpclient.Token) error {
 	if err != nil {
 		return fmt.Errorf("encode token: %w", err)
 	}
-	_, err = s.db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
 INSERT INTO oauth_tokens (server, token_json, updated_at_utc)
 VALUES (?, ?, ?)
 ON CONFLICT(server) DO UPDATE SET token_json=excluded.token_json, updated_at_utc=excluded.updated_at_utc
@@ -219,6 +460,139 @@ ON CONFLICT(server) DO UPDATE SET token_json=excluded.token_json, updated_at_utc
 	return nil
 }
 
+// reports whether a token was stored, so logout can say when there was none
+func (s *Store) DeleteToken(ctx context.Context, server string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM oauth_tokens WHERE server = ?`, server)
+	if err != nil {
+		return false, fmt.Errorf("delete token: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+type PurgeResult struct {
+	Tokens    int64
+	Snapshots int64
+	History   int64
+}
+
+func (s *Store) DeleteServerData(ctx context.Context, server string, includeHistory bool) (PurgeResult, error) {
+	var result PurgeResult
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("purge server data: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// the cached tool list goes with the snapshots but is not counted separately
+	tables := []string{"oauth_tokens", "tool_snapshots", "tool_cache"}
+	counts := []*int64{&result.Tokens, &result.Snapshots, nil}
+	if includeHistory {
+		tables = append(tables, "call_history")
+		counts = append(counts, &result.History)
+	}
+	for i, table := range tables {
+		res, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE server = ?`, server)
+		if err != nil {
+			return PurgeResult{}, fmt.Errorf("purge server data: %w", err)
+		}
+		if counts[i] != nil {
+			*counts[i], _ = res.RowsAffected()
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return PurgeResult{}, fmt.Errorf("purge server data: %w", err)
+	}
+	return result, nil
+}
+
+func truncateArgsJSON(data []byte, limit int) (string, error) {
+	preview := string(data[:limit])
+	for len(preview) > 0 && !utf8.ValidString(preview) {
+		preview = preview[:len(preview)-1]
+	}
+	marker, err := json.Marshal(map[string]interface{}{
+		truncatedArgsBytesKey:   len(data),
+		truncatedArgsPreviewKey: preview,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal truncated history args: %w", err)
+	}
+	return string(marker), nil
+}
+
+func (s *Store) SaveToolSnapshot(ctx context.Context, server string, snapshot map[string]string) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("encode tool snapshot: %w", err)
+	}
+	// only rotate current into previous when the tool set actually changed, so
+	// a diff keeps showing the last real change across unchanged refreshes
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO tool_snapshots (server, current_json, previous_json, changed_at_utc)
+VALUES (?, ?, NULL, ?)
+ON CONFLICT(server) DO UPDATE SET
+	previous_json=tool_snapshots.current_json,
+	current_json=excluded.current_json,
+	changed_at_utc=excluded.changed_at_utc
+WHERE tool_snapshots.current_json != excluded.current_json
+`, server, string(data), time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("save tool snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) GetToolSnapshots(ctx context.Context, server string) (previous map[string]string, current map[string]string, changedAt time.Time, err error) {
+	var currentJSON string
+	var previousJSON sql.NullString
+	var changedAtUTC string
+	err = s.db.QueryRowContext(ctx, `SELECT current_json, previous_json, changed_at_utc FROM tool_snapshots WHERE server = ?`, server).Scan(&currentJSON, &previousJSON, &changedAtUTC)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, time.Time{}, nil
+		}
+		return nil, nil, time.Time{}, fmt.Errorf("get tool snapshot: %w", err)
+	}
+	if err := json.Unmarshal([]byte(currentJSON), &current); err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("decode tool snapshot: %w", err)
+	}
+	if previousJSON.Valid && previousJSON.String != "" {
+		if err := json.Unmarshal([]byte(previousJSON.String), &previous); err != nil {
+			return nil, nil, time.Time{}, fmt.Errorf("decode tool snapshot: %w", err)
+		}
+	}
+	changedAt, _ = time.Parse(time.RFC3339Nano, changedAtUTC)
+	return previous, current, changedAt, nil
+}
+
+func (s *Store) SaveToolCache(ctx context.Context, server string, toolsJSON string) error {
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO tool_cache (server, tools_json, fetched_at_utc)
+VALUES (?, ?, ?)
+ON CONFLICT(server) DO UPDATE SET
+	tools_json=excluded.tools_json,
+	fetched_at_utc=excluded.fetched_at_utc
+`, server, toolsJSON, time.Now().UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("save tool cache: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) GetToolCache(ctx context.Context, server string) (toolsJSON string, fetchedAt time.Time, err error) {
+	var fetchedAtUTC string
+	err = s.db.QueryRowContext(ctx, `SELECT tools_json, fetched_at_utc FROM tool_cache WHERE server = ?`, server).Scan(&toolsJSON, &fetchedAtUTC)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", time.Time{}, nil
+		}
+		return "", time.Time{}, fmt.Errorf("get tool cache: %w", err)
+	}
+	fetchedAt, _ = time.Parse(time.RFC3339Nano, fetchedAtUTC)
+	return toolsJSON, fetchedAt, nil
+}
+
 func boolToInt(value bool) int {
 	if value {
 		return 1
@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/prbarcelon/mcpshim/internal/protocol"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "mcpshim.db"))
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func insertCalls(t *testing.T, s *Store, n int) {
+	t.Helper()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		item := protocol.HistoryItem{At: start.Add(time.Duration(i) * time.Minute), Server: "notion", Tool: "search", Success: true}
+		if err := s.InsertHistory(context.Background(), item); err != nil {
+			t.Fatalf("insert history: %v", err)
+		}
+	}
+}
+
+func historyIDs(items []protocol.HistoryItem) []int64 {
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestListHistoryPaging(t *testing.T) {
+	s := openTestStore(t)
+	insertCalls(t, s, 5)
+
+	latest, err := s.ListHistory(context.Background(), HistoryQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if got := historyIDs(latest); len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Fatalf("unexpected latest page: %v", got)
+	}
+
+	second, err := s.ListHistory(context.Background(), HistoryQuery{Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if got := historyIDs(second); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Fatalf("unexpected second page: %v", got)
+	}
+
+	older, err := s.ListHistory(context.Background(), HistoryQuery{Limit: 10, BeforeID: 3})
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if got := historyIDs(older); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("unexpected cursor page: %v", got)
+	}
+
+	newer, err := s.ListHistory(context.Background(), HistoryQuery{Limit: 2, AfterID: 2})
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if got := historyIDs(newer); len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Fatalf("expected the entries right after the cursor, got %v", got)
+	}
+
+	total, err := s.CountHistory(context.Background(), HistoryQuery{Server: "notion", Limit: 2, AfterID: 2})
+	if err != nil {
+		t.Fatalf("count history: %v", err)
+	}
+	if total != 5 {
+		t.Fatalf("expected the total to ignore the cursor, got %d", total)
+	}
+}
+
+func TestDeleteServerData(t *testing.T) {
+	s := openTestStore(t)
+	insertCalls(t, s, 3)
+	if err := s.SaveToken(context.Background(), "notion", &mcpclient.Token{AccessToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := s.DeleteServerData(context.Background(), "notion", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged.Tokens != 1 || purged.History != 0 {
+		t.Errorf("expected only the token to be purged, got %+v", purged)
+	}
+	if token, _ := s.GetToken(context.Background(), "notion"); token != nil {
+		t.Error("expected token to be deleted")
+	}
+
+	purged, err = s.DeleteServerData(context.Background(), "notion", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged.History != 3 {
+		t.Errorf("expected 3 history rows purged, got %+v", purged)
+	}
+}
+
+func TestPruneAndTrimHistory(t *testing.T) {
+	s := openTestStore(t)
+	insertCalls(t, s, 10)
+	ctx := context.Background()
+
+	// insertCalls spaces entries a minute apart from 2026-01-01 00:00
+	pruned, err := s.PruneHistory(ctx, time.Date(2026, 1, 1, 0, 3, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 3 {
+		t.Errorf("expected 3 entries pruned, got %d", pruned)
+	}
+	trimmed, err := s.TrimHistory(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trimmed != 3 {
+		t.Errorf("expected 3 entries trimmed, got %d", trimmed)
+	}
+	items, err := s.ListHistory(ctx, HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := historyIDs(items); !slices.Equal(got, []int64{7, 8, 9, 10}) {
+		t.Errorf("expected the newest 4 entries to remain, got %v", got)
+	}
+	if trimmed, _ := s.TrimHistory(ctx, 4); trimmed != 0 {
+		t.Errorf("expected nothing to trim at the limit, got %d", trimmed)
+	}
+}
+
+func TestClearHistory(t *testing.T) {
+	s := openTestStore(t)
+	insertCalls(t, s, 3)
+	ctx := context.Background()
+	if err := s.InsertHistory(ctx, protocol.HistoryItem{At: time.Now(), Server: "github", Tool: "search", Success: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := s.ClearHistory(ctx, "notion", "fetch"); err != nil || n != 0 {
+		t.Fatalf("expected no entries for an unused tool, got %d, %v", n, err)
+	}
+	if n, err := s.ClearHistory(ctx, "notion", ""); err != nil || n != 3 {
+		t.Fatalf("expected 3 notion entries cleared, got %d, %v", n, err)
+	}
+	if total, _ := s.CountHistory(ctx, HistoryQuery{}); total != 1 {
+		t.Errorf("expected the github entry to remain, got %d entries", total)
+	}
+	if n, err := s.ClearHistory(ctx, "", ""); err != nil || n != 1 {
+		t.Errorf("expected the rest cleared, got %d, %v", n, err)
+	}
+}
+
+func TestDeleteToken(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.SaveToken(context.Background(), "notion", &mcpclient.Token{AccessToken: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := s.DeleteToken(context.Background(), "notion"); err != nil || !removed {
+		t.Fatalf("expected the token to be removed, got %v %v", removed, err)
+	}
+	if token, _ := s.GetToken(context.Background(), "notion"); token != nil {
+		t.Error("expected no token after logout")
+	}
+	if removed, err := s.DeleteToken(context.Background(), "notion"); err != nil || removed {
+		t.Errorf("expected nothing left to remove, got %v %v", removed, err)
+	}
+}
+
+func TestListHistoryHonorsCancelledContext(t *testing.T) {
+	s := openTestStore(t)
+	insertCalls(t, s, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.ListHistory(ctx, HistoryQuery{Limit: 10}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if err := s.InsertHistory(ctx, protocol.HistoryItem{At: time.Now(), Server: "notion", Tool: "search"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected insert to be cancelled, got %v", err)
+	}
+}
+
+func TestStreamHistoryPagesByID(t *testing.T) {
+	batch := historyStreamBatch
+	historyStreamBatch = 2
+	defer func() { historyStreamBatch = batch }()
+
+	s := openTestStore(t)
+	insertCalls(t, s, 5)
+	if err := s.InsertHistory(context.Background(), protocol.HistoryItem{At: time.Now(), Server: "linear", Tool: "search"}); err != nil {
+		t.Fatalf("insert history: %v", err)
+	}
+
+	var got []protocol.HistoryItem
+	err := s.StreamHistory(context.Background(), HistoryQuery{Server: "notion"}, func(item protocol.HistoryItem) error {
+		got = append(got, item)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stream history: %v", err)
+	}
+	if ids := historyIDs(got); !slices.Equal(ids, []int64{1, 2, 3, 4, 5}) {
+		t.Fatalf("expected every notion entry oldest first, got %v", ids)
+	}
+
+	stop := errors.New("stop")
+	seen := 0
+	err = s.StreamHistory(context.Background(), HistoryQuery{}, func(protocol.HistoryItem) error {
+		seen++
+		if seen == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || seen != 3 {
+		t.Fatalf("expected the callback error to stop the stream after 3, got %v after %d", err, seen)
+	}
+}
+
+func TestOpenReadOnlyListsWithoutWriting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	insertCalls(t, s, 3)
+	s.Close()
+
+	ro, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("open read-only: %v", err)
+	}
+	defer ro.Close()
+	items, err := ro.ListHistory(context.Background(), HistoryQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("list history: %v", err)
+	}
+	if ids := historyIDs(items); !slices.Equal(ids, []int64{2, 3}) {
+		t.Fatalf("expected the newest two entries, got %v", ids)
+	}
+	if err := ro.InsertHistory(context.Background(), protocol.HistoryItem{At: time.Now(), Server: "notion", Tool: "search"}); err == nil {
+		t.Error("expected a read-only store to refuse writes")
+	}
+	if _, err := OpenReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("expected a missing file to fail instead of creating it")
+	}
+}
+
+func TestInsertHistoryTruncatesLargeArgs(t *testing.T) {
+	s := openTestStore(t)
+	ctx := context.Background()
+	// {"query":"roadmap notes"} encodes to 25 bytes
+	args := map[string]interface{}{"query": "roadmap notes"}
+	for _, limit := range []int{10, 25, 100} {
+		s.SetHistoryMaxArgsBytes(limit)
+		if err := s.InsertHistory(ctx, protocol.HistoryItem{At: time.Now(), Server: "notion", Tool: "search", Args: args, Success: true}); err != nil {
+			t.Fatalf("insert history: %v", err)
+		}
+	}
+
+	items, err := s.ListHistory(ctx, HistoryQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(items))
+	}
+	byID := map[int64]protocol.HistoryItem{}
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	// ids follow the inserts: a cap of 10 truncates, 25 and 100 keep the args
+	for _, id := range []int64{2, 3} {
+		if item := byID[id]; item.ArgsTruncated || item.Args["query"] != "roadmap notes" {
+			t.Errorf("expected args within the cap to be kept, got %+v", item)
+		}
+	}
+	truncated := byID[1]
+	if !truncated.ArgsTruncated {
+		t.Fatalf("expected args over the cap to be marked truncated, got %+v", truncated)
+	}
+	if truncated.Args[truncatedArgsBytesKey] != float64(25) || truncated.Args[truncatedArgsPreviewKey] != `{"query":"` {
+		t.Errorf("unexpected truncation marker: %v", truncated.Args)
+	}
+}